@@ -1,20 +1,53 @@
 package lib
 
 import (
+	"encoding/hex"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 type UniqueID struct {
-	Val string // is a number, but we don't bother to parse it
+	Val  string // is a number, but we don't bother to parse it
+	Span Span
+
+	// Pending is for an ID written as `@new`, which is a request for
+	// `snowpc newid --assign` to make one up. Val is empty until it has.
+	Pending bool
 }
 
 func (u UniqueID) IsSet() bool  { return u.Val != "" }
 func (u UniqueID) IsZero() bool { return u.Val == "" }
 
+// statementUniqueID returns the unique ID of s, for the kinds of statement
+// that can have one.
+func statementUniqueID(s Statement) (UniqueID, bool) {
+	switch s := s.(type) {
+	case Typedef:
+		return s.UniqueID, true
+	case Struct:
+		return s.UniqueID, true
+	case Variant:
+		return s.UniqueID, true
+	case Protocol:
+		return s.UniqueID, true
+	}
+	return UniqueID{}, false
+}
+
 type Root struct {
-	Id    UniqueID
-	Stmts []Statement
+	Id       UniqueID
+	Stmts    []Statement
+	Comments []Comment // in source order
+	Filename string
+	Span     Span
+}
+
+// Comment is a // or /* */ comment, including its delimiters. Docstrings
+// (/** */) aren't comments; they're part of the declaration they precede.
+type Comment struct {
+	Text string
+	Span Span
 }
 
 func (r *Root) DoInventory(i *Inventory) {
@@ -25,35 +58,82 @@ func (r *Root) DoInventory(i *Inventory) {
 
 type Statement interface {
 	DoInventory(i *Inventory)
+	GetSpan() Span
 	emit(e Emitter)
 }
 
 type BaseStatement struct {
-	Dec Decorators
+	Dec  Decorators
+	Span Span
 }
 
+func (b BaseStatement) GetSpan() Span { return b.Span }
+
 type BaseTypedef struct {
 	BaseStatement
 	Ident    Identifier
 	UniqueID UniqueID
 }
 
+// Import is an `import`, `go:import` or `ts:import` statement. A generic
+// `import` names another .snowp package, whose types are checked. Its Go
+// import path is worked out from the go.mod above where its Go code is
+// generated; when there's no go.mod there, or the package is only available
+// in the target language, the file needs a `go:import` (or `ts:import`) under
+// the same name, which is used instead. A project config's go-import-paths
+// can supply the go:import for every file at once. TypeScript output always
+// needs a ts:import, since there's no working out which generated module a
+// type is in.
 type Import struct {
 	Path string
 	Name string
 	Lang Language
+	Span Span
+	Pkg  *Package // set by Resolve for generic imports
+}
+
+func (i Import) GetSpan() Span { return i.Span }
+
+// GoPackage is a `go:package name;` directive. It names the Go package that
+// the file's directory compiles into, in place of the one snowpc would pick.
+type GoPackage struct {
+	Name Identifier
+	Span Span
 }
 
+func (g GoPackage) GetSpan() Span            { return g.Span }
+func (g GoPackage) DoInventory(i *Inventory) {}
+
 type Typedef struct {
 	BaseTypedef
 	Type Type
 }
 
 func (b BaseTypedef) DoInventory(i *Inventory) { b.UniqueID.DoInventory(i) }
-func (t Typedef) DoInventory(i *Inventory)     { i.Typedef = true; t.BaseTypedef.DoInventory(i) }
-func (s Struct) DoInventory(i *Inventory)      { i.Struct = true; s.BaseTypedef.DoInventory(i) }
-func (v Variant) DoInventory(i *Inventory)     { i.Variant = true; v.BaseTypedef.DoInventory(i) }
-func (b Import) DoInventory(i *Inventory)      { i.Import = true }
+
+func (t Typedef) DoInventory(i *Inventory) {
+	i.Typedef = true
+	t.BaseTypedef.DoInventory(i)
+	typeInventory(t.Type, i)
+}
+
+func (s Struct) DoInventory(i *Inventory) {
+	i.Struct = true
+	s.BaseTypedef.DoInventory(i)
+	for _, f := range s.Fields {
+		typeInventory(f.Type, i)
+	}
+}
+
+func (v Variant) DoInventory(i *Inventory) {
+	i.Variant = true
+	v.BaseTypedef.DoInventory(i)
+	for _, c := range v.Cases {
+		typeInventory(c.Type, i)
+	}
+}
+
+func (b Import) DoInventory(i *Inventory) { i.Import = true }
 func (u UniqueID) DoInventory(i *Inventory) {
 	if u.IsSet() {
 		i.Unique = true
@@ -65,11 +145,13 @@ type Decorators struct {
 }
 
 type Docstring struct {
-	Raw string
+	Raw  string
+	Span Span
 }
 
 type Identifier struct {
 	Name string
+	Span Span
 }
 
 type Type interface {
@@ -85,9 +167,11 @@ type Type interface {
 	DerivedPrefix() string
 	EnumPrefix() string
 	IsVoid() bool
+	GetSpan() Span
 }
 
 type BaseType struct {
+	Span Span
 }
 
 type Future struct {
@@ -100,32 +184,54 @@ type List struct {
 	Type Type
 }
 
+// Map is an unordered set of key/value pairs. It goes over the wire as a
+// list of [key, value] pairs, sorted by key so that the encoding is stable.
+type Map struct {
+	BaseType
+	Key   Type
+	Value Type
+}
+
 type Blob struct {
 	BaseType
-	Count int
+	Count      int
+	CountConst Identifier // if set, Resolve fills in Count from this constant
 }
 
 type DerivedType struct {
 	BaseType
 	Name         Identifier
 	ImportedFrom Identifier
+	Sym          *Symbol // set by Resolve; nil if declared in a foreign (e.g. go:) import
 }
 
 type Field struct {
 	Ident Identifier
 	Pos   int
 	Type  Type
+	Span  Span
 }
 
 type Struct struct {
 	BaseTypedef
-	Fields []Field
+	Fields   []Field
+	Reserved []Reserved
+}
+
+// Reserved is one entry of a `reserved @3, oldName;` clause. It marks a
+// position or a name that was used once, and mustn't be used again with a
+// different meaning, since old peers might still send it.
+type Reserved struct {
+	Pos  *int // nil if this reserves a name
+	Name string
+	Span Span
 }
 
 type CaseLabel interface {
 	CaseLabelToString(e Emitter, switchType Type) string
 	GetterMethodName(e Emitter) string
 	ConstructorName(e Emitter, swtch string) string
+	GetSpan() Span
 }
 
 type CaseLabelIdentifier struct {
@@ -144,8 +250,11 @@ func (c CaseLabelIdentifier) ConstructorName(e Emitter, swtch string) string {
 	return e.ConstructorNameForConstant(swtch, c.Ident.Name)
 }
 
+func (c CaseLabelIdentifier) GetSpan() Span { return c.Ident.Span }
+
 type CaseLabelNumber struct {
-	Num int
+	Num  int
+	Span Span
 }
 
 func (c CaseLabelNumber) CaseLabelToString(e Emitter, switchType Type) string {
@@ -160,8 +269,11 @@ func (c CaseLabelNumber) ConstructorName(e Emitter, swtch string) string {
 	return e.ConstructorNameForInt(swtch, c.Num)
 }
 
+func (c CaseLabelNumber) GetSpan() Span { return c.Span }
+
 type CaseLabelBool struct {
 	Bool bool
+	Span Span
 }
 
 func (c CaseLabelBool) CaseLabelToString(e Emitter, switchType Type) string {
@@ -179,10 +291,13 @@ func (c CaseLabelBool) ConstructorName(e Emitter, swtch string) string {
 	return e.ConstructorNameForBool(swtch, c.Bool)
 }
 
+func (c CaseLabelBool) GetSpan() Span { return c.Span }
+
 type Case struct {
 	Labels   []CaseLabel // nil for default case
 	Position *int        // will be nil for void data; 0 is a valid position
 	Type     Type
+	Span     Span
 }
 
 func (c Case) HasData() bool { return !c.Type.IsVoid() }
@@ -192,6 +307,7 @@ type Variant struct {
 	SwitchVar  Identifier
 	SwitchType Type
 	Cases      []Case
+	Reserved   []Reserved
 }
 
 type Option struct {
@@ -204,8 +320,10 @@ type Void struct {
 }
 
 type EnumValue struct {
-	Ident Identifier
-	Num   int
+	Ident    Identifier
+	Num      int
+	NumConst Identifier // if set, Resolve fills in Num from this constant
+	Span     Span
 }
 
 type Enum struct {
@@ -213,10 +331,81 @@ type Enum struct {
 	Values []EnumValue
 }
 
+// Const is a named constant, like `const MaxNameLen : Uint = 64;`. Integer
+// constants can also stand in for Blob sizes and enum values.
+type Const struct {
+	BaseStatement
+	Ident Identifier
+	Type  Type
+	Value Literal
+}
+
+func (c Const) DoInventory(i *Inventory) {}
+
+type LiteralKind int
+
+const (
+	LiteralInt  LiteralKind = iota // decimal, possibly negative
+	LiteralHex                     // 0x-prefixed; a number, or the bytes of a Blob
+	LiteralText                    // Raw doesn't include the quotes
+	LiteralBool
+)
+
+type Literal struct {
+	Kind LiteralKind
+	Raw  string
+	Span Span
+}
+
+// Int64 reads an integer literal as a signed number.
+func (l Literal) Int64() (int64, error) {
+	if l.Kind == LiteralHex {
+		digits, err := l.hexDigits()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(digits, 16, 64)
+	}
+	return strconv.ParseInt(l.Raw, 10, 64)
+}
+
+// Uint64 reads an integer literal as an unsigned number.
+func (l Literal) Uint64() (uint64, error) {
+	if l.Kind == LiteralHex {
+		digits, err := l.hexDigits()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(digits, 16, 64)
+	}
+	return strconv.ParseUint(l.Raw, 10, 64)
+}
+
+// Bytes reads a hex literal as the bytes of a Blob.
+func (l Literal) Bytes() ([]byte, error) {
+	digits, err := l.hexDigits()
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(digits)
+}
+
+// hexDigits returns what comes after the 0x of a hex literal. The lexer
+// makes sure there's something there, but a Literal can come from elsewhere,
+// like UnmarshalAST.
+func (l Literal) hexDigits() (string, error) {
+	digits, ok := strings.CutPrefix(l.Raw, "0x")
+	if !ok || digits == "" {
+		return "", fmt.Errorf("malformed hex literal %q", l.Raw)
+	}
+	return digits, nil
+}
+
 type Protocol struct {
 	BaseTypedef
 	Modifiers ProtocolModifiers
 	Methods   []Method
+	Reserved  []Reserved
 }
 
 type ProtocolModifier interface {
@@ -225,6 +414,12 @@ type ProtocolModifier interface {
 func (p Protocol) DoInventory(i *Inventory) {
 	i.Rpc = true
 	p.BaseTypedef.DoInventory(i)
+	for _, m := range p.Methods {
+		for _, prm := range m.Params {
+			typeInventory(prm.Type, i)
+		}
+		typeInventory(m.ResType, i)
+	}
 }
 
 func NewProtocolModifiers(pms []ProtocolModifier) (*ProtocolModifiers, error) {
@@ -270,20 +465,24 @@ type ProtocolModifiers struct {
 
 type Errors struct {
 	Type Type
+	Span Span
 }
 
 type ArgHeader struct {
 	Type Type
+	Span Span
 }
 
 type ResHeader struct {
 	Type Type
+	Span Span
 }
 
 type Param struct {
 	Ident Identifier
 	Type  Type
 	Pos   int
+	Span  Span
 }
 
 func (p Param) ToField() Field {
@@ -291,6 +490,7 @@ func (p Param) ToField() Field {
 		Ident: p.Ident,
 		Pos:   p.Pos,
 		Type:  p.Type,
+		Span:  p.Span,
 	}
 }
 
@@ -302,16 +502,32 @@ type Method struct {
 	ResType Type
 }
 
+// ParamsToStruct makes the struct that carries m's arguments. There's no way
+// to write `reserved` in a parameter list, so any gaps in the positions are
+// taken as reserved.
 func (m Method) ParamsToStruct(n string) Struct {
 	var fields []Field
+	var reserved []Reserved
+	used := make(map[int]bool)
+	last := -1
 	for _, p := range m.Params {
 		fields = append(fields, p.ToField())
+		used[p.Pos] = true
+		last = max(last, p.Pos)
+	}
+	for i := 0; i < last; i++ {
+		if !used[i] {
+			pos := i
+			reserved = append(reserved, Reserved{Pos: &pos, Span: m.Span})
+		}
 	}
 	return Struct{
 		BaseTypedef: BaseTypedef{
-			Ident: Identifier{Name: n},
+			BaseStatement: BaseStatement{Span: m.Span},
+			Ident:         Identifier{Name: n, Span: m.Ident.Span},
 		},
-		Fields: fields,
+		Fields:   fields,
+		Reserved: reserved,
 	}
 }
 
@@ -328,6 +544,42 @@ type Bool struct {
 	BaseType
 }
 
+// SizedInt is one of Uint8, Uint16, Uint32, Int8, Int16 or Int32. Unlike Uint
+// and Int, values that don't fit are rejected when decoding.
+type SizedInt struct {
+	BaseType
+	Bits   int
+	Signed bool
+}
+
+// Float is Float32 or Float64.
+type Float struct {
+	BaseType
+	Bits int
+}
+
+// Min and Max give the range of values that s can hold.
+func (s SizedInt) Min() int64 {
+	if !s.Signed {
+		return 0
+	}
+	return -(1 << (s.Bits - 1))
+}
+
+func (s SizedInt) Max() int64 {
+	if !s.Signed {
+		return 1<<s.Bits - 1
+	}
+	return 1<<(s.Bits-1) - 1
+}
+
+func (s SizedInt) Name() string {
+	if s.Signed {
+		return fmt.Sprintf("Int%d", s.Bits)
+	}
+	return fmt.Sprintf("Uint%d", s.Bits)
+}
+
 var _ CaseLabel = CaseLabelIdentifier{}
 var _ CaseLabel = CaseLabelNumber{}
 var _ CaseLabel = CaseLabelBool{}
@@ -335,13 +587,18 @@ var _ Statement = Typedef{}
 var _ Statement = Enum{}
 var _ Statement = Variant{}
 var _ Statement = Protocol{}
+var _ Statement = Const{}
+var _ Statement = GoPackage{}
 var _ Type = List{}
+var _ Type = Map{}
 var _ Type = Future{}
 var _ Type = Blob{}
 var _ Type = Text{}
 var _ Type = Uint{}
 var _ Type = Int{}
 var _ Type = Bool{}
+var _ Type = SizedInt{}
+var _ Type = Float{}
 var _ Type = Option{}
 var _ Type = Void{}
 var _ Type = DerivedType{}
@@ -351,78 +608,99 @@ var _ ProtocolModifier = ResHeader{}
 
 func (v Void) Emit(e Emitter)        { e.EmitVoid(v) }
 func (l List) Emit(e Emitter)        { e.EmitList(l) }
+func (m Map) Emit(e Emitter)         { e.EmitMap(m) }
 func (f Future) Emit(e Emitter)      { e.EmitFuture(f) }
 func (b Blob) Emit(e Emitter)        { e.EmitBlob(b) }
 func (t Text) Emit(e Emitter)        { e.EmitText(t) }
 func (u Uint) Emit(e Emitter)        { e.EmitUint(u) }
 func (i Int) Emit(e Emitter)         { e.EmitInt(i) }
 func (b Bool) Emit(e Emitter)        { e.EmitBool(b) }
+func (s SizedInt) Emit(e Emitter)    { e.EmitSizedInt(s) }
+func (f Float) Emit(e Emitter)       { e.EmitFloat(f) }
 func (o Option) Emit(e Emitter)      { e.EmitOption(o) }
 func (d DerivedType) Emit(e Emitter) { e.EmitDerivedType(d) }
 
 func (v Void) EmitInternal(e Emitter)        { e.EmitVoid(v) }
 func (l List) EmitInternal(e Emitter)        { e.EmitListInternal(l) }
+func (m Map) EmitInternal(e Emitter)         { e.EmitMapInternal(m) }
 func (f Future) EmitInternal(e Emitter)      { e.EmitFuture(f) }
 func (b Blob) EmitInternal(e Emitter)        { e.EmitBlob(b) }
 func (t Text) EmitInternal(e Emitter)        { e.EmitText(t) }
 func (u Uint) EmitInternal(e Emitter)        { e.EmitUint(u) }
 func (i Int) EmitInternal(e Emitter)         { e.EmitInt(i) }
 func (b Bool) EmitInternal(e Emitter)        { e.EmitBool(b) }
+func (s SizedInt) EmitInternal(e Emitter)    { e.EmitSizedInt(s) }
+func (f Float) EmitInternal(e Emitter)       { e.EmitFloat(f) }
 func (o Option) EmitInternal(e Emitter)      { e.EmitOptionInternal(o) }
 func (d DerivedType) EmitInternal(e Emitter) { e.EmitDerivedTypeInternal(d) }
 
 func (v Void) IsPrimitiveType() bool        { return false }
 func (l List) IsPrimitiveType() bool        { return false }
+func (m Map) IsPrimitiveType() bool         { return false }
 func (f Future) IsPrimitiveType() bool      { return true }
 func (b Blob) IsPrimitiveType() bool        { return true }
 func (t Text) IsPrimitiveType() bool        { return true }
 func (u Uint) IsPrimitiveType() bool        { return true }
 func (i Int) IsPrimitiveType() bool         { return true }
 func (b Bool) IsPrimitiveType() bool        { return true }
+func (s SizedInt) IsPrimitiveType() bool    { return true }
+func (f Float) IsPrimitiveType() bool       { return true }
 func (o Option) IsPrimitiveType() bool      { return false }
 func (d DerivedType) IsPrimitiveType() bool { return false }
 
 func (v Void) EmitExport(e Emitter, nm string)        { e.EmitVoid(v) }
 func (l List) EmitExport(e Emitter, nm string)        { e.EmitExportList(l, nm) }
+func (m Map) EmitExport(e Emitter, nm string)         { e.EmitExportMap(m, nm) }
 func (b Blob) EmitExport(e Emitter, nm string)        { e.EmitExportBlob(b, nm) }
 func (t Text) EmitExport(e Emitter, nm string)        { e.EmitExportText(t, nm) }
 func (u Uint) EmitExport(e Emitter, nm string)        { e.EmitExportUint(u, nm) }
 func (i Int) EmitExport(e Emitter, nm string)         { e.EmitExportInt(i, nm) }
 func (b Bool) EmitExport(e Emitter, nm string)        { e.EmitExportBool(b, nm) }
+func (s SizedInt) EmitExport(e Emitter, nm string)    { e.EmitExportSizedInt(s, nm) }
+func (f Float) EmitExport(e Emitter, nm string)       { e.EmitExportFloat(f, nm) }
 func (f Future) EmitExport(e Emitter, nm string)      { e.EmitExportFuture(f, nm) }
 func (o Option) EmitExport(e Emitter, nm string)      { e.EmitExportOption(o, nm) }
 func (d DerivedType) EmitExport(e Emitter, nm string) { e.EmitExportDerivedType(d, nm) }
 
 func (v Void) EmitImport(e Emitter, nm string)        {}
 func (l List) EmitImport(e Emitter, nm string)        { e.EmitImportList(l, nm) }
+func (m Map) EmitImport(e Emitter, nm string)         { e.EmitImportMap(m, nm) }
 func (b Blob) EmitImport(e Emitter, nm string)        { e.EmitImportBlob(b, nm) }
 func (t Text) EmitImport(e Emitter, nm string)        { e.EmitImportText(t, nm) }
 func (u Uint) EmitImport(e Emitter, nm string)        { e.EmitImportUint(u, nm) }
 func (i Int) EmitImport(e Emitter, nm string)         { e.EmitImportInt(i, nm) }
 func (b Bool) EmitImport(e Emitter, nm string)        { e.EmitImportBool(b, nm) }
+func (s SizedInt) EmitImport(e Emitter, nm string)    { e.EmitImportSizedInt(s, nm) }
+func (f Float) EmitImport(e Emitter, nm string)       { e.EmitImportFloat(f, nm) }
 func (f Future) EmitImport(e Emitter, nm string)      { e.EmitImportFuture(f, nm) }
 func (o Option) EmitImport(e Emitter, nm string)      { e.EmitImportOption(o, nm) }
 func (d DerivedType) EmitImport(e Emitter, nm string) { e.EmitImportDerivedType(d, nm) }
 
 func (v Void) EmitBytes(e Emitter, nm string)        { e.EmitNil() }
 func (l List) EmitBytes(e Emitter, nm string)        { e.EmitNil() }
+func (m Map) EmitBytes(e Emitter, nm string)         { e.EmitNil() }
 func (f Future) EmitBytes(e Emitter, nm string)      { e.EmitBlobToBytes(nm) }
 func (b Blob) EmitBytes(e Emitter, nm string)        { e.EmitBlobToBytes(nm) }
 func (t Text) EmitBytes(e Emitter, nm string)        { e.EmitNil() }
 func (u Uint) EmitBytes(e Emitter, nm string)        { e.EmitNil() }
 func (i Int) EmitBytes(e Emitter, nm string)         { e.EmitNil() }
 func (b Bool) EmitBytes(e Emitter, nm string)        { e.EmitNil() }
+func (s SizedInt) EmitBytes(e Emitter, nm string)    { e.EmitNil() }
+func (f Float) EmitBytes(e Emitter, nm string)       { e.EmitNil() }
 func (o Option) EmitBytes(e Emitter, nm string)      { e.EmitNil() }
 func (d DerivedType) EmitBytes(e Emitter, nm string) { e.EmitBytesDowncast(d.FullTypeName(), nm) }
 
 func (v Void) MakeOptional() Type        { return Option{Type: v} }
 func (l List) MakeOptional() Type        { return Option{Type: l} }
+func (m Map) MakeOptional() Type         { return Option{Type: m} }
 func (f Future) MakeOptional() Type      { return Option{Type: f} }
 func (b Blob) MakeOptional() Type        { return Option{Type: b} }
 func (t Text) MakeOptional() Type        { return Option{Type: t} }
 func (u Uint) MakeOptional() Type        { return Option{Type: u} }
 func (i Int) MakeOptional() Type         { return Option{Type: i} }
 func (b Bool) MakeOptional() Type        { return Option{Type: b} }
+func (s SizedInt) MakeOptional() Type    { return Option{Type: s} }
+func (f Float) MakeOptional() Type       { return Option{Type: f} }
 func (o Option) MakeOptional() Type      { return o }
 func (d DerivedType) MakeOptional() Type { return Option{Type: d} }
 
@@ -431,10 +709,15 @@ func (b BaseType) DerivedPrefix() string                  { return "" }
 func (b BaseType) EnumPrefix() string                     { return "" }
 func (b BaseType) IsVoid() bool                           { return false }
 func (b BaseType) IsList() bool                           { return false }
+func (b BaseType) GetSpan() Span                          { return b.Span }
 
 func (v Void) IsVoid() bool { return true }
 func (l List) IsList() bool { return true }
 
+// IsList is true for maps too, since they're sent as lists of pairs, and like
+// lists, they have no Export and Import methods of their own.
+func (m Map) IsList() bool { return true }
+
 func (d DerivedType) DerivedPrefix() string {
 	if d.ImportedFrom.Name != "" {
 		return d.ImportedFrom.Name + "."
@@ -450,6 +733,70 @@ func (f Future) EmitFutureLink(e Emitter, child string) {
 	e.EmitFutureLink(f.Type, child)
 }
 
+// walkType calls f on t and on every type nested inside of it.
+func walkType(t Type, f func(Type)) {
+	f(t)
+	switch t := t.(type) {
+	case List:
+		walkType(t.Type, f)
+	case Option:
+		walkType(t.Type, f)
+	case Future:
+		walkType(t.Type, f)
+	case Map:
+		walkType(t.Key, f)
+		walkType(t.Value, f)
+	}
+}
+
+// typeInventory notes the parts of t that need extra imports.
+func typeInventory(t Type, i *Inventory) {
+	if t == nil {
+		return
+	}
+	walkType(t, func(t Type) {
+		m, ok := t.(Map)
+		if !ok {
+			return
+		}
+		i.Map = true
+		if _, isBlob := Underlying(m.Key).(Blob); isBlob {
+			i.BlobKeyMap = true
+		}
+	})
+}
+
+// maxTypedefDepth bounds how far Underlying will chase typedefs, in case of
+// a cycle.
+const maxTypedefDepth = 64
+
+// Underlying follows typedefs to find the type that d is ultimately defined
+// as. Structs, variants and enums are their own underlying type, as are
+// types that haven't been resolved.
+func (d DerivedType) Underlying() Type {
+	var t Type = d
+	for i := 0; i < maxTypedefDepth; i++ {
+		dt, ok := t.(DerivedType)
+		if !ok || dt.Sym == nil {
+			return t
+		}
+		td, ok := dt.Sym.Decl().(Typedef)
+		if !ok {
+			return t
+		}
+		t = td.Type
+	}
+	return t
+}
+
+// Underlying returns the underlying type of t; see DerivedType.Underlying.
+func Underlying(t Type) Type {
+	if d, ok := t.(DerivedType); ok {
+		return d.Underlying()
+	}
+	return t
+}
+
 func (d DerivedType) FullTypeName() string {
 	var parts []string
 	if d.ImportedFrom.Name != "" {
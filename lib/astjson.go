@@ -0,0 +1,950 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ASTVersion is the version of the JSON syntax tree format that MarshalAST
+// writes and UnmarshalAST reads. It changes whenever a document written by
+// one version might mean something different to, or be rejected by, another.
+//
+// A document is an object with the version, the filename, the file's unique
+// ID, its statements and its comments. Statements and types are objects with
+// a "kind" field that says which fields go with them; see jsonStmt and
+// jsonType. Spans, where known, are {"start": pos, "end": pos}, with each pos
+// having a 1-based line and column, and a 0-based byte offset.
+const ASTVersion = 1
+
+type jsonRoot struct {
+	Version    int           `json:"version"`
+	Filename   string        `json:"filename"`
+	ID         *jsonID       `json:"id,omitempty"`
+	Span       *jsonSpan     `json:"span,omitempty"`
+	Statements []jsonStmt    `json:"statements"`
+	Comments   []jsonComment `json:"comments,omitempty"`
+}
+
+type jsonPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+type jsonSpan struct {
+	Start jsonPos `json:"start"`
+	End   jsonPos `json:"end"`
+}
+
+type jsonID struct {
+	Value   string    `json:"value,omitempty"`
+	Pending bool      `json:"pending,omitempty"` // written as @new
+	Span    *jsonSpan `json:"span,omitempty"`
+}
+
+type jsonIdent struct {
+	Name string    `json:"name"`
+	Span *jsonSpan `json:"span,omitempty"`
+}
+
+type jsonDoc struct {
+	Raw  string    `json:"raw"`
+	Span *jsonSpan `json:"span,omitempty"`
+}
+
+type jsonComment struct {
+	Text string    `json:"text"`
+	Span *jsonSpan `json:"span,omitempty"`
+}
+
+// jsonStmt is any statement. Kind is one of "struct", "typedef", "enum",
+// "variant", "protocol", "const", "import" or "go:package", and the fields
+// that don't apply to it are left out.
+type jsonStmt struct {
+	Kind string     `json:"kind"`
+	Name *jsonIdent `json:"name,omitempty"`
+	ID   *jsonID    `json:"id,omitempty"`
+	Doc  *jsonDoc   `json:"doc,omitempty"`
+	Span *jsonSpan  `json:"span,omitempty"`
+
+	Type      *jsonType       `json:"type,omitempty"`   // typedef, const
+	Value     *jsonLiteral    `json:"value,omitempty"`  // const
+	Fields    []jsonField     `json:"fields,omitempty"` // struct
+	Values    []jsonEnumValue `json:"values,omitempty"` // enum
+	Switch    *jsonSwitch     `json:"switch,omitempty"` // variant
+	Cases     []jsonCase      `json:"cases,omitempty"`  // variant
+	Errors    *jsonModifier   `json:"errors,omitempty"` // protocol
+	ArgHeader *jsonModifier   `json:"argHeader,omitempty"`
+	ResHeader *jsonModifier   `json:"resHeader,omitempty"`
+	Methods   []jsonMethod    `json:"methods,omitempty"`
+	Reserved  []jsonReserved  `json:"reserved,omitempty"` // struct, variant, protocol
+
+	Path string `json:"path,omitempty"` // import
+	As   string `json:"as,omitempty"`   // import
+	Lang string `json:"lang,omitempty"` // import: "generic", "go" or "ts"
+}
+
+// jsonType is any type. Kind is "text", "uint", "int", "bool", "uint8",
+// "uint16", "uint32", "int8", "int16", "int32", "float32", "float64",
+// "blob", "list", "map", "option", "future", "void" or "named".
+type jsonType struct {
+	Kind string    `json:"kind"`
+	Span *jsonSpan `json:"span,omitempty"`
+
+	Count      int        `json:"count,omitempty"`      // blob
+	CountConst *jsonIdent `json:"countConst,omitempty"` // blob
+	Type       *jsonType  `json:"type,omitempty"`       // list, option, future
+	Key        *jsonType  `json:"key,omitempty"`        // map
+	Value      *jsonType  `json:"value,omitempty"`      // map
+	Name       *jsonIdent `json:"name,omitempty"`       // named
+	From       *jsonIdent `json:"from,omitempty"`       // named, if imported
+}
+
+type jsonField struct {
+	Name     jsonIdent `json:"name"`
+	Position int       `json:"position"`
+	Type     *jsonType `json:"type"`
+	Span     *jsonSpan `json:"span,omitempty"`
+}
+
+type jsonReserved struct {
+	Position *int      `json:"position,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Span     *jsonSpan `json:"span,omitempty"`
+}
+
+type jsonEnumValue struct {
+	Name       jsonIdent  `json:"name"`
+	Value      int        `json:"value"`
+	ValueConst *jsonIdent `json:"valueConst,omitempty"`
+	Span       *jsonSpan  `json:"span,omitempty"`
+}
+
+type jsonSwitch struct {
+	Name jsonIdent `json:"name"`
+	Type *jsonType `json:"type"`
+}
+
+type jsonCase struct {
+	Default  bool        `json:"default,omitempty"`
+	Labels   []jsonLabel `json:"labels,omitempty"`
+	Position *int        `json:"position,omitempty"`
+	Type     *jsonType   `json:"type"`
+	Span     *jsonSpan   `json:"span,omitempty"`
+}
+
+// jsonLabel is a case label. Kind is "name", "number" or "bool".
+type jsonLabel struct {
+	Kind   string     `json:"kind"`
+	Name   *jsonIdent `json:"name,omitempty"`
+	Number *int       `json:"number,omitempty"`
+	Bool   *bool      `json:"bool,omitempty"`
+	Span   *jsonSpan  `json:"span,omitempty"`
+}
+
+type jsonModifier struct {
+	Type *jsonType `json:"type"`
+	Span *jsonSpan `json:"span,omitempty"`
+}
+
+type jsonMethod struct {
+	Name     jsonIdent   `json:"name"`
+	ID       *jsonID     `json:"id,omitempty"`
+	Doc      *jsonDoc    `json:"doc,omitempty"`
+	Position int         `json:"position"`
+	Params   []jsonParam `json:"params,omitempty"`
+	ArgType  *jsonIdent  `json:"argType,omitempty"`
+	Result   *jsonType   `json:"result"`
+	Span     *jsonSpan   `json:"span,omitempty"`
+}
+
+type jsonParam struct {
+	Name     jsonIdent `json:"name"`
+	Position int       `json:"position"`
+	Type     *jsonType `json:"type"`
+	Span     *jsonSpan `json:"span,omitempty"`
+}
+
+// jsonLiteral is a constant's value. Kind is "int", "hex", "text" or "bool",
+// and Raw is how it was written, without the quotes for text.
+type jsonLiteral struct {
+	Kind string    `json:"kind"`
+	Raw  string    `json:"raw"`
+	Span *jsonSpan `json:"span,omitempty"`
+}
+
+// MarshalAST writes r, as it came from Parse, as a JSON document in the
+// ASTVersion format. What Resolve adds to the tree isn't included.
+func MarshalAST(r *Root) ([]byte, error) {
+	jr := jsonRoot{
+		Version:    ASTVersion,
+		Filename:   r.Filename,
+		ID:         idToJSON(r.Id),
+		Span:       spanToJSON(r.Span),
+		Statements: []jsonStmt{},
+	}
+	for _, s := range r.Stmts {
+		js, err := stmtToJSON(s)
+		if err != nil {
+			return nil, err
+		}
+		jr.Statements = append(jr.Statements, js)
+	}
+	for _, c := range r.Comments {
+		jr.Comments = append(jr.Comments, jsonComment{Text: c.Text, Span: spanToJSON(c.Span)})
+	}
+	return json.MarshalIndent(jr, "", "  ")
+}
+
+// UnmarshalAST reads a JSON document written by MarshalAST, or by another
+// tool in the same format, back into a Root. The result is what Parse would
+// have returned, so it can be resolved, checked and emitted just the same.
+// Names, numbers and literals that Parse couldn't have produced are errors,
+// since the emitters copy them into the generated code as they are.
+func UnmarshalAST(dat []byte) (*Root, error) {
+	var jr jsonRoot
+	dec := json.NewDecoder(bytes.NewReader(dat))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&jr)
+	if err != nil {
+		return nil, err
+	}
+	if jr.Version != ASTVersion {
+		return nil, fmt.Errorf("unsupported AST version %d (want %d)", jr.Version, ASTVersion)
+	}
+	r := &Root{
+		Id:       idFromJSON(jr.ID),
+		Filename: jr.Filename,
+		Span:     spanFromJSON(jr.Span),
+	}
+	for i, js := range jr.Statements {
+		s, err := stmtFromJSON(js)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %w", i, err)
+		}
+		r.Stmts = append(r.Stmts, s)
+	}
+	for _, c := range jr.Comments {
+		r.Comments = append(r.Comments, Comment{Text: c.Text, Span: spanFromJSON(c.Span)})
+	}
+	return r, nil
+}
+
+// RunAST parses f and writes its syntax tree to w, in the format described
+// at ASTVersion.
+func RunAST(f *Infile, w io.Writer) error {
+	dat, err := f.Read()
+	if err != nil {
+		return err
+	}
+	r, err := Parse(dat, f.Name())
+	if err != nil {
+		return err
+	}
+	out, err := MarshalAST(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
+
+func spanToJSON(s Span) *jsonSpan {
+	if !s.IsValid() {
+		return nil
+	}
+	return &jsonSpan{
+		Start: jsonPos{Line: s.Start.Line, Column: s.Start.Column, Offset: s.Start.Offset},
+		End:   jsonPos{Line: s.End.Line, Column: s.End.Column, Offset: s.End.Offset},
+	}
+}
+
+func spanFromJSON(s *jsonSpan) Span {
+	if s == nil {
+		return Span{}
+	}
+	return Span{
+		Start: Pos{Line: s.Start.Line, Column: s.Start.Column, Offset: s.Start.Offset},
+		End:   Pos{Line: s.End.Line, Column: s.End.Column, Offset: s.End.Offset},
+	}
+}
+
+func idToJSON(u UniqueID) *jsonID {
+	if !u.IsSet() && !u.Pending {
+		return nil
+	}
+	return &jsonID{Value: u.Val, Pending: u.Pending, Span: spanToJSON(u.Span)}
+}
+
+func idFromJSON(u *jsonID) UniqueID {
+	if u == nil {
+		return UniqueID{}
+	}
+	return UniqueID{Val: u.Value, Pending: u.Pending, Span: spanFromJSON(u.Span)}
+}
+
+func identToJSON(i Identifier) jsonIdent {
+	return jsonIdent{Name: i.Name, Span: spanToJSON(i.Span)}
+}
+
+// optIdentToJSON is for identifiers that might not be there.
+func optIdentToJSON(i Identifier) *jsonIdent {
+	if i.Name == "" {
+		return nil
+	}
+	ret := identToJSON(i)
+	return &ret
+}
+
+func identFromJSON(i *jsonIdent) Identifier {
+	if i == nil {
+		return Identifier{}
+	}
+	return Identifier{Name: i.Name, Span: spanFromJSON(i.Span)}
+}
+
+func docToJSON(d Decorators) *jsonDoc {
+	if d.Doc.Raw == "" {
+		return nil
+	}
+	return &jsonDoc{Raw: d.Doc.Raw, Span: spanToJSON(d.Doc.Span)}
+}
+
+func docFromJSON(d *jsonDoc) Decorators {
+	if d == nil {
+		return Decorators{}
+	}
+	return Decorators{Doc: Docstring{Raw: d.Raw, Span: spanFromJSON(d.Span)}}
+}
+
+// typedefToJSON starts off the statement for a declaration.
+func typedefToJSON(kind string, b BaseTypedef) jsonStmt {
+	return jsonStmt{
+		Kind: kind,
+		Name: optIdentToJSON(b.Ident),
+		ID:   idToJSON(b.UniqueID),
+		Doc:  docToJSON(b.Dec),
+		Span: spanToJSON(b.Span),
+	}
+}
+
+func typedefFromJSON(js jsonStmt) BaseTypedef {
+	return BaseTypedef{
+		BaseStatement: BaseStatement{Dec: docFromJSON(js.Doc), Span: spanFromJSON(js.Span)},
+		Ident:         identFromJSON(js.Name),
+		UniqueID:      idFromJSON(js.ID),
+	}
+}
+
+var importLangs = map[Language]string{
+	LangGeneric:    "generic",
+	LangGo:         "go",
+	LangTypeScript: "ts",
+}
+
+func stmtToJSON(s Statement) (jsonStmt, error) {
+	switch s := s.(type) {
+	case Struct:
+		js := typedefToJSON("struct", s.BaseTypedef)
+		for _, f := range s.Fields {
+			js.Fields = append(js.Fields, jsonField{
+				Name:     identToJSON(f.Ident),
+				Position: f.Pos,
+				Type:     typeToJSON(f.Type),
+				Span:     spanToJSON(f.Span),
+			})
+		}
+		js.Reserved = reservedToJSON(s.Reserved)
+		return js, nil
+	case Typedef:
+		js := typedefToJSON("typedef", s.BaseTypedef)
+		js.Type = typeToJSON(s.Type)
+		return js, nil
+	case Enum:
+		js := typedefToJSON("enum", s.BaseTypedef)
+		for _, v := range s.Values {
+			js.Values = append(js.Values, jsonEnumValue{
+				Name:       identToJSON(v.Ident),
+				Value:      v.Num,
+				ValueConst: optIdentToJSON(v.NumConst),
+				Span:       spanToJSON(v.Span),
+			})
+		}
+		return js, nil
+	case Variant:
+		js := typedefToJSON("variant", s.BaseTypedef)
+		js.Switch = &jsonSwitch{Name: identToJSON(s.SwitchVar), Type: typeToJSON(s.SwitchType)}
+		for _, c := range s.Cases {
+			jc := jsonCase{
+				Default:  c.Labels == nil,
+				Position: c.Position,
+				Type:     typeToJSON(c.Type),
+				Span:     spanToJSON(c.Span),
+			}
+			for _, l := range c.Labels {
+				jc.Labels = append(jc.Labels, labelToJSON(l))
+			}
+			js.Cases = append(js.Cases, jc)
+		}
+		js.Reserved = reservedToJSON(s.Reserved)
+		return js, nil
+	case Protocol:
+		js := typedefToJSON("protocol", s.BaseTypedef)
+		js.Errors = &jsonModifier{
+			Type: typeToJSON(s.Modifiers.Errors.Type),
+			Span: spanToJSON(s.Modifiers.Errors.Span),
+		}
+		if h := s.Modifiers.ArgHeader; h != nil {
+			js.ArgHeader = &jsonModifier{Type: typeToJSON(h.Type), Span: spanToJSON(h.Span)}
+		}
+		if h := s.Modifiers.ResHeader; h != nil {
+			js.ResHeader = &jsonModifier{Type: typeToJSON(h.Type), Span: spanToJSON(h.Span)}
+		}
+		for _, m := range s.Methods {
+			jm := jsonMethod{
+				Name:     identToJSON(m.Ident),
+				ID:       idToJSON(m.UniqueID),
+				Doc:      docToJSON(m.Dec),
+				Position: m.Pos,
+				ArgType:  optIdentToJSON(m.ArgType),
+				Result:   typeToJSON(m.ResType),
+				Span:     spanToJSON(m.Span),
+			}
+			for _, p := range m.Params {
+				jm.Params = append(jm.Params, jsonParam{
+					Name:     identToJSON(p.Ident),
+					Position: p.Pos,
+					Type:     typeToJSON(p.Type),
+					Span:     spanToJSON(p.Span),
+				})
+			}
+			js.Methods = append(js.Methods, jm)
+		}
+		js.Reserved = reservedToJSON(s.Reserved)
+		return js, nil
+	case Const:
+		return jsonStmt{
+			Kind:  "const",
+			Name:  optIdentToJSON(s.Ident),
+			Doc:   docToJSON(s.Dec),
+			Span:  spanToJSON(s.Span),
+			Type:  typeToJSON(s.Type),
+			Value: literalToJSON(s.Value),
+		}, nil
+	case Import:
+		return jsonStmt{
+			Kind: "import",
+			Span: spanToJSON(s.Span),
+			Path: s.Path,
+			As:   s.Name,
+			Lang: importLangs[s.Lang],
+		}, nil
+	case GoPackage:
+		return jsonStmt{
+			Kind: "go:package",
+			Name: optIdentToJSON(s.Name),
+			Span: spanToJSON(s.Span),
+		}, nil
+	}
+	return jsonStmt{}, fmt.Errorf("can't write %T as JSON", s)
+}
+
+func stmtFromJSON(js jsonStmt) (Statement, error) {
+	switch js.Kind {
+	case "struct", "typedef", "enum", "variant", "protocol", "const", "go:package":
+		err := requireName(js.Kind, js.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch js.Kind {
+	case "struct":
+		var err error
+		s := Struct{BaseTypedef: typedefFromJSON(js)}
+		for _, f := range js.Fields {
+			err := requireName("field", &f.Name)
+			if err != nil {
+				return nil, err
+			}
+			err = checkNumber("position", f.Position)
+			if err != nil {
+				return nil, err
+			}
+			t, err := typeFromJSON(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.Name.Name, err)
+			}
+			s.Fields = append(s.Fields, Field{
+				Ident: identFromJSON(&f.Name),
+				Pos:   f.Position,
+				Type:  t,
+				Span:  spanFromJSON(f.Span),
+			})
+		}
+		s.Reserved, err = reservedFromJSON(js.Reserved)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "typedef":
+		t, err := typeFromJSON(js.Type)
+		if err != nil {
+			return nil, err
+		}
+		return Typedef{BaseTypedef: typedefFromJSON(js), Type: t}, nil
+	case "enum":
+		e := Enum{BaseTypedef: typedefFromJSON(js)}
+		for _, v := range js.Values {
+			err := requireName("enum value", &v.Name)
+			if err != nil {
+				return nil, err
+			}
+			err = checkName("enum value constant", v.ValueConst)
+			if err != nil {
+				return nil, err
+			}
+			err = checkNumber("enum value", v.Value)
+			if err != nil {
+				return nil, err
+			}
+			e.Values = append(e.Values, EnumValue{
+				Ident:    identFromJSON(&v.Name),
+				Num:      v.Value,
+				NumConst: identFromJSON(v.ValueConst),
+				Span:     spanFromJSON(v.Span),
+			})
+		}
+		return e, nil
+	case "variant":
+		if js.Switch == nil {
+			return nil, fmt.Errorf("variant %s has no switch", identFromJSON(js.Name).Name)
+		}
+		err := requireName("switch", &js.Switch.Name)
+		if err != nil {
+			return nil, err
+		}
+		st, err := typeFromJSON(js.Switch.Type)
+		if err != nil {
+			return nil, err
+		}
+		v := Variant{
+			BaseTypedef: typedefFromJSON(js),
+			SwitchVar:   identFromJSON(&js.Switch.Name),
+			SwitchType:  st,
+		}
+		for _, jc := range js.Cases {
+			t, err := typeFromJSON(jc.Type)
+			if err != nil {
+				return nil, err
+			}
+			if jc.Position != nil {
+				err := checkNumber("position", *jc.Position)
+				if err != nil {
+					return nil, err
+				}
+			}
+			c := Case{Position: jc.Position, Type: t, Span: spanFromJSON(jc.Span)}
+			if !jc.Default {
+				if len(jc.Labels) == 0 {
+					return nil, fmt.Errorf("case with no labels that isn't the default")
+				}
+				c.Labels = []CaseLabel{}
+			}
+			for _, jl := range jc.Labels {
+				l, err := labelFromJSON(jl)
+				if err != nil {
+					return nil, err
+				}
+				c.Labels = append(c.Labels, l)
+			}
+			v.Cases = append(v.Cases, c)
+		}
+		v.Reserved, err = reservedFromJSON(js.Reserved)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case "protocol":
+		if js.Errors == nil {
+			return nil, fmt.Errorf("protocol %s has no errors type", identFromJSON(js.Name).Name)
+		}
+		p := Protocol{BaseTypedef: typedefFromJSON(js)}
+		t, err := typeFromJSON(js.Errors.Type)
+		if err != nil {
+			return nil, err
+		}
+		p.Modifiers.Errors = Errors{Type: t, Span: spanFromJSON(js.Errors.Span)}
+		if h := js.ArgHeader; h != nil {
+			t, err := typeFromJSON(h.Type)
+			if err != nil {
+				return nil, err
+			}
+			p.Modifiers.ArgHeader = &ArgHeader{Type: t, Span: spanFromJSON(h.Span)}
+		}
+		if h := js.ResHeader; h != nil {
+			t, err := typeFromJSON(h.Type)
+			if err != nil {
+				return nil, err
+			}
+			p.Modifiers.ResHeader = &ResHeader{Type: t, Span: spanFromJSON(h.Span)}
+		}
+		for _, jm := range js.Methods {
+			err := requireName("method", &jm.Name)
+			if err != nil {
+				return nil, err
+			}
+			m, err := methodFromJSON(jm)
+			if err != nil {
+				return nil, fmt.Errorf("method %s: %w", jm.Name.Name, err)
+			}
+			p.Methods = append(p.Methods, m)
+		}
+		p.Reserved, err = reservedFromJSON(js.Reserved)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case "const":
+		t, err := typeFromJSON(js.Type)
+		if err != nil {
+			return nil, err
+		}
+		if js.Value == nil {
+			return nil, fmt.Errorf("const %s has no value", identFromJSON(js.Name).Name)
+		}
+		l, err := literalFromJSON(*js.Value)
+		if err != nil {
+			return nil, err
+		}
+		return Const{
+			BaseStatement: BaseStatement{Dec: docFromJSON(js.Doc), Span: spanFromJSON(js.Span)},
+			Ident:         identFromJSON(js.Name),
+			Type:          t,
+			Value:         l,
+		}, nil
+	case "import":
+		err := requireName("import", &jsonIdent{Name: js.As})
+		if err != nil {
+			return nil, err
+		}
+		// The lexer stops a string at a quote or the end of the line.
+		if strings.ContainsAny(js.Path, "\"\n") {
+			return nil, fmt.Errorf("import %s has a bad path %q", js.As, js.Path)
+		}
+		for lang, nm := range importLangs {
+			if nm == js.Lang {
+				return Import{Path: js.Path, Name: js.As, Lang: lang, Span: spanFromJSON(js.Span)}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown import language %q", js.Lang)
+	case "go:package":
+		return GoPackage{Name: identFromJSON(js.Name), Span: spanFromJSON(js.Span)}, nil
+	}
+	return nil, fmt.Errorf("unknown statement kind %q", js.Kind)
+}
+
+func methodFromJSON(jm jsonMethod) (Method, error) {
+	err := checkName("argument type", jm.ArgType)
+	if err != nil {
+		return Method{}, err
+	}
+	err = checkNumber("position", jm.Position)
+	if err != nil {
+		return Method{}, err
+	}
+	res, err := typeFromJSON(jm.Result)
+	if err != nil {
+		return Method{}, err
+	}
+	m := Method{
+		BaseTypedef: BaseTypedef{
+			BaseStatement: BaseStatement{Dec: docFromJSON(jm.Doc), Span: spanFromJSON(jm.Span)},
+			Ident:         identFromJSON(&jm.Name),
+			UniqueID:      idFromJSON(jm.ID),
+		},
+		Pos:     jm.Position,
+		ArgType: identFromJSON(jm.ArgType),
+		ResType: res,
+	}
+	for _, jp := range jm.Params {
+		err := requireName("parameter", &jp.Name)
+		if err != nil {
+			return Method{}, err
+		}
+		err = checkNumber("position", jp.Position)
+		if err != nil {
+			return Method{}, err
+		}
+		t, err := typeFromJSON(jp.Type)
+		if err != nil {
+			return Method{}, err
+		}
+		m.Params = append(m.Params, Param{
+			Ident: identFromJSON(&jp.Name),
+			Type:  t,
+			Pos:   jp.Position,
+			Span:  spanFromJSON(jp.Span),
+		})
+	}
+	return m, nil
+}
+
+func reservedToJSON(rs []Reserved) []jsonReserved {
+	var ret []jsonReserved
+	for _, r := range rs {
+		ret = append(ret, jsonReserved{Position: r.Pos, Name: r.Name, Span: spanToJSON(r.Span)})
+	}
+	return ret
+}
+
+func reservedFromJSON(rs []jsonReserved) ([]Reserved, error) {
+	var ret []Reserved
+	for _, r := range rs {
+		if r.Name != "" && !isIdentifier(r.Name) {
+			return nil, fmt.Errorf("bad reserved name %q", r.Name)
+		}
+		if r.Position != nil {
+			err := checkNumber("position", *r.Position)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, Reserved{Pos: r.Position, Name: r.Name, Span: spanFromJSON(r.Span)})
+	}
+	return ret, nil
+}
+
+func labelToJSON(l CaseLabel) jsonLabel {
+	switch l := l.(type) {
+	case CaseLabelIdentifier:
+		return jsonLabel{Kind: "name", Name: optIdentToJSON(l.Ident)}
+	case CaseLabelNumber:
+		return jsonLabel{Kind: "number", Number: &l.Num, Span: spanToJSON(l.Span)}
+	case CaseLabelBool:
+		return jsonLabel{Kind: "bool", Bool: &l.Bool, Span: spanToJSON(l.Span)}
+	}
+	return jsonLabel{}
+}
+
+func labelFromJSON(jl jsonLabel) (CaseLabel, error) {
+	switch {
+	case jl.Kind == "name" && jl.Name != nil:
+		err := requireName("case label", jl.Name)
+		if err != nil {
+			return nil, err
+		}
+		return CaseLabelIdentifier{Ident: identFromJSON(jl.Name)}, nil
+	case jl.Kind == "number" && jl.Number != nil:
+		err := checkNumber("case label", *jl.Number)
+		if err != nil {
+			return nil, err
+		}
+		return CaseLabelNumber{Num: *jl.Number, Span: spanFromJSON(jl.Span)}, nil
+	case jl.Kind == "bool" && jl.Bool != nil:
+		return CaseLabelBool{Bool: *jl.Bool, Span: spanFromJSON(jl.Span)}, nil
+	}
+	return nil, fmt.Errorf("bad case label of kind %q", jl.Kind)
+}
+
+var literalKinds = map[LiteralKind]string{
+	LiteralInt:  "int",
+	LiteralHex:  "hex",
+	LiteralText: "text",
+	LiteralBool: "bool",
+}
+
+func literalToJSON(l Literal) *jsonLiteral {
+	return &jsonLiteral{Kind: literalKinds[l.Kind], Raw: l.Raw, Span: spanToJSON(l.Span)}
+}
+
+// literalFromJSON checks that Raw is spelled the way the lexer would have
+// it for the kind, since the rest of the compiler counts on that.
+func literalFromJSON(jl jsonLiteral) (Literal, error) {
+	var ok bool
+	switch jl.Kind {
+	case "int":
+		ok = intrxx.MatchString(jl.Raw)
+	case "hex":
+		ok = hexrxx.MatchString(jl.Raw)
+	case "bool":
+		ok = jl.Raw == "true" || jl.Raw == "false"
+	case "text":
+		ok = !strings.ContainsAny(jl.Raw, "\"\n")
+	default:
+		return Literal{}, fmt.Errorf("unknown literal kind %q", jl.Kind)
+	}
+	if !ok {
+		return Literal{}, fmt.Errorf("malformed %s literal %q", jl.Kind, jl.Raw)
+	}
+	for k, nm := range literalKinds {
+		if nm == jl.Kind {
+			return Literal{Kind: k, Raw: jl.Raw, Span: spanFromJSON(jl.Span)}, nil
+		}
+	}
+	return Literal{}, fmt.Errorf("unknown literal kind %q", jl.Kind)
+}
+
+// requireName makes sure that a declaration read from JSON has a name, since
+// the emitters can't do anything sensible without one, and that it's a name
+// the lexer would have read as an identifier.
+func requireName(what string, i *jsonIdent) error {
+	if i == nil || i.Name == "" {
+		return fmt.Errorf("%s with no name", what)
+	}
+	return checkName(what, i)
+}
+
+// checkName checks a name that's allowed to be missing. The emitters write
+// names into their output just as they are, so one that isn't an identifier
+// could turn into anything at all there.
+func checkName(what string, i *jsonIdent) error {
+	if i != nil && !isIdentifier(i.Name) {
+		return fmt.Errorf("%s has a bad name %q", what, i.Name)
+	}
+	return nil
+}
+
+// checkNumber makes sure that n is a number the grammar allows, which none
+// of the negative ones are.
+func checkNumber(what string, n int) error {
+	if n < 0 {
+		return fmt.Errorf("negative %s %d", what, n)
+	}
+	return nil
+}
+
+func typeToJSON(t Type) *jsonType {
+	if t == nil {
+		return nil
+	}
+	jt := &jsonType{Span: spanToJSON(t.GetSpan())}
+	switch t := t.(type) {
+	case Text:
+		jt.Kind = "text"
+	case Uint:
+		jt.Kind = "uint"
+	case Int:
+		jt.Kind = "int"
+	case Bool:
+		jt.Kind = "bool"
+	case SizedInt:
+		jt.Kind = fmt.Sprintf("int%d", t.Bits)
+		if !t.Signed {
+			jt.Kind = "u" + jt.Kind
+		}
+	case Float:
+		jt.Kind = fmt.Sprintf("float%d", t.Bits)
+	case Blob:
+		jt.Kind = "blob"
+		jt.Count = t.Count
+		jt.CountConst = optIdentToJSON(t.CountConst)
+	case List:
+		jt.Kind = "list"
+		jt.Type = typeToJSON(t.Type)
+	case Map:
+		jt.Kind = "map"
+		jt.Key = typeToJSON(t.Key)
+		jt.Value = typeToJSON(t.Value)
+	case Option:
+		jt.Kind = "option"
+		jt.Type = typeToJSON(t.Type)
+	case Future:
+		jt.Kind = "future"
+		jt.Type = typeToJSON(t.Type)
+	case Void:
+		jt.Kind = "void"
+	case DerivedType:
+		jt.Kind = "named"
+		jt.Name = optIdentToJSON(t.Name)
+		jt.From = optIdentToJSON(t.ImportedFrom)
+	}
+	return jt
+}
+
+func typeFromJSON(jt *jsonType) (Type, error) {
+	if jt == nil {
+		return nil, fmt.Errorf("missing type")
+	}
+	bt := BaseType{Span: spanFromJSON(jt.Span)}
+
+	// The ones that wrap another type.
+	var inner Type
+	switch jt.Kind {
+	case "list", "option", "future":
+		var err error
+		inner, err = typeFromJSON(jt.Type)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch jt.Kind {
+	case "text":
+		return Text{BaseType: bt}, nil
+	case "uint":
+		return Uint{BaseType: bt}, nil
+	case "int":
+		return Int{BaseType: bt}, nil
+	case "bool":
+		return Bool{BaseType: bt}, nil
+	case "uint8", "uint16", "uint32":
+		return SizedInt{BaseType: bt, Bits: kindBits(jt.Kind)}, nil
+	case "int8", "int16", "int32":
+		return SizedInt{BaseType: bt, Bits: kindBits(jt.Kind), Signed: true}, nil
+	case "float32", "float64":
+		return Float{BaseType: bt, Bits: kindBits(jt.Kind)}, nil
+	case "blob":
+		err := checkNumber("blob count", jt.Count)
+		if err != nil {
+			return nil, err
+		}
+		err = checkName("blob count", jt.CountConst)
+		if err != nil {
+			return nil, err
+		}
+		return Blob{BaseType: bt, Count: jt.Count, CountConst: identFromJSON(jt.CountConst)}, nil
+	case "list":
+		return List{BaseType: bt, Type: inner}, nil
+	case "option":
+		return Option{BaseType: bt, Type: inner}, nil
+	case "future":
+		return Future{BaseType: bt, Type: inner}, nil
+	case "map":
+		k, err := typeFromJSON(jt.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := typeFromJSON(jt.Value)
+		if err != nil {
+			return nil, err
+		}
+		return Map{BaseType: bt, Key: k, Value: v}, nil
+	case "void":
+		return Void{BaseType: bt}, nil
+	case "named":
+		err := requireName("named type", jt.Name)
+		if err != nil {
+			return nil, err
+		}
+		err = checkName("import", jt.From)
+		if err != nil {
+			return nil, err
+		}
+		return DerivedType{BaseType: bt, Name: identFromJSON(jt.Name), ImportedFrom: identFromJSON(jt.From)}, nil
+	}
+	return nil, fmt.Errorf("unknown type kind %q", jt.Kind)
+}
+
+// kindBits gets the size out of a kind like "uint16".
+func kindBits(kind string) int {
+	var bits int
+	for _, r := range kind {
+		if isDigit(r) {
+			bits = bits*10 + int(r-'0')
+		}
+	}
+	return bits
+}
@@ -0,0 +1,378 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// roundTrip writes r as JSON and reads it back, and makes sure that nothing
+// was lost: the JSON is the same the second time around, and so is the
+// formatted source.
+func roundTrip(t *testing.T, r *Root) *Root {
+	t.Helper()
+	dat, err := MarshalAST(r)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	r2, err := UnmarshalAST(dat)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	dat2, err := MarshalAST(r2)
+	if err != nil {
+		t.Fatalf("marshal again: %v", err)
+	}
+	if string(dat) != string(dat2) {
+		t.Errorf("JSON changed on a round trip; first:\n%s\nsecond:\n%s", dat, dat2)
+	}
+	if f, f2 := Format(r), Format(r2); string(f) != string(f2) {
+		t.Errorf("formatted source changed on a round trip; first:\n%s\nsecond:\n%s", f, f2)
+	}
+	return r2
+}
+
+func TestASTRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string // after checkHeader, unless it has its own file ID
+		emit bool   // whether it's a standalone file we can generate Go for
+	}{
+		{
+			name: "declarations",
+			emit: true,
+			src: `// header
+go:package foo;
+const N : Uint = 4;
+const H : Blob(2) = 0xbeef;
+const T : Text = "hi";
+const B : Bool = false;
+const I : Int8 = -3;
+
+/** S is a thing. */
+struct S @0x8a9f2b3c4d5e6f71 {
+    a @0 : Blob(N); // trailing
+    reserved @1, old;
+    c @2 : Map(Text, List(Uint));
+    d @3 : Option(Float64);
+}
+typedef U = List(S);
+enum E {
+    A @0;
+    B @N;
+}`,
+		},
+		{
+			name: "variants",
+			emit: true,
+			src: `enum E {
+    A @0;
+    B @1;
+}
+variant V switch (e : E) @0x8a9f2b3c4d5e6f72 {
+    case A @0 : Text;
+    reserved @1;
+    default : void;
+}
+variant W switch (t : Int) {
+    case 1, 2 @0 : Uint;
+    case 3 : void;
+}
+variant X switch (b : Bool) {
+    case true @0 : Text;
+    case false : void;
+}`,
+		},
+		{
+			name: "protocols",
+			emit: true,
+			src: `struct Hdr {
+    a @0 : Uint;
+}
+protocol P errors Text argHeader Hdr resHeader Hdr @0xcccccccc {
+    /** doc for m */
+    m @0 (a @0 : Int, b @1 : Uint32) : MArg -> void;
+    n @1 () -> Float64;
+    reserved @2;
+    o @3 (x @0 : Int8) -> List(Text);
+}`,
+		},
+		{
+			name: "imports",
+			src: `import "a/b" as ab;
+go:import "example.com/x" as x;
+ts:import "./x" as x;
+struct S {
+    a @0 : ab.A;
+    b @1 : x.B;
+}`,
+		},
+		{
+			name: "pending IDs",
+			src: `@new;
+struct S @new {
+    a @0 : Text;
+}
+protocol P errors Text {
+    m @0 ();
+}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src
+			if !strings.HasPrefix(src, "@") {
+				src = checkHeader + src
+			}
+			r, err := Parse([]byte(src), "t.snowp")
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			r2 := roundTrip(t, r)
+			if !tt.emit {
+				return
+			}
+			// Go has to be generated from a fresh parse, since checking
+			// fills in the tree.
+			r, _ = Parse([]byte(src), "t.snowp")
+			if g, g2 := emitGo(t, r), emitGo(t, r2); g != g2 {
+				t.Errorf("generated Go differs; from source:\n%s\nfrom JSON:\n%s", g, g2)
+			}
+		})
+	}
+}
+
+// TestASTRoundTripExamples round-trips the example schemas that ship with
+// snowpc.
+func TestASTRoundTripExamples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "snowpc", "*.snowp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			dat, err := os.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := Parse(dat, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			roundTrip(t, r)
+		})
+	}
+}
+
+func TestUnmarshalASTErrors(t *testing.T) {
+	// Each is a statement, which goes into an otherwise good file.
+	tests := []struct {
+		name string
+		stmt string
+		want string
+	}{
+		{
+			name: "hex with no 0x",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "uint"}, "value": {"kind": "hex", "raw": "1"}}`,
+			want: `statement 0: malformed hex literal "1"`,
+		},
+		{
+			name: "hex with no digits",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "uint"}, "value": {"kind": "hex", "raw": "0x"}}`,
+			want: `statement 0: malformed hex literal "0x"`,
+		},
+		{
+			name: "hex with bad digits",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "uint"}, "value": {"kind": "hex", "raw": "0xzz"}}`,
+			want: `statement 0: malformed hex literal "0xzz"`,
+		},
+		{
+			name: "int that isn't decimal",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "uint"}, "value": {"kind": "int", "raw": "0x10"}}`,
+			want: `statement 0: malformed int literal "0x10"`,
+		},
+		{
+			name: "empty int",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "uint"}, "value": {"kind": "int", "raw": ""}}`,
+			want: `statement 0: malformed int literal ""`,
+		},
+		{
+			name: "bool that isn't",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "bool"}, "value": {"kind": "bool", "raw": "yes"}}`,
+			want: `statement 0: malformed bool literal "yes"`,
+		},
+		{
+			name: "unknown literal kind",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "uint"}, "value": {"kind": "float", "raw": "1.5"}}`,
+			want: `statement 0: unknown literal kind "float"`,
+		},
+		{
+			name: "const with no value",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "uint"}}`,
+			want: "statement 0: const N has no value",
+		},
+		{
+			name: "struct with no name",
+			stmt: `{"kind": "struct", "fields": [{"name": {"name": "a"}, "position": 0, "type": {"kind": "uint"}}]}`,
+			want: "statement 0: struct with no name",
+		},
+		{
+			name: "field with no name",
+			stmt: `{"kind": "struct", "name": {"name": "S"}, "fields": [{"name": {"name": ""}, "position": 0, "type": {"kind": "uint"}}]}`,
+			want: "statement 0: field with no name",
+		},
+		{
+			name: "enum value with no name",
+			stmt: `{"kind": "enum", "name": {"name": "E"}, "values": [{"name": {"name": ""}, "value": 0}]}`,
+			want: "statement 0: enum value with no name",
+		},
+		{
+			name: "code in a name",
+			stmt: `{"kind": "const", "name": {"name": "Foo = 1\nfunc init() { println(\"pwned\") }\nconst Bar"}, "type": {"kind": "uint"}, "value": {"kind": "int", "raw": "1"}}`,
+			want: `statement 0: const has a bad name "Foo = 1\nfunc init() { println(\"pwned\") }\nconst Bar"`,
+		},
+		{
+			name: "keyword as a name",
+			stmt: `{"kind": "struct", "name": {"name": "struct"}}`,
+			want: `statement 0: struct has a bad name "struct"`,
+		},
+		{
+			name: "name starting with a digit",
+			stmt: `{"kind": "struct", "name": {"name": "S"}, "fields": [{"name": {"name": "1a"}, "position": 0, "type": {"kind": "uint"}}]}`,
+			want: `statement 0: field has a bad name "1a"`,
+		},
+		{
+			name: "bad type reference",
+			stmt: `{"kind": "typedef", "name": {"name": "T"}, "type": {"kind": "named", "name": {"name": "A; var x"}}}`,
+			want: `statement 0: named type has a bad name "A; var x"`,
+		},
+		{
+			name: "bad import in a type reference",
+			stmt: `{"kind": "typedef", "name": {"name": "T"}, "type": {"kind": "named", "name": {"name": "A"}, "from": {"name": "x.y"}}}`,
+			want: `statement 0: import has a bad name "x.y"`,
+		},
+		{
+			name: "bad method name",
+			stmt: `{"kind": "protocol", "name": {"name": "P"}, "errors": {"type": {"kind": "text"}}, "methods": [{"name": {"name": "m()"}, "position": 0, "result": {"kind": "void"}}]}`,
+			want: `statement 0: method has a bad name "m()"`,
+		},
+		{
+			name: "bad parameter name",
+			stmt: `{"kind": "protocol", "name": {"name": "P"}, "errors": {"type": {"kind": "text"}}, "methods": [{"name": {"name": "m"}, "position": 0, "params": [{"name": {"name": "a b"}, "position": 0, "type": {"kind": "uint"}}], "result": {"kind": "void"}}]}`,
+			want: `statement 0: method m: parameter has a bad name "a b"`,
+		},
+		{
+			name: "bad case label",
+			stmt: `{"kind": "variant", "name": {"name": "V"}, "switch": {"name": {"name": "t"}, "type": {"kind": "named", "name": {"name": "E"}}}, "cases": [{"labels": [{"kind": "name", "name": {"name": "A:"}}], "type": {"kind": "void"}}]}`,
+			want: `statement 0: case label has a bad name "A:"`,
+		},
+		{
+			name: "bad import alias",
+			stmt: `{"kind": "import", "path": "example.com/x", "as": "x/y", "lang": "go"}`,
+			want: `statement 0: import has a bad name "x/y"`,
+		},
+		{
+			name: "quote in an import path",
+			stmt: `{"kind": "import", "path": "./x\"; evil(); \"", "as": "x", "lang": "ts"}`,
+			want: `statement 0: import x has a bad path`,
+		},
+		{
+			name: "quote in a text literal",
+			stmt: `{"kind": "const", "name": {"name": "N"}, "type": {"kind": "text"}, "value": {"kind": "text", "raw": "a\" + evil() + \"b"}}`,
+			want: `statement 0: malformed text literal`,
+		},
+		{
+			name: "bad reserved name",
+			stmt: `{"kind": "struct", "name": {"name": "S"}, "reserved": [{"name": "a-b"}]}`,
+			want: `statement 0: bad reserved name "a-b"`,
+		},
+		{
+			name: "negative blob count",
+			stmt: `{"kind": "typedef", "name": {"name": "T"}, "type": {"kind": "blob", "count": -3}}`,
+			want: "statement 0: negative blob count -3",
+		},
+		{
+			name: "negative position",
+			stmt: `{"kind": "struct", "name": {"name": "S"}, "fields": [{"name": {"name": "a"}, "position": -2, "type": {"kind": "uint"}}]}`,
+			want: "statement 0: negative position -2",
+		},
+		{
+			name: "unknown type",
+			stmt: `{"kind": "typedef", "name": {"name": "T"}, "type": {"kind": "complex"}}`,
+			want: `statement 0: unknown type kind "complex"`,
+		},
+		{
+			name: "missing type",
+			stmt: `{"kind": "typedef", "name": {"name": "T"}}`,
+			want: "statement 0: missing type",
+		},
+		{
+			name: "unknown statement",
+			stmt: `{"kind": "union", "name": {"name": "U"}}`,
+			want: `statement 0: unknown statement kind "union"`,
+		},
+		{
+			name: "unknown field",
+			stmt: `{"kind": "typedef", "name": {"name": "T"}, "type": {"kind": "uint"}, "extra": 1}`,
+			want: `json: unknown field "extra"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := `{"version": 1, "id": {"value": "0x8a9f2b3c4d5e6f70"}, "statements": [` + tt.stmt + `]}`
+			_, err := UnmarshalAST([]byte(doc))
+			if err == nil {
+				t.Fatalf("no error; want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %q; want %q", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("version", func(t *testing.T) {
+		_, err := UnmarshalAST([]byte(`{"version": 99, "statements": []}`))
+		if err == nil || !strings.Contains(err.Error(), "unsupported AST version 99") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+// TestLiteralAccessors makes sure that a literal that doesn't look the way
+// its kind says gives an error, rather than a panic or a wrong value.
+func TestLiteralAccessors(t *testing.T) {
+	tests := []struct {
+		lit     Literal
+		wantInt int64
+		wantErr bool
+	}{
+		{lit: Literal{Kind: LiteralInt, Raw: "12"}, wantInt: 12},
+		{lit: Literal{Kind: LiteralInt, Raw: "-12"}, wantInt: -12},
+		{lit: Literal{Kind: LiteralHex, Raw: "0x1f"}, wantInt: 31},
+		{lit: Literal{Kind: LiteralHex, Raw: "1"}, wantErr: true},
+		{lit: Literal{Kind: LiteralHex, Raw: "0x"}, wantErr: true},
+		{lit: Literal{Kind: LiteralHex, Raw: ""}, wantErr: true},
+		{lit: Literal{Kind: LiteralInt, Raw: "x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lit.Raw, func(t *testing.T) {
+			n, err := tt.lit.Int64()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Int64: err = %v; want error %t", err, tt.wantErr)
+			}
+			if err == nil && n != tt.wantInt {
+				t.Errorf("Int64 = %d; want %d", n, tt.wantInt)
+			}
+			if tt.lit.Kind != LiteralHex {
+				return
+			}
+			if _, err := tt.lit.Uint64(); (err != nil) != tt.wantErr {
+				t.Errorf("Uint64: err = %v; want error %t", err, tt.wantErr)
+			}
+			if _, err := tt.lit.Bytes(); tt.wantErr && err == nil {
+				t.Errorf("Bytes: no error")
+			}
+		})
+	}
+}
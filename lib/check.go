@@ -0,0 +1,644 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checker runs semantic checks over a parsed file, catching mistakes that
+// would otherwise produce Go code that only fails at `go build` time.
+type checker struct {
+	root  *Root
+	diags []Diagnostic
+
+	// warnExhaustive turns on warnings for enum-switched variants that
+	// don't handle every value of the enum.
+	warnExhaustive bool
+}
+
+// Check resolves and validates a standalone parsed file, and returns a list
+// of problems found, each located in the source. If none of them are errors,
+// the file is OK to emit.
+func Check(r *Root) []Diagnostic {
+	scope := NewScope()
+	diags := scope.Declare(r)
+	diags = append(diags, Resolve(r, scope, nil)...)
+	diags = append(diags, validate(r, true)...)
+	sortDiagnostics(diags)
+	return diags
+}
+
+// validate runs the checks that need a file to have been resolved already.
+func validate(r *Root, warnExhaustive bool) []Diagnostic {
+	c := &checker{root: r, warnExhaustive: warnExhaustive}
+	c.run()
+	return c.diags
+}
+
+// checkTargetImports makes sure that every import can be mapped onto a
+// package in the target language. That takes an import for the target
+// language itself, or, for Go, a generic import whose generated code we can
+// find. TypeScript always needs a ts:import, since its modules are files
+// rather than packages, so there's no telling which of an imported package's
+// generated files a type is in.
+func checkTargetImports(r *Root, lang Language) []Diagnostic {
+	type flavors struct {
+		first  Import
+		byLang map[Language]Import
+	}
+	var names []string
+	imps := make(map[string]*flavors)
+	for _, s := range r.Stmts {
+		i, ok := s.(Import)
+		if !ok {
+			continue
+		}
+		f := imps[i.Name]
+		if f == nil {
+			f = &flavors{first: i, byLang: make(map[Language]Import)}
+			imps[i.Name] = f
+			names = append(names, i.Name)
+		}
+		f.byLang[i.Lang] = i
+	}
+
+	var diags []Diagnostic
+	errorf := func(i Import, format string, args ...any) {
+		diags = append(diags, Diagnostic{
+			Filename: r.Filename,
+			Pos:      i.Span.Start,
+			Msg:      fmt.Sprintf(format, args...),
+		})
+	}
+	for _, nm := range names {
+		f := imps[nm]
+		if _, ok := f.byLang[lang]; ok {
+			continue
+		}
+		gen, isGeneric := f.byLang[LangGeneric]
+		switch {
+		case lang == LangGo && isGeneric && gen.Pkg != nil && gen.Pkg.GoPath == "":
+			errorf(gen, "cannot work out the Go import path for %q; "+
+				"add `go:import \"...\" as %s;`", gen.Path, nm)
+		case lang == LangGo && isGeneric:
+			// Either we found the Go package, or the resolver already
+			// complained about the import.
+		case lang == LangGo:
+			errorf(f.first, "%s has no go:import", nm)
+		case lang == LangTypeScript && isGeneric:
+			errorf(gen, "%s has no ts:import; TypeScript can't import a .snowp package "+
+				"by itself, so add `ts:import \"...\" as %s;` naming the generated module", nm, nm)
+		case lang == LangTypeScript:
+			errorf(f.first, "%s has no ts:import", nm)
+		}
+	}
+	return diags
+}
+
+func (c *checker) errorf(sp Span, format string, args ...any) {
+	c.diags = append(c.diags, Diagnostic{
+		Filename: c.root.Filename,
+		Pos:      sp.Start,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) warnf(sp Span, format string, args ...any) {
+	c.diags = append(c.diags, Diagnostic{
+		Filename: c.root.Filename,
+		Pos:      sp.Start,
+		Msg:      fmt.Sprintf(format, args...),
+		Severity: SeverityWarning,
+	})
+}
+
+func (c *checker) run() {
+	c.checkImports()
+	c.checkGoPackage()
+	c.checkUniqueIDs()
+	for _, s := range c.root.Stmts {
+		switch s := s.(type) {
+		case Typedef:
+			c.checkTypedef(s)
+			c.checkTypes(s.Type)
+		case Struct:
+			c.checkFields(s.Ident, s.Fields, s.Reserved)
+			c.checkStructGaps(s)
+			for _, f := range s.Fields {
+				c.checkTypes(f.Type)
+			}
+		case Enum:
+			c.checkEnum(s)
+		case Const:
+			c.checkConst(s)
+		case Variant:
+			c.checkVariant(s)
+			for _, cs := range s.Cases {
+				c.checkTypes(cs.Type)
+			}
+		case Protocol:
+			c.checkProtocol(s)
+			for _, m := range s.Methods {
+				for _, prm := range m.Params {
+					c.checkTypes(prm.Type)
+				}
+				c.checkTypes(m.ResType)
+			}
+		}
+	}
+}
+
+// checkUniqueIDs makes sure that every `@new` has been filled in, that
+// protocols have IDs, and that no ID is used twice in the file, which
+// otherwise wouldn't come up until rpc.AddUnique panics at startup.
+func (c *checker) checkUniqueIDs() {
+	seen := make(map[string]string)
+	check := func(what string, u UniqueID) {
+		key := strings.ToLower(u.Val)
+		switch {
+		case u.Pending:
+			c.errorf(u.Span, "%s has no unique ID yet; run `snowpc newid --assign %s`",
+				what, c.root.Filename)
+		case !u.IsSet():
+		case seen[key] != "":
+			c.errorf(u.Span, "unique ID %s of %s is already used by %s", u.Val, what, seen[key])
+		default:
+			seen[key] = what
+		}
+	}
+	check("file", c.root.Id)
+	for _, s := range c.root.Stmts {
+		u, ok := statementUniqueID(s)
+		if !ok {
+			continue
+		}
+		if p, isProto := s.(Protocol); isProto && !u.IsSet() && !u.Pending {
+			c.errorf(p.Ident.Span, "protocol %s has no unique ID; run `snowpc newid --assign %s`",
+				p.Ident.Name, c.root.Filename)
+			continue
+		}
+		check(statementDescription(s), u)
+	}
+}
+
+// checkTypes checks t and every type nested inside of it.
+func (c *checker) checkTypes(t Type) {
+	if t == nil {
+		return
+	}
+	walkType(t, func(t Type) {
+		if m, ok := t.(Map); ok {
+			c.checkMapKey(m)
+		}
+	})
+}
+
+// checkMapKey makes sure that a map's keys can be compared in both Go and
+// TypeScript, and sorted, so that the encoding is stable. Blobs have to be
+// fixed-size to be Go array keys; TypeScript keys them by their hex.
+func (c *checker) checkMapKey(m Map) {
+	switch k := Underlying(m.Key).(type) {
+	case Text, Int, Uint, SizedInt, Bool:
+		return
+	case Blob:
+		if k.Count > 0 {
+			return
+		}
+	case DerivedType:
+		if k.Sym != nil {
+			if _, isEnum := k.Sym.Decl().(Enum); isEnum {
+				return
+			}
+		}
+	}
+	c.errorf(m.Key.GetSpan(),
+		"map key must be Text, Bool, an integer type, a fixed-size Blob or an enum")
+}
+
+func (c *checker) checkImports() {
+	type importKey struct {
+		lang Language
+		name string
+	}
+	seen := make(map[importKey]bool)
+	for _, s := range c.root.Stmts {
+		s, ok := s.(Import)
+		if !ok {
+			continue
+		}
+		// The same name is allowed once per language, since that's how
+		// we map one import onto the different target languages.
+		key := importKey{lang: s.Lang, name: s.Name}
+		if seen[key] {
+			c.errorf(s.Span, "duplicate import %q", s.Name)
+			continue
+		}
+		seen[key] = true
+	}
+}
+
+// checkGoPackage allows at most one go:package per file. Whether the files
+// in a directory agree is up to the Runner, which sees them all.
+func (c *checker) checkGoPackage() {
+	var first *GoPackage
+	for _, s := range c.root.Stmts {
+		s, ok := s.(GoPackage)
+		if !ok {
+			continue
+		}
+		if first != nil {
+			c.errorf(s.Span, "duplicate go:package (first at %s)", first.Span.Start)
+			continue
+		}
+		first = &s
+	}
+}
+
+// checkTypedef rejects typedefs that never bottom out in a real type, like
+// `typedef A = B; typedef B = A;`.
+func (c *checker) checkTypedef(t Typedef) {
+	u, ok := Underlying(t.Type).(DerivedType)
+	if !ok || u.Sym == nil {
+		return
+	}
+	if _, isTypedef := u.Sym.Decl().(Typedef); isTypedef {
+		c.errorf(t.Ident.Span, "typedef %s is circular", t.Ident.Name)
+	}
+}
+
+// reservations indexes the reserved positions and names of a struct,
+// variant or protocol.
+type reservations struct {
+	positions map[int]Reserved
+	names     map[string]Reserved
+}
+
+func (c *checker) reservations(owner Identifier, rs []Reserved) reservations {
+	ret := reservations{
+		positions: make(map[int]Reserved),
+		names:     make(map[string]Reserved),
+	}
+	for _, r := range rs {
+		if r.Pos != nil {
+			if prev, found := ret.positions[*r.Pos]; found {
+				c.errorf(r.Span, "@%d is reserved twice in %s (previously at %s)",
+					*r.Pos, owner.Name, prev.Span.Start)
+			} else {
+				ret.positions[*r.Pos] = r
+			}
+			continue
+		}
+		if prev, found := ret.names[r.Name]; found {
+			c.errorf(r.Span, "%q is reserved twice in %s (previously at %s)",
+				r.Name, owner.Name, prev.Span.Start)
+		} else {
+			ret.names[r.Name] = r
+		}
+	}
+	return ret
+}
+
+// checkPos reports a use of a reserved position.
+func (res reservations) checkPos(c *checker, sp Span, what string, pos int, owner Identifier) {
+	if r, found := res.positions[pos]; found {
+		c.errorf(sp, "%s uses position @%d, which is reserved in %s (at %s)",
+			what, pos, owner.Name, r.Span.Start)
+	}
+}
+
+// checkName reports a use of a reserved name.
+func (res reservations) checkName(c *checker, id Identifier, owner Identifier) {
+	if r, found := res.names[id.Name]; found {
+		c.errorf(id.Span, "%q is reserved in %s (at %s)", id.Name, owner.Name, r.Span.Start)
+	}
+}
+
+// checkFields checks struct fields and method parameters, which both turn
+// into positional msgpack structs.
+func (c *checker) checkFields(owner Identifier, fields []Field, reserved []Reserved) {
+	res := c.reservations(owner, reserved)
+	names := make(map[string]Field)
+	positions := make(map[int]Field)
+	for _, f := range fields {
+		res.checkPos(c, f.Span, "field "+f.Ident.Name, f.Pos, owner)
+		res.checkName(c, f.Ident, owner)
+		if prev, found := names[f.Ident.Name]; found {
+			c.errorf(f.Ident.Span, "duplicate field name %q in %s (previously at %s)",
+				f.Ident.Name, owner.Name, prev.Span.Start)
+		} else {
+			names[f.Ident.Name] = f
+		}
+		if prev, found := positions[f.Pos]; found {
+			c.errorf(f.Span, "duplicate field position @%d in %s (already used by %q)",
+				f.Pos, owner.Name, prev.Ident.Name)
+		} else {
+			positions[f.Pos] = f
+		}
+	}
+}
+
+// checkStructGaps warns about positions below the last field that are
+// neither used nor reserved. They still take up a slot on the wire, so the
+// schema ought to say that they've been retired. It's only a warning, since
+// schemas written before `reserved` existed leave gaps, and they still build
+// the way they always have.
+func (c *checker) checkStructGaps(s Struct) {
+	used := make(map[int]bool)
+	last := -1
+	for _, f := range s.Fields {
+		used[f.Pos] = true
+		last = max(last, f.Pos)
+	}
+	for _, r := range s.Reserved {
+		if r.Pos != nil {
+			used[*r.Pos] = true
+		}
+	}
+	var missing []string
+	for i := 0; i < last; i++ {
+		if !used[i] {
+			missing = append(missing, fmt.Sprintf("@%d", i))
+		}
+	}
+	if len(missing) > 0 {
+		c.warnf(s.Ident.Span, "struct %s doesn't use %s; mark retired positions with `reserved`",
+			s.Ident.Name, strings.Join(missing, ", "))
+	}
+}
+
+func (c *checker) checkEnum(e Enum) {
+	names := make(map[string]EnumValue)
+	nums := make(map[int]EnumValue)
+	for _, v := range e.Values {
+		if prev, found := names[v.Ident.Name]; found {
+			c.errorf(v.Ident.Span, "duplicate enum value %q in %s (previously at %s)",
+				v.Ident.Name, e.Ident.Name, prev.Span.Start)
+		} else {
+			names[v.Ident.Name] = v
+		}
+		if prev, found := nums[v.Num]; found {
+			c.errorf(v.Span, "duplicate enum value @%d in %s (already used by %q)",
+				v.Num, e.Ident.Name, prev.Ident.Name)
+		} else {
+			nums[v.Num] = v
+		}
+	}
+}
+
+// checkConst makes sure that a constant's value fits its type.
+func (c *checker) checkConst(k Const) {
+	v := k.Value
+	mismatch := func(kind string) {
+		c.errorf(v.Span, "%s value for constant %s, which is %s",
+			kind, k.Ident.Name, constTypeDescription(k.Type))
+	}
+	outOfRange := func() {
+		c.errorf(v.Span, "value of constant %s doesn't fit in %s",
+			k.Ident.Name, constTypeDescription(k.Type))
+	}
+	isInt := v.Kind == LiteralInt || v.Kind == LiteralHex
+	switch t := Underlying(k.Type).(type) {
+	case Int:
+		if !isInt {
+			mismatch(literalDescription(v))
+		} else if _, err := v.Int64(); err != nil {
+			outOfRange()
+		}
+	case Uint:
+		if !isInt {
+			mismatch(literalDescription(v))
+		} else if _, err := v.Uint64(); err != nil {
+			outOfRange()
+		}
+	case SizedInt:
+		if !isInt {
+			mismatch(literalDescription(v))
+		} else if n, err := v.Int64(); err != nil || n < t.Min() || n > t.Max() {
+			outOfRange()
+		}
+	case Float:
+		if !isInt {
+			mismatch(literalDescription(v))
+		}
+	case Text:
+		if v.Kind != LiteralText {
+			mismatch(literalDescription(v))
+		}
+	case Bool:
+		if v.Kind != LiteralBool {
+			mismatch(literalDescription(v))
+		}
+	case Blob:
+		if v.Kind != LiteralHex {
+			mismatch(literalDescription(v))
+			return
+		}
+		b, err := v.Bytes()
+		if err != nil {
+			c.errorf(v.Span, "blob constant %s must have an even number of hex digits", k.Ident.Name)
+		} else if t.Count > 0 && len(b) != t.Count {
+			c.errorf(v.Span, "blob constant %s has %d bytes, but its type has %d",
+				k.Ident.Name, len(b), t.Count)
+		}
+	case DerivedType:
+		if t.Sym != nil {
+			c.errorf(k.Type.GetSpan(), "constant %s must be a number, Text, Bool or Blob",
+				k.Ident.Name)
+		}
+	}
+}
+
+func constTypeDescription(t Type) string {
+	switch t := Underlying(t).(type) {
+	case Text:
+		return "Text"
+	case Blob:
+		if t.Count > 0 {
+			return fmt.Sprintf("Blob(%d)", t.Count)
+		}
+		return "Blob"
+	}
+	return typeDescription(t)
+}
+
+func literalDescription(l Literal) string {
+	switch l.Kind {
+	case LiteralText:
+		return "string"
+	case LiteralBool:
+		return "boolean"
+	case LiteralHex:
+		return "hex"
+	}
+	return "integer"
+}
+
+// checkVariant makes sure that every case label has the same kind as the
+// switch type, and that each value is handled at most once.
+func (c *checker) checkVariant(v Variant) {
+	var enum *Enum
+	switch t := Underlying(v.SwitchType).(type) {
+	case Int, Uint, SizedInt, Bool:
+	case DerivedType:
+		if t.Sym != nil {
+			e, ok := t.Sym.Decl().(Enum)
+			if !ok {
+				c.errorf(v.SwitchType.GetSpan(),
+					"switch type of %s must be an enum, an integer type or Bool", v.Ident.Name)
+				return
+			}
+			enum = &e
+		}
+	default:
+		c.errorf(v.SwitchType.GetSpan(),
+			"switch type of %s must be an enum, an integer type or Bool", v.Ident.Name)
+		return
+	}
+
+	res := c.reservations(v.Ident, v.Reserved)
+	var dflt *Case
+	labels := make(map[string]Span)
+	positions := make(map[int]Case)
+	for _, cs := range v.Cases {
+		if cs.Position != nil {
+			res.checkPos(c, cs.Span, "case", *cs.Position, v.Ident)
+			if prev, found := positions[*cs.Position]; found {
+				c.errorf(cs.Span, "duplicate case position @%d in %s (previously at %s)",
+					*cs.Position, v.Ident.Name, prev.Span.Start)
+			} else {
+				positions[*cs.Position] = cs
+			}
+		}
+		if cs.Labels == nil {
+			if dflt != nil {
+				c.errorf(cs.Span, "multiple default cases in %s (previously at %s)",
+					v.Ident.Name, dflt.Span.Start)
+			} else {
+				dflt = &cs
+			}
+			continue
+		}
+		for _, l := range cs.Labels {
+			if id, isIdent := l.(CaseLabelIdentifier); isIdent {
+				res.checkName(c, id.Ident, v.Ident)
+			}
+			key, ok := c.checkCaseLabel(v, enum, l)
+			if !ok {
+				continue
+			}
+			if prev, found := labels[key]; found {
+				c.errorf(l.GetSpan(), "duplicate case %s in %s (previously at %s)",
+					key, v.Ident.Name, prev.Start)
+				continue
+			}
+			labels[key] = l.GetSpan()
+		}
+	}
+
+	if c.warnExhaustive && enum != nil && dflt == nil {
+		var missing []string
+		for _, ev := range enum.Values {
+			if _, found := labels[ev.Ident.Name]; !found {
+				missing = append(missing, ev.Ident.Name)
+			}
+		}
+		if len(missing) > 0 {
+			c.warnf(v.Ident.Span, "variant %s has no default case and doesn't handle %s",
+				v.Ident.Name, strings.Join(missing, ", "))
+		}
+	}
+}
+
+// checkCaseLabel checks one case label against the variant's switch type. If
+// it's OK, it returns the label's value as a string, for spotting duplicates.
+func (c *checker) checkCaseLabel(v Variant, enum *Enum, l CaseLabel) (string, bool) {
+	mismatch := func(kind string) (string, bool) {
+		c.errorf(l.GetSpan(), "%s case label in %s, which switches on %s",
+			kind, v.Ident.Name, typeDescription(v.SwitchType))
+		return "", false
+	}
+	sw := Underlying(v.SwitchType)
+	if dt, ok := sw.(DerivedType); ok && dt.Sym == nil {
+		// Switch type is imported from another language, so we can't tell
+		// what kind of label it wants, let alone check the value. All we
+		// can do is look for duplicates.
+		return caseLabelKey(l), true
+	}
+	switch l := l.(type) {
+	case CaseLabelIdentifier:
+		if _, isDerived := sw.(DerivedType); !isDerived {
+			return mismatch("enum")
+		}
+		for _, ev := range enum.Values {
+			if ev.Ident.Name == l.Ident.Name {
+				return l.Ident.Name, true
+			}
+		}
+		c.errorf(l.Ident.Span, "%s is not a value of enum %s", l.Ident.Name, enum.Ident.Name)
+		return "", false
+	case CaseLabelNumber:
+		switch sw := sw.(type) {
+		case Int, Uint:
+			return fmt.Sprintf("%d", l.Num), true
+		case SizedInt:
+			if int64(l.Num) < sw.Min() || int64(l.Num) > sw.Max() {
+				c.errorf(l.Span, "case label %d in %s doesn't fit in %s",
+					l.Num, v.Ident.Name, sw.Name())
+				return "", false
+			}
+			return fmt.Sprintf("%d", l.Num), true
+		}
+		return mismatch("integer")
+	case CaseLabelBool:
+		if _, isBool := sw.(Bool); !isBool {
+			return mismatch("boolean")
+		}
+		return fmt.Sprintf("%t", l.Bool), true
+	}
+	return "", false
+}
+
+func typeDescription(t Type) string {
+	switch t := Underlying(t).(type) {
+	case Int:
+		return "Int"
+	case Uint:
+		return "Uint"
+	case SizedInt:
+		return t.Name()
+	case Float:
+		return fmt.Sprintf("Float%d", t.Bits)
+	case Bool:
+		return "Bool"
+	case DerivedType:
+		return "enum " + t.FullTypeName()
+	}
+	return "a non-switchable type"
+}
+
+func (c *checker) checkProtocol(p Protocol) {
+	res := c.reservations(p.Ident, p.Reserved)
+	names := make(map[string]Method)
+	positions := make(map[int]Method)
+	for _, m := range p.Methods {
+		res.checkPos(c, m.Span, "method "+m.Ident.Name, m.Pos, p.Ident)
+		res.checkName(c, m.Ident, p.Ident)
+		if prev, found := names[m.Ident.Name]; found {
+			c.errorf(m.Ident.Span, "duplicate method name %q in %s (previously at %s)",
+				m.Ident.Name, p.Ident.Name, prev.Span.Start)
+		} else {
+			names[m.Ident.Name] = m
+		}
+		if prev, found := positions[m.Pos]; found {
+			c.errorf(m.Span, "duplicate method position @%d in %s (already used by %q)",
+				m.Pos, p.Ident.Name, prev.Ident.Name)
+		} else {
+			positions[m.Pos] = m
+		}
+		var fields []Field
+		for _, prm := range m.Params {
+			fields = append(fields, prm.ToField())
+		}
+		c.checkFields(m.Ident, fields, nil)
+	}
+}
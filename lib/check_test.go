@@ -0,0 +1,374 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkHeader starts every source in these tests, since a file needs an ID.
+const checkHeader = "@0x8a9f2b3c4d5e6f70;\n"
+
+type checkTest struct {
+	name string
+	src  string   // after checkHeader, so line numbers start at 2
+	want []string // each diagnostic, as "line:col: msg"
+}
+
+// checkSource runs the checker over checkHeader+src, and returns what it
+// found, without the filename.
+func checkSource(t *testing.T, src string) []string {
+	t.Helper()
+	r, err := Parse([]byte(checkHeader+src), "t.snowp")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var ret []string
+	for _, d := range Check(r) {
+		ret = append(ret, strings.TrimPrefix(d.Error(), "t.snowp:"))
+	}
+	return ret
+}
+
+func runCheckTests(t *testing.T, tests []checkTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkSource(t, tt.src)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n\t%s\nwant:\n\t%s",
+					strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
+
+func TestCheckDeclarations(t *testing.T) {
+	runCheckTests(t, []checkTest{
+		{
+			name: "ok",
+			src: `struct S {
+    a @0 : Uint;
+    b @1 : List(Text);
+}
+typedef T = S;
+enum E {
+    A @0;
+    B @1;
+}`,
+		},
+		{
+			name: "undefined type",
+			src: `struct S {
+    a @0 : Nope;
+}`,
+			want: []string{`3:12: undefined type "Nope"`},
+		},
+		{
+			name: "duplicate fields",
+			src: `struct S {
+    a @0 : Uint;
+    a @1 : Uint;
+    b @1 : Uint;
+}`,
+			want: []string{
+				`4:5: duplicate field name "a" in S (previously at 3:5)`,
+				`5:5: duplicate field position @1 in S (already used by "a")`,
+			},
+		},
+		{
+			name: "circular typedef",
+			src: `typedef A = B;
+typedef B = A;`,
+			want: []string{
+				"2:9: typedef A is circular",
+				"3:9: typedef B is circular",
+			},
+		},
+		{
+			name: "duplicate enum values",
+			src: `enum E {
+    A @0;
+    A @1;
+    B @1;
+}`,
+			want: []string{
+				`4:5: duplicate enum value "A" in E (previously at 3:5)`,
+				`5:5: duplicate enum value @1 in E (already used by "A")`,
+			},
+		},
+	})
+}
+
+func TestCheckConsts(t *testing.T) {
+	runCheckTests(t, []checkTest{
+		{
+			name: "ok",
+			src: `const A : Uint = 3;
+const B : Int8 = -128;
+const C : Text = "hi";
+const D : Bool = true;
+const E : Blob(2) = 0xbeef;`,
+		},
+		{
+			name: "wrong kind",
+			src: `const A : Uint = "three";
+const B : Bool = 1;`,
+			want: []string{
+				"2:18: string value for constant A, which is Uint",
+				"3:18: integer value for constant B, which is Bool",
+			},
+		},
+		{
+			name: "out of range",
+			src: `const A : Uint8 = 256;
+const B : Uint = -1;`,
+			want: []string{
+				"2:19: value of constant A doesn't fit in Uint8",
+				"3:18: value of constant B doesn't fit in Uint",
+			},
+		},
+		{
+			name: "blob",
+			src: `const A : Blob(2) = 0xbee;
+const B : Blob(2) = 0xbeefee;`,
+			want: []string{
+				"2:21: blob constant A must have an even number of hex digits",
+				"3:21: blob constant B has 3 bytes, but its type has 2",
+			},
+		},
+		{
+			name: "used as a blob size",
+			src: `const N : Uint = 4;
+typedef T = Blob(N);`,
+		},
+	})
+}
+
+func TestCheckReserved(t *testing.T) {
+	runCheckTests(t, []checkTest{
+		{
+			name: "ok",
+			src: `struct S {
+    a @0 : Uint;
+    reserved @1, old;
+    c @2 : Text;
+}`,
+		},
+		{
+			name: "reserved gap",
+			src: `struct S {
+    a @0 : Uint;
+    reserved @1, @2;
+    d @3 : Text;
+}`,
+		},
+		{
+			name: "unreserved gap",
+			src: `struct S {
+    a @0 : Uint;
+    reserved @1;
+    d @3 : Text;
+}`,
+			want: []string{"2:8: warning: struct S doesn't use @2; mark retired positions with `reserved`"},
+		},
+		{
+			name: "reserved slot used",
+			src: `struct S {
+    reserved @0, old;
+    a @0 : Uint;
+    old @1 : Uint;
+}`,
+			want: []string{
+				`4:5: field a uses position @0, which is reserved in S (at 3:14)`,
+				`5:5: "old" is reserved in S (at 3:18)`,
+			},
+		},
+		{
+			name: "reserved twice",
+			src: `struct S {
+    reserved @0, @0, x, x;
+    a @1 : Uint;
+}`,
+			want: []string{
+				"3:18: @0 is reserved twice in S (previously at 3:14)",
+				`3:25: "x" is reserved twice in S (previously at 3:22)`,
+			},
+		},
+		{
+			name: "variant and protocol",
+			src: `variant V switch (t : Uint) {
+    reserved @0;
+    case 1 @0 : Text;
+}
+protocol P errors Text @0xcccccccc {
+    reserved @0;
+    m @0 ();
+}`,
+			want: []string{
+				"4:5: case uses position @0, which is reserved in V (at 3:14)",
+				"8:5: method m uses position @0, which is reserved in P (at 7:14)",
+			},
+		},
+	})
+}
+
+func TestCheckCaseLabels(t *testing.T) {
+	runCheckTests(t, []checkTest{
+		{
+			name: "enum",
+			src: `enum E {
+    A @0;
+    B @1;
+}
+variant V switch (e : E) {
+    case A @0 : Text;
+    case B : void;
+}`,
+		},
+		{
+			name: "not in the enum",
+			src: `enum E {
+    A @0;
+}
+variant V switch (e : E) {
+    case C : void;
+    default : void;
+}`,
+			want: []string{"6:10: C is not a value of enum E"},
+		},
+		{
+			name: "missing enum values",
+			src: `enum E {
+    A @0;
+    B @1;
+    C @2;
+}
+variant V switch (e : E) {
+    case A : void;
+}`,
+			want: []string{"7:9: warning: variant V has no default case and doesn't handle B, C"},
+		},
+		{
+			name: "wrong kind",
+			src: `enum E {
+    A @0;
+}
+variant V switch (e : E) {
+    case 1 : void;
+    default : void;
+}
+variant W switch (t : Uint) {
+    case true : void;
+    case A : void;
+}
+variant X switch (b : Bool) {
+    case 0 : void;
+}`,
+			want: []string{
+				"6:10: integer case label in V, which switches on enum E",
+				"10:10: boolean case label in W, which switches on Uint",
+				"11:10: enum case label in W, which switches on Uint",
+				"14:10: integer case label in X, which switches on Bool",
+			},
+		},
+		{
+			name: "out of range",
+			src: `variant V switch (t : Uint8) {
+    case 255 : void;
+    case 256 : void;
+}`,
+			want: []string{"4:10: case label 256 in V doesn't fit in Uint8"},
+		},
+		{
+			name: "duplicates",
+			src: `variant V switch (t : Int) {
+    case 1 @0 : Text;
+    case 2, 1 @0 : Text;
+    default : void;
+    default : void;
+}`,
+			want: []string{
+				"4:5: duplicate case position @0 in V (previously at 3:5)",
+				"4:13: duplicate case 1 in V (previously at 3:10)",
+				"6:5: multiple default cases in V (previously at 5:5)",
+			},
+		},
+		{
+			name: "bad switch type",
+			src: `struct S {
+    a @0 : Uint;
+}
+variant V switch (s : S) {
+    case 1 : void;
+}
+variant W switch (t : Text) {
+    case 1 : void;
+}`,
+			want: []string{
+				"5:23: switch type of V must be an enum, an integer type or Bool",
+				"8:23: switch type of W must be an enum, an integer type or Bool",
+			},
+		},
+		{
+			name: "foreign switch type",
+			src: `go:import "example.com/lib" as lib;
+variant V switch (t : lib.Typ) {
+    case 1 @0 : Text;
+    case 2 : void;
+    case true : void;
+    case X : void;
+}`,
+		},
+		{
+			name: "foreign switch type duplicates",
+			src: `go:import "example.com/lib" as lib;
+variant V switch (t : lib.Typ) {
+    case 1 : void;
+    case X : void;
+    case 1, X : void;
+}`,
+			want: []string{
+				"6:10: duplicate case 1 in V (previously at 4:10)",
+				"6:13: duplicate case X in V (previously at 5:10)",
+			},
+		},
+	})
+}
+
+func TestCheckMapKeys(t *testing.T) {
+	runCheckTests(t, []checkTest{
+		{
+			name: "ok",
+			src: `enum E {
+    A @0;
+}
+typedef Name = Text;
+struct S {
+    a @0 : Map(Text, Uint);
+    b @1 : Map(Int16, Text);
+    c @2 : Map(Bool, Text);
+    d @3 : Map(Blob(4), Text);
+    e @4 : Map(E, List(Text));
+    f @5 : Map(Name, Map(Uint, Bool));
+}`,
+		},
+		{
+			name: "bad keys",
+			src: `struct T {
+    a @0 : Uint;
+}
+struct S {
+    a @0 : Map(Blob, Text);
+    b @1 : Map(T, Text);
+    c @2 : Map(Float64, Text);
+    d @3 : Option(Map(T, Text));
+}`,
+			want: []string{
+				"6:16: map key must be Text, Bool, an integer type, a fixed-size Blob or an enum",
+				"7:16: map key must be Text, Bool, an integer type, a fixed-size Blob or an enum",
+				"8:16: map key must be Text, Bool, an integer type, a fixed-size Blob or an enum",
+				"9:23: map key must be Text, Bool, an integer type, a fixed-size Blob or an enum",
+			},
+		},
+	})
+}
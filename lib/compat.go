@@ -0,0 +1,666 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Change is one difference between two versions of a schema. A change is
+// breaking if peers built from the old schema can't talk to peers built
+// from the new one.
+type Change struct {
+	Breaking bool
+	Decl     string // e.g. "struct Foo"
+	Msg      string
+}
+
+func (c Change) String() string {
+	kind := "compatible"
+	if c.Breaking {
+		kind = "breaking"
+	}
+	return fmt.Sprintf("%s: %s: %s", kind, c.Decl, c.Msg)
+}
+
+// Compare reports how the declarations in newer differ from those in older,
+// in the order they appear in older, followed by anything that's new. Both
+// roots must already have been resolved.
+func Compare(older, newer *Root) []Change {
+	cc := &compatChecker{}
+	cc.compareFileID(older.Id, newer.Id)
+	olds := declsByName(older)
+	news := declsByName(newer)
+	for _, s := range older.Stmts {
+		nm := statementName(s)
+		if nm == "" {
+			continue
+		}
+		n, found := news[nm]
+		if !found {
+			cc.breaking(s, "was removed")
+			continue
+		}
+		cc.compare(s, n)
+	}
+	for _, s := range newer.Stmts {
+		nm := statementName(s)
+		if nm == "" {
+			continue
+		}
+		if _, found := olds[nm]; !found {
+			cc.compatible(s, "was added")
+		}
+	}
+	return cc.changes
+}
+
+func declsByName(r *Root) map[string]Statement {
+	ret := make(map[string]Statement)
+	for _, s := range r.Stmts {
+		if nm := statementName(s); nm != "" {
+			ret[nm] = s
+		}
+	}
+	return ret
+}
+
+type compatChecker struct {
+	changes []Change
+}
+
+func (cc *compatChecker) add(breaking bool, s Statement, format string, args ...any) {
+	cc.addDecl(breaking, statementDescription(s), format, args...)
+}
+
+func (cc *compatChecker) addDecl(breaking bool, decl string, format string, args ...any) {
+	cc.changes = append(cc.changes, Change{
+		Breaking: breaking,
+		Decl:     decl,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+func (cc *compatChecker) breaking(s Statement, format string, args ...any) {
+	cc.add(true, s, format, args...)
+}
+
+func (cc *compatChecker) compatible(s Statement, format string, args ...any) {
+	cc.add(false, s, format, args...)
+}
+
+func (cc *compatChecker) compare(o, n Statement) {
+	switch o := o.(type) {
+	case Typedef:
+		if n, ok := n.(Typedef); ok {
+			cc.compareUniqueID(n, o.UniqueID, n.UniqueID)
+			cc.compareType(n, "type", o.Type, n.Type)
+			return
+		}
+	case Struct:
+		if n, ok := n.(Struct); ok {
+			cc.compareUniqueID(n, o.UniqueID, n.UniqueID)
+			cc.compareFields(n, "field", o.Fields, n.Fields, n.Reserved)
+			return
+		}
+	case Enum:
+		if n, ok := n.(Enum); ok {
+			cc.compareEnum(o, n)
+			return
+		}
+	case Variant:
+		if n, ok := n.(Variant); ok {
+			cc.compareUniqueID(n, o.UniqueID, n.UniqueID)
+			cc.compareVariant(o, n)
+			return
+		}
+	case Protocol:
+		if n, ok := n.(Protocol); ok {
+			cc.compareUniqueID(n, o.UniqueID, n.UniqueID)
+			cc.compareProtocol(o, n)
+			return
+		}
+	case Const:
+		if n, ok := n.(Const); ok {
+			cc.compareConst(o, n)
+			return
+		}
+	}
+	cc.breaking(n, "was %s", statementDescription(o))
+}
+
+// compareFileID compares the unique IDs of the two files. Peers use it to
+// tell types from different files apart, so any change to it is breaking.
+func (cc *compatChecker) compareFileID(o, n UniqueID) {
+	if o.Val != n.Val {
+		cc.addDecl(true, "file", "changed unique ID from %s to %s",
+			uniqueIDSpelling(o), uniqueIDSpelling(n))
+	}
+}
+
+func uniqueIDSpelling(u UniqueID) string {
+	if u.IsZero() {
+		return "none"
+	}
+	return u.Val
+}
+
+func (cc *compatChecker) compareUniqueID(s Statement, o, n UniqueID) {
+	switch {
+	case o.Val == n.Val:
+	case o.IsZero():
+		cc.compatible(s, "added unique ID %s", n.Val)
+	case n.IsZero():
+		cc.breaking(s, "removed unique ID %s", o.Val)
+	default:
+		cc.breaking(s, "changed unique ID from %s to %s", o.Val, n.Val)
+	}
+}
+
+// compareType compares the types of two things that sit in the same place on
+// the wire. Option(T) and T are sent the same way, so switching between them
+// is fine, as is switching between a typedef and the type it stands for.
+func (cc *compatChecker) compareType(s Statement, what string, o, n Type) {
+	os, ns := typeSpelling(o), typeSpelling(n)
+	if os == ns {
+		return
+	}
+	if wireSpelling(stripOption(o), 0) == wireSpelling(stripOption(n), 0) {
+		cc.compatible(s, "%s changed from %s to %s", what, os, ns)
+		return
+	}
+	cc.breaking(s, "%s changed from %s to %s", what, os, ns)
+}
+
+// wireSpelling is like typeSpelling, but with typedefs replaced by their
+// underlying types all the way down, so that two types spell the same if
+// they're sent the same way.
+func wireSpelling(t Type, depth int) string {
+	if depth > maxTypedefDepth {
+		return typeSpelling(t)
+	}
+	switch t := Underlying(t).(type) {
+	case List:
+		return "List(" + wireSpelling(t.Type, depth+1) + ")"
+	case Option:
+		return "Option(" + wireSpelling(t.Type, depth+1) + ")"
+	case Future:
+		return "Future(" + wireSpelling(t.Type, depth+1) + ")"
+	case Map:
+		return "Map(" + wireSpelling(t.Key, depth+1) + ", " + wireSpelling(t.Value, depth+1) + ")"
+	default:
+		return typeSpelling(t)
+	}
+}
+
+func stripOption(t Type) Type {
+	if o, ok := t.(Option); ok {
+		return o.Type
+	}
+	return t
+}
+
+// compareFields compares the fields of a struct, or the parameters of a
+// method, by position, since that's how they're encoded.
+func (cc *compatChecker) compareFields(s Statement, what string, o, n []Field, reserved []Reserved) {
+	news := make(map[int]Field)
+	for _, f := range n {
+		news[f.Pos] = f
+	}
+	olds := make(map[int]bool)
+	isReserved := make(map[int]bool)
+	for _, r := range reserved {
+		if r.Pos != nil {
+			isReserved[*r.Pos] = true
+		}
+	}
+	for _, of := range o {
+		olds[of.Pos] = true
+		nf, found := news[of.Pos]
+		switch {
+		case !found && isReserved[of.Pos]:
+			cc.compatible(s, "retired %s %s @%d", what, of.Ident.Name, of.Pos)
+		case !found:
+			cc.breaking(s, "removed %s %s @%d without reserving its position",
+				what, of.Ident.Name, of.Pos)
+		default:
+			desc := fmt.Sprintf("%s %s @%d", what, of.Ident.Name, of.Pos)
+			if nf.Ident.Name != of.Ident.Name {
+				cc.compatible(s, "renamed %s to %s", desc, nf.Ident.Name)
+			}
+			cc.compareType(s, desc, of.Type, nf.Type)
+		}
+	}
+	for _, nf := range n {
+		if !olds[nf.Pos] {
+			cc.compatible(s, "added %s %s @%d", what, nf.Ident.Name, nf.Pos)
+		}
+	}
+}
+
+func (cc *compatChecker) compareEnum(o, n Enum) {
+	byName := make(map[string]EnumValue)
+	byNum := make(map[int]EnumValue)
+	for _, v := range n.Values {
+		byName[v.Ident.Name] = v
+		byNum[v.Num] = v
+	}
+	olds := make(map[int]bool)
+	for _, ov := range o.Values {
+		olds[ov.Num] = true
+		nv, found := byName[ov.Ident.Name]
+		switch {
+		case found && nv.Num != ov.Num:
+			cc.breaking(n, "value %s changed from @%d to @%d", ov.Ident.Name, ov.Num, nv.Num)
+		case found:
+		case byNum[ov.Num].Ident.Name != "":
+			cc.compatible(n, "renamed value %s @%d to %s",
+				ov.Ident.Name, ov.Num, byNum[ov.Num].Ident.Name)
+		default:
+			cc.breaking(n, "removed value %s @%d", ov.Ident.Name, ov.Num)
+		}
+	}
+	for _, nv := range n.Values {
+		if !olds[nv.Num] {
+			if _, found := byName[nv.Ident.Name]; found && isMovedValue(o, nv) {
+				continue
+			}
+			cc.compatible(n, "added value %s @%d", nv.Ident.Name, nv.Num)
+		}
+	}
+}
+
+// isMovedValue is true if v was already in o under a different number, in
+// which case compareEnum has already reported it.
+func isMovedValue(o Enum, v EnumValue) bool {
+	for _, ov := range o.Values {
+		if ov.Ident.Name == v.Ident.Name {
+			return ov.Num != v.Num
+		}
+	}
+	return false
+}
+
+// caseLabelKey names a case label the same way in both versions.
+func caseLabelKey(l CaseLabel) string {
+	switch l := l.(type) {
+	case CaseLabelIdentifier:
+		return l.Ident.Name
+	case CaseLabelNumber:
+		return fmt.Sprintf("%d", l.Num)
+	case CaseLabelBool:
+		return fmt.Sprintf("%t", l.Bool)
+	}
+	return ""
+}
+
+const defaultCaseKey = "default"
+
+func casesByLabel(v Variant) map[string]Case {
+	ret := make(map[string]Case)
+	for _, c := range v.Cases {
+		if c.Labels == nil {
+			ret[defaultCaseKey] = c
+		}
+		for _, l := range c.Labels {
+			ret[caseLabelKey(l)] = c
+		}
+	}
+	return ret
+}
+
+func casePosition(c Case) string {
+	if c.Position == nil {
+		return "no data"
+	}
+	return fmt.Sprintf("@%d", *c.Position)
+}
+
+func (cc *compatChecker) compareVariant(o, n Variant) {
+	cc.compareType(n, "switch type", o.SwitchType, n.SwitchType)
+	olds := casesByLabel(o)
+	news := casesByLabel(n)
+	var keys []string
+	for _, c := range o.Cases {
+		if c.Labels == nil {
+			keys = append(keys, defaultCaseKey)
+		}
+		for _, l := range c.Labels {
+			keys = append(keys, caseLabelKey(l))
+		}
+	}
+	for _, k := range keys {
+		oc := olds[k]
+		nc, found := news[k]
+		if !found {
+			cc.breaking(n, "removed case %s", k)
+			continue
+		}
+		op, np := casePosition(oc), casePosition(nc)
+		if op != np {
+			cc.breaking(n, "case %s moved from %s to %s", k, op, np)
+			continue
+		}
+		cc.compareType(n, "case "+k, oc.Type, nc.Type)
+	}
+	for _, c := range n.Cases {
+		if c.Labels == nil {
+			if _, found := olds[defaultCaseKey]; !found {
+				cc.compatible(n, "added default case")
+			}
+		}
+		for _, l := range c.Labels {
+			k := caseLabelKey(l)
+			if _, found := olds[k]; !found {
+				cc.compatible(n, "added case %s", k)
+			}
+		}
+	}
+}
+
+func (cc *compatChecker) compareProtocol(o, n Protocol) {
+	om, nm := o.Modifiers, n.Modifiers
+	cc.compareType(n, "errors type", om.Errors.Type, nm.Errors.Type)
+	cc.compareHeader(n, "arg_header", om.ArgHeader, nm.ArgHeader)
+	cc.compareHeader(n, "res_header", om.ResHeader, nm.ResHeader)
+	cc.compareMethods(n, o.Methods, n.Methods)
+}
+
+// compareMethods compares the methods of a protocol by position, since
+// that's how calls name them on the wire.
+func (cc *compatChecker) compareMethods(p Protocol, o, n []Method) {
+	news := make(map[int]Method)
+	newNames := make(map[string]Method)
+	for _, m := range n {
+		news[m.Pos] = m
+		newNames[m.Ident.Name] = m
+	}
+	olds := make(map[int]bool)
+	oldNames := make(map[string]Method)
+	isReserved := make(map[int]bool)
+	for _, r := range p.Reserved {
+		if r.Pos != nil {
+			isReserved[*r.Pos] = true
+		}
+	}
+	for _, m := range o {
+		olds[m.Pos] = true
+		oldNames[m.Ident.Name] = m
+	}
+	for _, m := range o {
+		nm, found := news[m.Pos]
+		if !found {
+			moved, isMoved := newNames[m.Ident.Name]
+			switch {
+			case isMoved && !olds[moved.Pos]:
+				cc.breaking(p, "method %s moved from @%d to @%d", m.Ident.Name, m.Pos, moved.Pos)
+			case isReserved[m.Pos]:
+				cc.compatible(p, "retired method %s @%d", m.Ident.Name, m.Pos)
+			default:
+				cc.breaking(p, "removed method %s @%d", m.Ident.Name, m.Pos)
+			}
+			continue
+		}
+		desc := fmt.Sprintf("method %s @%d", m.Ident.Name, m.Pos)
+		if nm.Ident.Name != m.Ident.Name {
+			cc.compatible(p, "renamed %s to %s", desc, nm.Ident.Name)
+		}
+		var of, nf []Field
+		for _, a := range m.Params {
+			of = append(of, a.ToField())
+		}
+		for _, a := range nm.Params {
+			nf = append(nf, a.ToField())
+		}
+		cc.compareFields(p, "method "+m.Ident.Name+" parameter", of, nf, nil)
+		cc.compareType(p, "method "+m.Ident.Name+" result", m.ResType, nm.ResType)
+	}
+	for _, m := range n {
+		if olds[m.Pos] {
+			continue
+		}
+		if om, found := oldNames[m.Ident.Name]; found {
+			if _, stillThere := news[om.Pos]; !stillThere {
+				continue // reported as moved
+			}
+		}
+		cc.compatible(p, "added method %s @%d", m.Ident.Name, m.Pos)
+	}
+}
+
+func headerType(h any) Type {
+	switch h := h.(type) {
+	case *ArgHeader:
+		if h != nil {
+			return h.Type
+		}
+	case *ResHeader:
+		if h != nil {
+			return h.Type
+		}
+	}
+	return Void{}
+}
+
+func (cc *compatChecker) compareHeader(s Statement, what string, o, n any) {
+	cc.compareType(s, what, headerType(o), headerType(n))
+}
+
+// compareConst reports changed values, which don't affect the wire, but
+// might still be worth a look.
+func (cc *compatChecker) compareConst(o, n Const) {
+	if typeSpelling(o.Type) != typeSpelling(n.Type) || o.Value.Raw != n.Value.Raw {
+		cc.compatible(n, "changed from %s = %s to %s = %s",
+			typeSpelling(o.Type), o.Value.Raw, typeSpelling(n.Type), n.Value.Raw)
+	}
+}
+
+// typeSpelling writes t out the way it would appear in a .snowp file.
+func typeSpelling(t Type) string {
+	switch t := t.(type) {
+	case nil:
+		return "void"
+	case Void:
+		return "void"
+	case Text:
+		return "Text"
+	case Int:
+		return "Int"
+	case Uint:
+		return "Uint"
+	case Bool:
+		return "Bool"
+	case SizedInt:
+		return t.Name()
+	case Float:
+		return fmt.Sprintf("Float%d", t.Bits)
+	case Blob:
+		if t.Count > 0 {
+			return fmt.Sprintf("Blob(%d)", t.Count)
+		}
+		return "Blob"
+	case List:
+		return "List(" + typeSpelling(t.Type) + ")"
+	case Option:
+		return "Option(" + typeSpelling(t.Type) + ")"
+	case Future:
+		return "Future(" + typeSpelling(t.Type) + ")"
+	case Map:
+		return "Map(" + typeSpelling(t.Key) + ", " + typeSpelling(t.Value) + ")"
+	case DerivedType:
+		return t.FullTypeName()
+	}
+	return fmt.Sprintf("%T", t)
+}
+
+// compatVersion is one version of a schema file, along with the other files
+// of its package as they were at the same time, since it can use their types
+// and constants.
+type compatVersion struct {
+	name     string
+	dat      []byte
+	siblings map[string][]byte // by name
+}
+
+// load parses and resolves v's file in the scope of its whole package.
+// Imports aren't followed, since types are compared by name. Only v's file
+// is validated; its siblings just have to parse and resolve.
+func (v *compatVersion) load() (*Root, error) {
+	r, err := Parse(v.dat, v.name)
+	if err != nil {
+		return nil, err
+	}
+	roots := []*Root{r}
+	names := make([]string, 0, len(v.siblings))
+	for nm := range v.siblings {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	for _, nm := range names {
+		sr, err := Parse(v.siblings[nm], nm)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, sr)
+	}
+	pkg, diags := NewPackage(filepath.Dir(v.name), roots)
+	for _, sr := range roots {
+		diags = append(diags, Resolve(sr, pkg.Scope, nil)...)
+	}
+	diags = append(diags, validate(r, true)...)
+	sortDiagnostics(diags)
+	errs, _ := splitWarnings(diags)
+	if len(errs) > 0 {
+		return nil, Diagnostics(errs)
+	}
+	return r, nil
+}
+
+// readCompatVersion reads file, and the other files with the same extension
+// in its directory, from disk. Files in skip aren't part of the package,
+// which is for comparing two files that sit side by side.
+func readCompatVersion(file string, skip ...string) (*compatVersion, error) {
+	dat, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	v := &compatVersion{name: file, dat: dat, siblings: make(map[string][]byte)}
+	dir := filepath.Dir(file)
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	skipped := map[string]bool{absOr(file): true}
+	for _, s := range skip {
+		skipped[absOr(s)] = true
+	}
+	for _, e := range ents {
+		p := filepath.Join(dir, e.Name())
+		if e.IsDir() || filepath.Ext(p) != filepath.Ext(file) || skipped[absOr(p)] {
+			continue
+		}
+		sdat, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		v.siblings[p] = sdat
+	}
+	return v, nil
+}
+
+// gitCompatVersion reads file, and the other files with the same extension
+// in its directory, as they were at a git revision.
+func gitCompatVersion(rev string, file string) (*compatVersion, error) {
+	dir, base := filepath.Split(file)
+	if dir == "" {
+		dir = "."
+	}
+	dat, err := gitOutput(dir, "show", rev+":./"+base)
+	if err != nil {
+		return nil, err
+	}
+	v := &compatVersion{name: rev + ":" + file, dat: dat, siblings: make(map[string][]byte)}
+	ls, err := gitOutput(dir, "ls-tree", "--name-only", rev, "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, nm := range strings.Split(strings.TrimSpace(string(ls)), "\n") {
+		if nm == "" || nm == base || filepath.Ext(nm) != filepath.Ext(base) {
+			continue
+		}
+		sdat, err := gitOutput(dir, "show", rev+":./"+nm)
+		if err != nil {
+			return nil, err
+		}
+		v.siblings[rev+":"+filepath.Join(dir, nm)] = sdat
+	}
+	return v, nil
+}
+
+// gitOutput runs git in dir and returns what it prints.
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// CompatOptions says which two versions of a schema to compare: either two
+// files, or one file against its copy at a git revision. Each version is
+// checked along with the rest of its package: the other files in its
+// directory, as they were at the same time.
+type CompatOptions struct {
+	Old string
+	New string
+	Rev string
+}
+
+// RunCompat writes a report of the changes between two versions of a schema
+// to w, and returns an error if any of them are breaking.
+func RunCompat(o CompatOptions, w io.Writer) error {
+	var ov *compatVersion
+	var err error
+	if o.Rev != "" {
+		ov, err = gitCompatVersion(o.Rev, o.New)
+	} else {
+		ov, err = readCompatVersion(o.Old, o.New)
+	}
+	if err != nil {
+		return err
+	}
+	nv, err := readCompatVersion(o.New, o.Old)
+	if err != nil {
+		return err
+	}
+	older, err := ov.load()
+	if err != nil {
+		return err
+	}
+	newer, err := nv.load()
+	if err != nil {
+		return err
+	}
+
+	var nBreaking int
+	for _, c := range Compare(older, newer) {
+		fmt.Fprintln(w, c.String())
+		if c.Breaking {
+			nBreaking++
+		}
+	}
+	if nBreaking > 0 {
+		return fmt.Errorf("%d breaking change(s)", nBreaking)
+	}
+	return nil
+}
+
+var errCompatArgs = errors.New("compat takes OLD NEW, or --rev REV FILE")
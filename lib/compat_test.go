@@ -0,0 +1,411 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compareSources compares two versions of a file, each with the other files
+// of its package, and returns the changes as strings.
+func compareSources(t *testing.T, older, newer string, oldSibs, newSibs map[string][]byte) []string {
+	t.Helper()
+	ov := &compatVersion{name: "old.snowp", dat: []byte(checkHeader + older), siblings: oldSibs}
+	nv := &compatVersion{name: "new.snowp", dat: []byte(checkHeader + newer), siblings: newSibs}
+	o, err := ov.load()
+	if err != nil {
+		t.Fatalf("old: %v", err)
+	}
+	n, err := nv.load()
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	var ret []string
+	for _, c := range Compare(o, n) {
+		ret = append(ret, c.String())
+	}
+	return ret
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name  string
+		older string
+		newer string
+		want  []string
+	}{
+		{
+			name:  "same",
+			older: "struct S { a @0 : Uint; }",
+			newer: "struct S {\n    a @0 : Uint; // now with a comment\n}",
+		},
+		{
+			name:  "added and removed",
+			older: "struct S { a @0 : Uint; }",
+			newer: "struct T { a @0 : Uint; }",
+			want: []string{
+				"breaking: struct S: was removed",
+				"compatible: struct T: was added",
+			},
+		},
+		{
+			name:  "kind",
+			older: "typedef S = Uint;",
+			newer: "struct S { a @0 : Uint; }",
+			want:  []string{"breaking: struct S: was typedef S"},
+		},
+		{
+			name: "unique IDs",
+			older: `struct A { a @0 : Uint; }
+struct B @0x8a9f2b3c4d5e6f71 { a @0 : Uint; }
+struct C @0x8a9f2b3c4d5e6f72 { a @0 : Uint; }`,
+			newer: `struct A @0x8a9f2b3c4d5e6f73 { a @0 : Uint; }
+struct B { a @0 : Uint; }
+struct C @0x8a9f2b3c4d5e6f74 { a @0 : Uint; }`,
+			want: []string{
+				"compatible: struct A: added unique ID 0x8a9f2b3c4d5e6f73",
+				"breaking: struct B: removed unique ID 0x8a9f2b3c4d5e6f71",
+				"breaking: struct C: changed unique ID from 0x8a9f2b3c4d5e6f72 to 0x8a9f2b3c4d5e6f74",
+			},
+		},
+		{
+			name:  "fields",
+			older: "struct S { a @0 : Uint; b @1 : Text; c @2 : Bool; d @3 : Int; e @4 : Text; }",
+			newer: "struct S { a @0 : Uint; reserved @1; cc @2 : Bool; d @3 : Text; e @4 : Option(Text); f @5 : Uint; }",
+			want: []string{
+				"compatible: struct S: retired field b @1",
+				"compatible: struct S: renamed field c @2 to cc",
+				"breaking: struct S: field d @3 changed from Int to Text",
+				"compatible: struct S: field e @4 changed from Text to Option(Text)",
+				"compatible: struct S: added field f @5",
+			},
+		},
+		{
+			name:  "field removed without reserving",
+			older: "struct S { a @0 : Uint; b @1 : Text; }",
+			newer: "struct S { a @0 : Uint; }",
+			want:  []string{"breaking: struct S: removed field b @1 without reserving its position"},
+		},
+		{
+			name:  "enum",
+			older: "enum E { A @0; B @1; C @2; D @3; }",
+			newer: "enum E { A @0; B @4; CC @2; F @5; }",
+			want: []string{
+				"breaking: enum E: value B changed from @1 to @4",
+				"compatible: enum E: renamed value C @2 to CC",
+				"breaking: enum E: removed value D @3",
+				"compatible: enum E: added value F @5",
+			},
+		},
+		{
+			name: "variant",
+			older: `variant V switch (t : Uint) {
+    case 1 @0 : Text;
+    case 2 @1 : Uint;
+    case 3 : void;
+}`,
+			newer: `variant V switch (t : Uint) {
+    case 1 @0 : Uint;
+    case 2 @2 : Uint;
+    case 4 : void;
+    default : void;
+}`,
+			want: []string{
+				"breaking: variant V: case 1 changed from Text to Uint",
+				"breaking: variant V: case 2 moved from @1 to @2",
+				"breaking: variant V: removed case 3",
+				"compatible: variant V: added case 4",
+				"compatible: variant V: added default case",
+			},
+		},
+		{
+			name: "protocol",
+			older: `protocol P errors Text @0xcccccccc {
+    a @0 (x @0 : Uint) -> Text;
+    b @1 ();
+    c @2 ();
+}`,
+			newer: `protocol P errors Text @0xcccccccc {
+    a @0 (x @0 : Uint, y @1 : Text) -> Uint;
+    b @3 ();
+    d @4 ();
+}`,
+			want: []string{
+				"compatible: protocol P: added method a parameter y @1",
+				"breaking: protocol P: method a result changed from Text to Uint",
+				"breaking: protocol P: method b moved from @1 to @3",
+				"breaking: protocol P: removed method c @2",
+				"compatible: protocol P: added method d @4",
+			},
+		},
+		{
+			name: "methods by position",
+			older: `protocol P errors Text @0xcccccccc {
+    a @0 (x @0 : Uint) -> Text;
+    b @1 (y @0 : Text) -> Uint;
+    c @2 ();
+    d @3 ();
+}`,
+			newer: `protocol P errors Text @0xcccccccc {
+    reserved @2;
+    b @0 (x @0 : Uint) -> Text;
+    a @1 (y @0 : Text) -> Uint;
+    dd @3 ();
+}`,
+			want: []string{
+				"compatible: protocol P: renamed method a @0 to b",
+				"compatible: protocol P: renamed method b @1 to a",
+				"compatible: protocol P: retired method c @2",
+				"compatible: protocol P: renamed method d @3 to dd",
+			},
+		},
+		{
+			name: "method swapped with a different signature",
+			older: `protocol P errors Text @0xcccccccc {
+    a @0 (x @0 : Uint) -> Text;
+    b @1 (y @0 : Text) -> Uint;
+}`,
+			newer: `protocol P errors Text @0xcccccccc {
+    b @0 (y @0 : Text) -> Uint;
+    a @1 (x @0 : Uint) -> Text;
+}`,
+			want: []string{
+				"compatible: protocol P: renamed method a @0 to b",
+				"compatible: protocol P: renamed method a parameter x @0 to y",
+				"breaking: protocol P: method a parameter x @0 changed from Uint to Text",
+				"breaking: protocol P: method a result changed from Text to Uint",
+				"compatible: protocol P: renamed method b @1 to a",
+				"compatible: protocol P: renamed method b parameter y @0 to x",
+				"breaking: protocol P: method b parameter y @0 changed from Text to Uint",
+				"breaking: protocol P: method b result changed from Uint to Text",
+			},
+		},
+		{
+			name: "method moved onto a removed one",
+			older: `protocol P errors Text @0xcccccccc {
+    a @0 ();
+    b @1 (x @0 : Uint);
+}`,
+			newer: `protocol P errors Text @0xcccccccc {
+    b @0 (x @0 : Uint);
+}`,
+			want: []string{
+				"compatible: protocol P: renamed method a @0 to b",
+				"compatible: protocol P: added method a parameter x @0",
+				"breaking: protocol P: removed method b @1",
+			},
+		},
+		{
+			name: "typedefs and their underlying types",
+			older: `typedef Key = Blob(4);
+typedef Keys = List(Key);
+struct S {
+    a @0 : Blob(4);
+    b @1 : List(Key);
+    c @2 : Keys;
+    d @3 : Key;
+    e @4 : Map(Text, Key);
+}`,
+			newer: `typedef Key = Blob(4);
+typedef Keys = List(Key);
+struct S {
+    a @0 : Key;
+    b @1 : List(Blob(4));
+    c @2 : Option(List(Blob(4)));
+    d @3 : Blob(8);
+    e @4 : Map(Text, Blob);
+}`,
+			want: []string{
+				"compatible: struct S: field a @0 changed from Blob(4) to Key",
+				"compatible: struct S: field b @1 changed from List(Key) to List(Blob(4))",
+				"compatible: struct S: field c @2 changed from Keys to Option(List(Blob(4)))",
+				"breaking: struct S: field d @3 changed from Key to Blob(8)",
+				"breaking: struct S: field e @4 changed from Map(Text, Key) to Map(Text, Blob)",
+			},
+		},
+		{
+			name:  "struct isn't its fields",
+			older: "struct A { x @0 : Uint; }\nstruct S { a @0 : A; }",
+			newer: "struct A { x @0 : Uint; }\nstruct S { a @0 : Uint; }",
+			want:  []string{"breaking: struct S: field a @0 changed from A to Uint"},
+		},
+		{
+			name:  "const",
+			older: "const N : Uint = 3;\nconst M : Uint = 3;",
+			newer: "const N : Uint = 4;\nconst M : Uint = 3;",
+			want:  []string{"compatible: const N: changed from Uint = 3 to Uint = 4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareSources(t, tt.older, tt.newer, nil, nil)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n\t%s\nwant:\n\t%s",
+					strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
+
+// TestCompareFileID makes sure that a change to a file's unique ID is
+// breaking, since peers use it to tell types from different files apart.
+func TestCompareFileID(t *testing.T) {
+	tests := []struct {
+		name         string
+		older, newer string
+		want         []string
+	}{
+		{name: "same", older: "@0x8a9f2b3c4d5e6f70;", newer: "@0x8a9f2b3c4d5e6f70;"},
+		{
+			name: "changed", older: "@0x8a9f2b3c4d5e6f70;", newer: "@0x8a9f2b3c4d5e6f71;",
+			want: []string{"breaking: file: changed unique ID from 0x8a9f2b3c4d5e6f70 to 0x8a9f2b3c4d5e6f71"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			load := func(name, header string) *Root {
+				v := &compatVersion{name: name, dat: []byte(header + "\nstruct S { a @0 : Uint; }")}
+				r, err := v.load()
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				return r
+			}
+			var got []string
+			for _, c := range Compare(load("old.snowp", tt.older), load("new.snowp", tt.newer)) {
+				got = append(got, c.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n\t%s\nwant:\n\t%s",
+					strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
+
+// TestCompareAcrossFiles compares files whose types and constants come from
+// other files of the same package.
+func TestCompareAcrossFiles(t *testing.T) {
+	const file = `struct S {
+    b @0 : B;
+    k @1 : Blob(N);
+}`
+	sibling := func(n string) map[string][]byte {
+		return map[string][]byte{
+			"b.snowp": []byte(`@0x8a9f2b3c4d5e6f7f;
+const N : Uint = ` + n + `;
+struct B { x @0 : Uint; }`),
+		}
+	}
+	tests := []struct {
+		name       string
+		older      string
+		newer      string
+		oldN, newN string
+		want       []string
+	}{
+		{name: "nothing changed", older: file, newer: file, oldN: "16", newN: "16"},
+		{
+			name: "sibling constant changed", older: file, newer: file, oldN: "16", newN: "32",
+			want: []string{"breaking: struct S: field k @1 changed from Blob(16) to Blob(32)"},
+		},
+		{
+			name: "constant inlined", older: file, oldN: "16", newN: "16",
+			newer: strings.Replace(file, "Blob(N)", "Blob(16)", 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareSources(t, tt.older, tt.newer, sibling(tt.oldN), sibling(tt.newN))
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n\t%s\nwant:\n\t%s",
+					strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+
+	// Without its sibling, the file doesn't make sense.
+	v := &compatVersion{name: "a.snowp", dat: []byte(checkHeader + file)}
+	_, err := v.load()
+	var diags Diagnostics
+	if !errors.As(err, &diags) || !strings.Contains(err.Error(), `undefined type "B"`) {
+		t.Errorf("load without sibling: got %v", err)
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for nm, dat := range files {
+		p := filepath.Join(dir, nm)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(dat), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRunCompatSideBySide compares two files in the same directory, which
+// share their other files, but aren't part of each other's package.
+func TestRunCompatSideBySide(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"old.snowp": checkHeader + "struct S { b @0 : B; }",
+		"new.snowp": checkHeader + "struct S { b @0 : B; c @1 : Uint; }",
+		"b.snowp":   "@0x8a9f2b3c4d5e6f7f;\nstruct B { x @0 : Uint; }",
+		"other.txt": "not a schema",
+	})
+	var out strings.Builder
+	err := RunCompat(CompatOptions{
+		Old: filepath.Join(dir, "old.snowp"),
+		New: filepath.Join(dir, "new.snowp"),
+	}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "compatible: struct S: added field c @1\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// TestRunCompatRev compares a file against the way it, and the rest of its
+// package, were at a git revision.
+func TestRunCompatRev(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	git("init", "-q")
+	writeFiles(t, dir, map[string]string{
+		"p/a.snowp": checkHeader + "struct A { b @0 : B; k @1 : Blob(N); }",
+		"p/b.snowp": "@0x8a9f2b3c4d5e6f7f;\nconst N : Uint = 16;\nstruct B { x @0 : Uint; }",
+	})
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+
+	writeFiles(t, dir, map[string]string{
+		"p/b.snowp": "@0x8a9f2b3c4d5e6f7f;\nconst N : Uint = 32;\nstruct B { x @0 : Uint; }",
+	})
+	var out strings.Builder
+	err := RunCompat(CompatOptions{Rev: "HEAD", New: filepath.Join(dir, "p", "a.snowp")}, &out)
+	if err == nil || err.Error() != "1 breaking change(s)" {
+		t.Errorf("got error %v; want 1 breaking change", err)
+	}
+	if got, want := out.String(), "breaking: struct A: field k @1 changed from Blob(16) to Blob(32)\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,253 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configNames are the project config files we look for, in each directory
+// from the working directory up, in this order.
+var configNames = []string{"snowpc.yaml", "snowpc.yml", "snowpc.json"}
+
+// Config is a project's snowpc.yaml (or .json). It lists the targets to
+// generate, so that `snowpc gen` with no input flags builds all of them. The
+// settings at the top apply to every target, and to gen runs with flags too.
+// Paths are relative to the directory the config is in. Flags given on the
+// command line override whatever the config says.
+//
+//	ext: .snowp
+//	import-path: [vendor/snowp]
+//	go-import-paths:
+//	  common: github.com/acme/common/proto
+//	targets:
+//	  - name: go
+//	    input: proto
+//	    output: go/proto
+//	    package: proto
+//	    exclude: ["*_test.snowp"]
+//	  - name: ts
+//	    lang: ts
+//	    input: proto/**/*.snowp
+//	    output: ts/src/proto
+type Config struct {
+	Ext            string            `yaml:"ext" json:"ext"`
+	ImportPath     []string          `yaml:"import-path" json:"import-path"`
+	WarnExhaustive bool              `yaml:"warn-exhaustive" json:"warn-exhaustive"`
+	GoImportPaths  map[string]string `yaml:"go-import-paths" json:"go-import-paths"`
+	Targets        []Target          `yaml:"targets" json:"targets"`
+
+	file string // where we read it from
+	dir  string // and its directory, which paths are relative to
+}
+
+// Target is one set of outputs to generate. Input is a .snowp file, a
+// directory of them, or a glob, and Output is the file or directory to
+// match. A glob is matched against whole paths, with `**` standing for any
+// number of directories; the directories before its first wildcard are
+// where the input tree starts, and the output mirrors the tree from there.
+// Include and Exclude globs, matched against paths relative to that, narrow
+// it down further.
+type Target struct {
+	Name    string   `yaml:"name" json:"name"`
+	Lang    string   `yaml:"lang" json:"lang"`
+	Input   string   `yaml:"input" json:"input"`
+	Output  string   `yaml:"output" json:"output"`
+	Package string   `yaml:"package" json:"package"`
+	Include []string `yaml:"include" json:"include"`
+	Exclude []string `yaml:"exclude" json:"exclude"`
+
+	// GoImportPaths maps the aliases of generic imports onto the Go import
+	// paths of the code generated from them, for when snowpc can't work it
+	// out from go.mod. Each works like a `go:import` of that alias in every
+	// file that doesn't have one of its own. These add to the ones at the top
+	// of the config.
+	GoImportPaths map[string]string `yaml:"go-import-paths" json:"go-import-paths"`
+}
+
+// findConfig looks for a config file in dir and then in each directory
+// above it. It returns "" if there isn't one.
+func findConfig(dir string) string {
+	for d := absOr(dir); ; d = filepath.Dir(d) {
+		for _, nm := range configNames {
+			p := filepath.Join(d, nm)
+			if st, err := os.Stat(p); err == nil && !st.IsDir() {
+				return p
+			}
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// LoadConfig reads the config file at path. Fields it doesn't know about
+// are errors, since they're most likely typos.
+func LoadConfig(path string) (*Config, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(dat))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&c)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(dat))
+		dec.KnownFields(true)
+		err = dec.Decode(&c)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c.file = path
+	c.dir = filepath.Dir(absOr(path))
+	for i, t := range c.Targets {
+		if t.Input == "" || t.Output == "" {
+			return nil, fmt.Errorf("%s: target %s needs an input and an output",
+				path, targetName(t, i))
+		}
+		if _, glob := splitGlob(t.Input); glob != "" {
+			if !validGlob(glob) {
+				return nil, fmt.Errorf("%s: target %s has a bad input glob %q",
+					path, targetName(t, i), t.Input)
+			}
+		}
+	}
+	for _, m := range append([]map[string]string{c.GoImportPaths}, targetGoImports(c.Targets)...) {
+		for alias := range m {
+			if !isIdentifier(alias) {
+				return nil, fmt.Errorf("%s: go-import-paths: %q isn't an import alias", path, alias)
+			}
+		}
+	}
+	return &c, nil
+}
+
+func targetGoImports(ts []Target) []map[string]string {
+	var ret []map[string]string
+	for _, t := range ts {
+		ret = append(ret, t.GoImportPaths)
+	}
+	return ret
+}
+
+// splitGlob splits an input path into the directory that comes before any
+// wildcards, and the glob that's left, which is empty if there isn't one.
+func splitGlob(p string) (string, string) {
+	elems := strings.Split(filepath.ToSlash(p), "/")
+	for i, e := range elems {
+		if strings.ContainsAny(e, "*?[") {
+			return filepath.FromSlash(strings.Join(elems[:i], "/")), strings.Join(elems[i:], "/")
+		}
+	}
+	return p, ""
+}
+
+func targetName(t Target, i int) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// path resolves p, which is relative to the config's directory. It doesn't
+// depend on the working directory, and nor does anything generated from it.
+func (c *Config) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.dir, filepath.FromSlash(p))
+}
+
+// applyDefaults fills in o from the settings at the top of the config,
+// except for those that were set with flags.
+func (c *Config) applyDefaults(o *Options, changed func(string) bool) {
+	if c.Ext != "" && !changed("ext") {
+		o.ext = c.Ext
+	}
+	if len(c.ImportPath) > 0 && !changed("import-path") {
+		o.importPath = nil
+		for _, p := range c.ImportPath {
+			o.importPath = append(o.importPath, c.path(p))
+		}
+	}
+	if c.WarnExhaustive && !changed("warn-exhaustive") {
+		o.warnExhaustive = true
+	}
+	o.goImports = mergeGoImports(c.GoImportPaths)
+}
+
+// mergeGoImports merges maps of aliases onto Go import paths, later ones
+// winning.
+func mergeGoImports(maps ...map[string]string) map[string]string {
+	ret := make(map[string]string)
+	for _, m := range maps {
+		for alias, gp := range m {
+			ret[alias] = gp
+		}
+	}
+	return ret
+}
+
+// selectTargets returns the targets named, or all of them if none are.
+func (c *Config) selectTargets(names []string) ([]Target, error) {
+	if len(c.Targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets", c.file)
+	}
+	if len(names) == 0 {
+		return c.Targets, nil
+	}
+	var ret []Target
+	for _, nm := range names {
+		i := slices.IndexFunc(c.Targets, func(t Target) bool { return t.Name == nm })
+		if i < 0 {
+			return nil, fmt.Errorf("%s: no target named %s", c.file, nm)
+		}
+		ret = append(ret, c.Targets[i])
+	}
+	return ret, nil
+}
+
+// targetOptions makes the options for building t, starting from o, which
+// already has the config's defaults in it. Flags still win.
+func (c *Config) targetOptions(o *Options, t Target, changed func(string) bool) *Options {
+	ret := *o
+	if t.Lang != "" && !changed("lang") {
+		ret.langRaw = t.Lang
+	}
+	if t.Package != "" && !changed("package") {
+		ret.pkg = t.Package
+	}
+	if len(t.Include) > 0 && !changed("include") {
+		ret.include = t.Include
+	}
+	if len(t.Exclude) > 0 && !changed("exclude") {
+		ret.exclude = t.Exclude
+	}
+	in, glob := splitGlob(t.Input)
+	in, out := c.path(in), c.path(t.Output)
+	switch {
+	case glob != "":
+		ret.indir, ret.outdir, ret.inputGlob = in, out, glob
+	case isDir(in):
+		ret.indir, ret.outdir = in, out
+	default:
+		ret.infile, ret.outfile = in, out
+	}
+	ret.goImports = mergeGoImports(c.GoImportPaths, t.GoImportPaths)
+	ret.baseDir = c.dir
+	return &ret
+}
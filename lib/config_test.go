@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildTargets builds every target of the config in dir, as snowpc gen
+// with no input flags would, after making the output directories.
+func buildTargets(t *testing.T, dir string, outdirs ...string) {
+	t.Helper()
+	for _, od := range outdirs {
+		if err := os.MkdirAll(filepath.Join(dir, od), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := LoadConfig(filepath.Join(dir, "snowpc.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := func(string) bool { return false }
+	o := &Options{langRaw: "go", ext: ".snowp"}
+	cfg.applyDefaults(o, changed)
+	ts, err := cfg.selectTargets(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tg := range ts {
+		to := cfg.targetOptions(o, tg, changed)
+		if err := to.check(); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewRunner(to).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// outputs lists the files under dir, relative to it.
+func outputs(t *testing.T, dir string) []string {
+	t.Helper()
+	var ret []string
+	err := filepath.WalkDir(dir, func(p string, ent os.DirEntry, err error) error {
+		if err != nil || ent.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		ret = append(ret, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestConfigInputGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"snowpc.yaml": `targets:
+  - input: proto/*/*.snowp
+    output: out
+    exclude: ["*_test.snowp"]
+`,
+		"proto/top.snowp":       "@0x8a9f2b3c4d5e6f70;\nstruct T { x @0 : Uint; }",
+		"proto/a/a.snowp":       "@0x8a9f2b3c4d5e6f71;\nstruct A { x @0 : Uint; }",
+		"proto/a/a_test.snowp":  "@0x8a9f2b3c4d5e6f72;\nstruct AT { x @0 : Uint; }",
+		"proto/a/deep/d.snowp":  "@0x8a9f2b3c4d5e6f73;\nstruct D { x @0 : Uint; }",
+		"proto/b/b.snowp":       "@0x8a9f2b3c4d5e6f74;\nstruct B { x @0 : Uint; }",
+		"proto/b/notes.txt":     "not a schema",
+		"proto/.hidden/h.snowp": "@0x8a9f2b3c4d5e6f75;\nstruct H { x @0 : Uint; }",
+	})
+	buildTargets(t, dir, "out")
+	got := strings.Join(outputs(t, filepath.Join(dir, "out")), " ")
+	if want := ".snowpc-manifest.json a/a.go b/b.go"; got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestConfigGoImports(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"snowpc.yaml": `go-import-paths:
+  lib: example.com/top/lib
+targets:
+  - input: proto/top
+    output: out/top
+    package: top
+  - input: proto/other
+    output: out/other
+    package: other
+    go-import-paths:
+      lib: example.com/other/lib
+`,
+		"proto/lib/b.snowp": "@0x8a9f2b3c4d5e6f70;\nstruct B { x @0 : Uint; }",
+		"proto/top/a.snowp": `@0x8a9f2b3c4d5e6f71;
+import "../lib" as lib;
+struct A { b @0 : lib.B; }`,
+		"proto/top/c.snowp": `@0x8a9f2b3c4d5e6f72;
+import "../lib" as lib;
+go:import "example.com/mine/lib" as lib;
+struct C { b @0 : lib.B; }`,
+		"proto/other/o.snowp": `@0x8a9f2b3c4d5e6f73;
+import "../lib" as lib;
+struct O { b @0 : lib.B; }`,
+	})
+	buildTargets(t, dir, "out/top", "out/other")
+	for file, want := range map[string]string{
+		"out/top/a.go":   `lib "example.com/top/lib"`,
+		"out/top/c.go":   `lib "example.com/mine/lib"`,
+		"out/other/o.go": `lib "example.com/other/lib"`,
+	} {
+		dat, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(dat), "example.com/"); n != 1 || !strings.Contains(string(dat), want) {
+			t.Errorf("%s: want one import, %s:\n%s", file, want, dat)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want string
+	}{
+		{
+			name: "no output",
+			cfg:  "targets:\n  - input: proto\n",
+			want: "target #1 needs an input and an output",
+		},
+		{
+			name: "bad glob",
+			cfg:  "targets:\n  - name: go\n    input: proto/[a-/*.snowp\n    output: out\n",
+			want: `target go has a bad input glob "proto/[a-/*.snowp"`,
+		},
+		{
+			name: "directory for an alias",
+			cfg:  "go-import-paths:\n  proto/lib: example.com/lib\n",
+			want: `go-import-paths: "proto/lib" isn't an import alias`,
+		},
+		{
+			name: "keyword for an alias",
+			cfg:  "targets:\n  - input: proto\n    output: out\n    go-import-paths:\n      struct: example.com/lib\n",
+			want: `go-import-paths: "struct" isn't an import alias`,
+		},
+		{
+			name: "unknown field",
+			cfg:  "target:\n  - input: proto\n",
+			want: "field target not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "snowpc.yaml")
+			if err := os.WriteFile(p, []byte(tt.cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(p)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v; want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		glob, rel string
+		want      bool
+	}{
+		{"*.snowp", "a.snowp", true},
+		{"*.snowp", "x/a.snowp", false},
+		{"*/*.snowp", "x/a.snowp", true},
+		{"*/*.snowp", "x/y/a.snowp", false},
+		{"**/*.snowp", "a.snowp", true},
+		{"**/*.snowp", "x/y/a.snowp", true},
+		{"x/**/a.snowp", "x/a.snowp", true},
+		{"x/**/a.snowp", "y/z/a.snowp", false},
+		{"**", "x/y/a.snowp", true},
+		{"x/**", "y/a.snowp", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.glob, tt.rel); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %t; want %t", tt.glob, tt.rel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError   Severity = iota
+	SeverityWarning Severity = iota
+)
+
+// Diagnostic is one problem found in a .snowp file. Errors stop the compile;
+// warnings are printed but code is still emitted.
+type Diagnostic struct {
+	Filename string
+	Pos      Pos
+	Msg      string
+	Severity Severity
+}
+
+func (d Diagnostic) IsWarning() bool { return d.Severity == SeverityWarning }
+
+func (d Diagnostic) Error() string {
+	msg := d.Msg
+	if d.IsWarning() {
+		msg = "warning: " + msg
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.Filename, d.Pos.Line, d.Pos.Column, msg)
+}
+
+// Diagnostics collects all of the problems found in one pass over a file,
+// so they can be reported together rather than one compile at a time.
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	var lines []string
+	for _, diag := range d {
+		lines = append(lines, diag.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sortDiagnostics puts diagnostics in source order.
+func sortDiagnostics(d []Diagnostic) {
+	sort.SliceStable(d, func(i, j int) bool {
+		if d[i].Filename != d[j].Filename {
+			return d[i].Filename < d[j].Filename
+		}
+		return d[i].Pos.Offset < d[j].Pos.Offset
+	})
+}
+
+// splitWarnings separates warnings from errors, keeping the order of each.
+func splitWarnings(d []Diagnostic) (errs []Diagnostic, warns []Diagnostic) {
+	for _, diag := range d {
+		if diag.IsWarning() {
+			warns = append(warns, diag)
+		} else {
+			errs = append(errs, diag)
+		}
+	}
+	return errs, warns
+}
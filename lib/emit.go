@@ -1,8 +1,13 @@
 package lib
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"go/format"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -17,6 +22,11 @@ type Inventory struct {
 	Typedef bool
 	Unique  bool
 	Import  bool
+
+	// Map is set if any type uses a Map, and BlobKeyMap if any of those
+	// maps is keyed by a Blob, since exporting them needs to sort the keys.
+	Map        bool
+	BlobKeyMap bool
 }
 
 func (i *Inventory) imports() []string {
@@ -31,6 +41,12 @@ func (i *Inventory) imports() []string {
 		ret = append(ret, "context")
 		ret = append(ret, "time")
 	}
+	if i.Map {
+		ret = append(ret, "sort")
+	}
+	if i.BlobKeyMap {
+		ret = append(ret, "bytes")
+	}
 
 	if i.Rpc || i.Unique || i.Struct || i.Variant || i.Typedef {
 		ret = append(ret, "github.com/foks-proj/go-snowpack-rpc/rpc")
@@ -40,9 +56,11 @@ func (i *Inventory) imports() []string {
 
 type Emitter interface {
 	EmitEnum(e Enum)
+	EmitConst(c Const)
 	EmitTypedef(t Typedef)
 	EmitVoid(v Void)
 	EmitList(l List)
+	EmitMap(m Map)
 	EmitOption(o Option)
 	EmitFuture(f Future)
 	EmitBlob(b Blob)
@@ -50,29 +68,38 @@ type Emitter interface {
 	EmitUint(u Uint)
 	EmitInt(i Int)
 	EmitBool(b Bool)
+	EmitSizedInt(s SizedInt)
+	EmitFloat(f Float)
 	EmitProtocol(p Protocol)
 	EmitListInternal(l List)
+	EmitMapInternal(m Map)
 	EmitOptionInternal(o Option)
 	EmitStruct(s Struct)
 	EmitVariant(v Variant)
 	EmitImport(i Import)
 	EmitDerivedType(d DerivedType)
 	EmitExportList(l List, param string)
+	EmitExportMap(m Map, param string)
 	EmitExportBlob(b Blob, param string)
 	EmitExportText(t Text, param string)
 	EmitExportUint(u Uint, param string)
 	EmitExportInt(i Int, param string)
 	EmitExportBool(b Bool, param string)
+	EmitExportSizedInt(s SizedInt, param string)
+	EmitExportFloat(f Float, param string)
 	EmitExportFuture(f Future, param string)
 	EmitExportOption(o Option, param string)
 	EmitDerivedTypeInternal(d DerivedType)
 	EmitExportDerivedType(d DerivedType, param string)
 	EmitImportList(l List, param string)
+	EmitImportMap(m Map, param string)
 	EmitImportBlob(b Blob, param string)
 	EmitImportText(t Text, param string)
 	EmitImportUint(u Uint, param string)
 	EmitImportInt(i Int, param string)
 	EmitImportBool(b Bool, param string)
+	EmitImportSizedInt(s SizedInt, param string)
+	EmitImportFloat(f Float, param string)
 	EmitImportFuture(f Future, param string)
 	EmitImportOption(o Option, param string)
 	EmitImportDerivedType(d DerivedType, param string)
@@ -107,6 +134,10 @@ type BaseEmitter struct {
 	nTabs     int
 	isNewline bool
 	imports   map[string]*ImportFlavors
+
+	// err is the first error we hit. Once it's set, output is dropped, and
+	// the error is returned when the current statement is done.
+	err error
 }
 
 func (b *BaseEmitter) storeImport(i Import) {
@@ -131,13 +162,24 @@ func (g *BaseEmitter) addUnique(s string) {
 	g.uniques = append(g.uniques, s)
 }
 
+// fail records err, unless we've already hit an error.
+func (g *BaseEmitter) fail(err error) {
+	if g.err == nil {
+		g.err = err
+	}
+}
+
 func (g *BaseEmitter) outputString(s string) {
+	if g.err != nil {
+		return
+	}
 	n, err := g.dst.Write([]byte(s))
 	if err != nil {
-		panic(err)
+		g.fail(err)
+		return
 	}
 	if n != len(s) {
-		panic(fmt.Sprintf("short io.Writer write: wrote %d bytes, expected %d", n, len(s)))
+		g.fail(fmt.Errorf("short io.Writer write: wrote %d bytes, expected %d", n, len(s)))
 	}
 }
 
@@ -175,18 +217,132 @@ func (g *BaseEmitter) tab()                { g.nTabs++ }
 func (g *BaseEmitter) untab() {
 	g.nTabs--
 	if g.nTabs < 0 {
-		panic("untab() called too many times")
+		g.nTabs = 0
+		g.fail(errors.New("untab() called too many times"))
 	}
 }
 
+// emitStatements emits the statements of r one at a time, and stops at the
+// first error, which it tags with the statement that caused it.
+func (g *BaseEmitter) emitStatements(r *Root, e Emitter) error {
+	for _, s := range r.Stmts {
+		err := g.emitStatement(s, e)
+		if err != nil {
+			return fmt.Errorf("%s:%s: cannot emit %s: %w",
+				r.Filename, s.GetSpan().Start, statementDescription(s), err)
+		}
+	}
+	return nil
+}
+
+func (g *BaseEmitter) emitStatement(s Statement, e Emitter) (err error) {
+	// Emitters are big and a bug in one shouldn't take down a long-running
+	// process that's using this package, so turn panics into errors too.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	s.emit(e)
+	return g.err
+}
+
+func statementDescription(s Statement) string {
+	switch s := s.(type) {
+	case Import:
+		return fmt.Sprintf("import %q", s.Path)
+	case Typedef:
+		return "typedef " + s.Ident.Name
+	case Struct:
+		return "struct " + s.Ident.Name
+	case Variant:
+		return "variant " + s.Ident.Name
+	case Enum:
+		return "enum " + s.Ident.Name
+	case Protocol:
+		return "protocol " + s.Ident.Name
+	case Const:
+		return "const " + s.Ident.Name
+	case GoPackage:
+		return "go:package " + s.Name.Name
+	}
+	return "statement"
+}
+
 type GoEmitter struct {
 	*BaseEmitter
+
+	// Code is generated into buf, and then run through go/format on its
+	// way to out.
+	out io.Writer
+	buf *bytes.Buffer
+
+	// Names that have a go:import, which beats any generic import of the
+	// same name.
+	goAliases map[string]bool
 }
 
 func NewGoEmitter(m *Metadata, dst io.Writer) *GoEmitter {
+	buf := &bytes.Buffer{}
 	return &GoEmitter{
-		BaseEmitter: NewBaseEmitter(m, dst),
+		BaseEmitter: NewBaseEmitter(m, buf),
+		out:         dst,
+		buf:         buf,
+	}
+}
+
+// goImport is one line of the import block, with an optional alias.
+type goImport struct {
+	alias string
+	path  string
+}
+
+func (i goImport) String() string {
+	if i.alias == "" {
+		return fmt.Sprintf("%q", i.path)
 	}
+	return fmt.Sprintf("%s %q", i.alias, i.path)
+}
+
+// isStdlib guesses whether a Go import path is in the standard library the
+// same way goimports does: by whether its first element has a dot in it.
+func (i goImport) isStdlib() bool {
+	first, _, _ := strings.Cut(i.path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// imports returns the import block for r, as the standard library imports
+// and then everything else, each sorted by path.
+func (g *GoEmitter) imports(r *Root) ([]goImport, []goImport) {
+	inv := &Inventory{}
+	r.DoInventory(inv)
+	var all []goImport
+	for _, p := range inv.imports() {
+		all = append(all, goImport{path: p})
+	}
+	for _, s := range r.Stmts {
+		i, ok := s.(Import)
+		switch {
+		case !ok:
+		case i.Lang == LangGo:
+			all = append(all, goImport{alias: i.Name, path: i.Path})
+		case i.Lang == LangGeneric && i.Pkg != nil && !g.goAliases[i.Name]:
+			// Checker made sure that GoPath is set in this case.
+			all = append(all, goImport{alias: i.Name, path: i.Pkg.GoPath})
+		}
+	}
+	var std, other []goImport
+	for _, i := range all {
+		if i.isStdlib() {
+			std = append(std, i)
+		} else {
+			other = append(other, i)
+		}
+	}
+	for _, l := range [][]goImport{std, other} {
+		sort.SliceStable(l, func(a, b int) bool { return l[a].path < l[b].path })
+	}
+	return std, other
 }
 
 func (g *GoEmitter) emitPreamble(r *Root) {
@@ -196,18 +352,25 @@ func (g *GoEmitter) emitPreamble(r *Root) {
 			name, version, url,
 		),
 	)
-	inv := &Inventory{}
-	r.DoInventory(inv)
-
-	g.outputLine(`//  Input file:` + g.md.infile.Name())
+	g.outputLine(`//  Input file:` + g.md.inputName())
 	g.emptyLine()
 	g.outputLine(`package ` + g.md.pkg)
 	g.emptyLine()
+
+	std, other := g.imports(r)
+	if len(std)+len(other) == 0 {
+		return
+	}
 	g.outputLine(`import (`)
 	g.tab()
-	imps := inv.imports()
-	for _, imp := range imps {
-		g.outputLine("\"" + imp + "\"")
+	for _, imp := range std {
+		g.outputLine(imp.String())
+	}
+	if len(std) > 0 && len(other) > 0 {
+		g.emptyLine()
+	}
+	for _, imp := range other {
+		g.outputLine(imp.String())
 	}
 	g.untab()
 	g.outputLine(`)`)
@@ -228,10 +391,32 @@ func (g *GoEmitter) emitPostamble(r *Root) {
 	g.outputLine("}")
 }
 
-func (g *GoEmitter) Emit(r *Root) {
+func (g *GoEmitter) Emit(r *Root) error {
+	g.goAliases = make(map[string]bool)
+	for _, s := range r.Stmts {
+		if i, ok := s.(Import); ok && i.Lang == LangGo {
+			g.goAliases[i.Name] = true
+		}
+	}
 	g.emitPreamble(r)
-	r.emit(g)
+	if g.err != nil {
+		return g.err
+	}
+	err := g.emitStatements(r, g)
+	if err != nil {
+		return err
+	}
 	g.emitPostamble(r)
+	if g.err != nil {
+		return g.err
+	}
+
+	dat, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("%s: generated Go code doesn't parse: %w", r.Filename, err)
+	}
+	_, err = g.out.Write(dat)
+	return err
 }
 
 // emitDoc will work for any target language that has //-style comments
@@ -284,7 +469,7 @@ func (g *GoEmitter) emitEnumMap(e Enum) {
 	g.outputLine("var " + exsym + "Map = map[string]" + exsym + "{")
 	g.tab()
 	for _, v := range e.Values {
-		g.foutputLine("\"%s\" : %d,", v.Ident.Name, v.Num)
+		g.foutputLine("\"%s\": %d,", v.Ident.Name, v.Num)
 	}
 	g.untab()
 	g.outputLine("}")
@@ -295,7 +480,7 @@ func (g *GoEmitter) emitEnumRevMap(e Enum) {
 	g.outputLine("var " + exsym + "RevMap = map[" + exsym + "]string{")
 	g.tab()
 	for _, v := range e.Values {
-		g.foutputLine("%d : \"%s\",", v.Num, v.Ident.Name)
+		g.foutputLine("%d: \"%s\",", v.Num, v.Ident.Name)
 	}
 	g.untab()
 	g.outputLine("}")
@@ -377,6 +562,43 @@ func (g *GoEmitter) EmitEnum(e Enum) {
 	g.emitEnumExport(e)
 }
 
+// EmitConst emits a Go const, or a var for Blob constants, since Go doesn't
+// have array constants.
+func (g *GoEmitter) EmitConst(c Const) {
+	g.emitStatementPremable(c.BaseStatement)
+	exsym := g.exportSymbol(c.Ident.Name)
+	if _, isBlob := Underlying(c.Type).(Blob); isBlob {
+		b, err := c.Value.Bytes()
+		if err != nil {
+			g.fail(err)
+			return
+		}
+		g.foutputFrag("var %s = ", exsym)
+		c.Type.Emit(g)
+		g.foutputLine("{%s}", byteList(b))
+		g.emptyLine()
+		return
+	}
+	g.foutputFrag("const %s ", exsym)
+	c.Type.Emit(g)
+	if c.Value.Kind == LiteralText {
+		g.foutputLine(" = %s", strconv.Quote(c.Value.Raw))
+	} else {
+		g.foutputLine(" = %s", c.Value.Raw)
+	}
+	g.emptyLine()
+}
+
+// byteList formats b as a comma-separated list of hex bytes, which works in
+// both Go and TypeScript.
+func byteList(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("0x%02x", c)
+	}
+	return strings.Join(parts, ", ")
+}
+
 func (g *GoEmitter) emitTypedefInternal(t Typedef) {
 	g.foutputFrag("type %s ", g.internalStructName(t.Ident.Name))
 	t.Type.EmitInternal(g)
@@ -495,6 +717,18 @@ func (g *GoEmitter) EmitUint(u Uint)     { g.outputFrag("uint64") }
 func (g *GoEmitter) EmitInt(i Int)       { g.outputFrag("int64") }
 func (g *GoEmitter) EmitBool(b Bool)     { g.outputFrag("bool") }
 
+// Sized types are decoded straight into the Go type of the same size, and
+// the msgpack codec rejects values that don't fit.
+func (g *GoEmitter) EmitSizedInt(s SizedInt) {
+	if s.Signed {
+		g.foutputFrag("int%d", s.Bits)
+	} else {
+		g.foutputFrag("uint%d", s.Bits)
+	}
+}
+
+func (g *GoEmitter) EmitFloat(f Float) { g.foutputFrag("float%d", f.Bits) }
+
 func (g *GoEmitter) EmitList(l List) {
 	g.outputFrag("[]")
 	g.emitType(l.Type)
@@ -534,9 +768,9 @@ func (g *GoEmitter) outputParamsMaybe(p string) {
 }
 
 func (g *GoEmitter) EmitExportList(l List, param string) {
-	g.outputFrag("(func (x ")
+	g.outputFrag("(func(x ")
 	l.Emit(g)
-	g.outputFrag(") * ")
+	g.outputFrag(") *")
 	l.EmitInternal(g)
 	g.outputLine(" {")
 	g.tab()
@@ -553,7 +787,7 @@ func (g *GoEmitter) EmitExportList(l List, param string) {
 	if l.Type.IsPrimitiveType() {
 		g.outputLine("copy(ret, x)")
 	} else {
-		g.outputLine("for k,v := range x {")
+		g.outputLine("for k, v := range x {")
 		g.tab()
 		g.outputFrag("ret[k] = ")
 		l.Type.EmitExport(g, "v")
@@ -568,7 +802,7 @@ func (g *GoEmitter) EmitExportList(l List, param string) {
 }
 
 func (g *GoEmitter) emitImportSignature(t Type) {
-	g.outputFrag("(func (x *")
+	g.outputFrag("(func(x *")
 	t.EmitInternal(g)
 	g.outputFrag(") (ret ")
 	t.Emit(g)
@@ -595,7 +829,7 @@ func (g *GoEmitter) EmitImportList(l List, param string) {
 	g.outputFrag("ret = make(")
 	l.Emit(g)
 	g.outputLine(", len(*x))")
-	g.outputLine("for k,v := range *x {")
+	g.outputLine("for k, v := range *x {")
 	g.tab()
 	if !l.Type.IsPrimitiveType() {
 		g.outputLine("if v == nil {")
@@ -619,6 +853,119 @@ func (g *GoEmitter) EmitImportList(l List, param string) {
 	g.outputParamsMaybe(param)
 }
 
+func (g *GoEmitter) EmitMap(m Map) {
+	g.outputFrag("map[")
+	g.emitType(m.Key)
+	g.outputFrag("]")
+	g.emitType(m.Value)
+}
+
+// EmitMapInternal emits a map's wire type, which is a list of key/value
+// pairs, each encoded as a 2-tuple.
+func (g *GoEmitter) EmitMapInternal(m Map) {
+	g.outputLine("[]struct {")
+	g.tab()
+	g.outputLine("_struct struct{} `codec:\",toarray\"` //lint:ignore U1000 msgpack internal field")
+	g.outputFrag("K ")
+	g.emitTypeInternal(m.Key.MakeOptional())
+	g.emptyLine()
+	g.outputFrag("V ")
+	g.emitTypeInternal(m.Value.MakeOptional())
+	g.emptyLine()
+	g.untab()
+	g.outputFrag("}")
+}
+
+// mapKeyLess returns a Go expression that orders keys[i] before keys[j].
+func mapKeyLess(m Map) string {
+	switch Underlying(m.Key).(type) {
+	case Bool:
+		return "!keys[i] && keys[j]"
+	case Blob:
+		return "bytes.Compare(keys[i][:], keys[j][:]) < 0"
+	}
+	return "keys[i] < keys[j]"
+}
+
+// EmitExportMap emits the pairs in key order, so that encoding the same map
+// always gives the same bytes.
+func (g *GoEmitter) EmitExportMap(m Map, param string) {
+	g.outputFrag("(func(x ")
+	m.Emit(g)
+	g.outputFrag(") *")
+	m.EmitInternal(g)
+	g.outputLine(" {")
+	g.tab()
+	g.outputLine("if len(x) == 0 {")
+	g.tab()
+	g.outputLine("return nil")
+	g.untab()
+	g.outputLine("}")
+	g.outputFrag("keys := make([]")
+	m.Key.Emit(g)
+	g.outputLine(", 0, len(x))")
+	g.outputLine("for k := range x {")
+	g.tab()
+	g.outputLine("keys = append(keys, k)")
+	g.untab()
+	g.outputLine("}")
+	g.outputLine("sort.Slice(keys, func(i, j int) bool {")
+	g.tab()
+	g.foutputLine("return %s", mapKeyLess(m))
+	g.untab()
+	g.outputLine("})")
+	g.outputFrag("ret := make(")
+	m.EmitInternal(g)
+	g.outputLine(", len(keys))")
+	g.outputLine("for i := range keys {")
+	g.tab()
+	g.outputLine("k := keys[i]")
+	g.outputLine("v := x[k]")
+	g.outputFrag("ret[i].K = ")
+	m.Key.EmitExport(g, "k")
+	g.emptyLine()
+	g.outputFrag("ret[i].V = ")
+	m.Value.EmitExport(g, "v")
+	g.emptyLine()
+	g.untab()
+	g.outputLine("}")
+	g.outputLine("return &ret")
+	g.untab()
+	g.outputFrag("})")
+	g.outputParamsMaybe(param)
+}
+
+func (g *GoEmitter) EmitImportMap(m Map, param string) {
+	g.emitImportSignature(m)
+	g.outputLine("if x == nil || len(*x) == 0 {")
+	g.tab()
+	g.outputLine("return nil")
+	g.untab()
+	g.outputLine("}")
+	g.outputFrag("ret = make(")
+	m.Emit(g)
+	g.outputLine(", len(*x))")
+	g.outputLine("for _, p := range *x {")
+	g.tab()
+	g.outputLine("if p.K == nil {")
+	g.tab()
+	g.outputLine("continue")
+	g.untab()
+	g.outputLine("}")
+	g.outputFrag("k := ")
+	m.Key.EmitImport(g, "p.K")
+	g.emptyLine()
+	g.outputFrag("ret[k] = ")
+	m.Value.EmitImport(g, "p.V")
+	g.emptyLine()
+	g.untab()
+	g.outputLine("}")
+	g.outputLine("return ret")
+	g.untab()
+	g.outputFrag("})")
+	g.outputParamsMaybe(param)
+}
+
 func (g *GoEmitter) emitImportPrimitiveType(t Type, param string) {
 	g.emitImportPreamble(t)
 	g.outputLine("return *x")
@@ -628,7 +975,7 @@ func (g *GoEmitter) emitImportPrimitiveType(t Type, param string) {
 }
 
 func (g *GoEmitter) EmitImportOption(o Option, param string) {
-	g.outputFrag("(func (x *")
+	g.outputFrag("(func(x *")
 	o.Type.EmitInternal(g)
 	g.outputFrag(") *")
 	o.Type.Emit(g)
@@ -661,6 +1008,10 @@ func (g *GoEmitter) EmitImportText(t Text, param string) { g.emitImportPrimitive
 func (g *GoEmitter) EmitImportUint(u Uint, param string) { g.emitImportPrimitiveType(u, param) }
 func (g *GoEmitter) EmitImportInt(i Int, param string)   { g.emitImportPrimitiveType(i, param) }
 func (g *GoEmitter) EmitImportBool(b Bool, param string) { g.emitImportPrimitiveType(b, param) }
+func (g *GoEmitter) EmitImportSizedInt(s SizedInt, param string) {
+	g.emitImportPrimitiveType(s, param)
+}
+func (g *GoEmitter) EmitImportFloat(f Float, param string) { g.emitImportPrimitiveType(f, param) }
 func (g *GoEmitter) EmitImportFuture(f Future, param string) {
 	g.emitImportPrimitiveType(Blob{}, param)
 }
@@ -671,9 +1022,9 @@ func (g *GoEmitter) emitExportPrimitiveType(t Type, param string) {
 		g.foutputFrag("&%s", param)
 		return
 	}
-	g.outputFrag("(func (x ")
+	g.outputFrag("(func(x ")
 	t.EmitInternal(g)
-	g.outputFrag(") * ")
+	g.outputFrag(") *")
 	t.Emit(g)
 	g.outputLine(" {")
 	g.tab()
@@ -687,9 +1038,9 @@ func (g *GoEmitter) EmitExportOption(o Option, param string) {
 		g.outputFrag(param)
 		return
 	}
-	g.outputFrag("(func (x *")
+	g.outputFrag("(func(x *")
 	o.Type.Emit(g)
-	g.outputFrag(") * ")
+	g.outputFrag(") *")
 	o.Type.EmitInternal(g)
 	g.outputLine(" {")
 	g.tab()
@@ -711,9 +1062,9 @@ func (g *GoEmitter) EmitExportDerivedType(d DerivedType, param string) {
 		g.foutputFrag("%s.Export()", param)
 		return
 	}
-	g.outputFrag("(func (x ")
+	g.outputFrag("(func(x ")
 	d.Emit(g)
-	g.outputFrag(") * ")
+	g.outputFrag(") *")
 	d.EmitInternal(g)
 	g.outputLine(" {")
 	g.tab()
@@ -727,6 +1078,10 @@ func (g *GoEmitter) EmitExportText(t Text, param string) { g.emitExportPrimitive
 func (g *GoEmitter) EmitExportUint(u Uint, param string) { g.emitExportPrimitiveType(u, param) }
 func (g *GoEmitter) EmitExportInt(i Int, param string)   { g.emitExportPrimitiveType(i, param) }
 func (g *GoEmitter) EmitExportBool(b Bool, param string) { g.emitExportPrimitiveType(b, param) }
+func (g *GoEmitter) EmitExportSizedInt(s SizedInt, param string) {
+	g.emitExportPrimitiveType(s, param)
+}
+func (g *GoEmitter) EmitExportFloat(f Float, param string) { g.emitExportPrimitiveType(f, param) }
 func (g *GoEmitter) EmitExportFuture(f Future, param string) {
 	g.emitExportPrimitiveType(Blob{}, param)
 }
@@ -788,7 +1143,7 @@ func (g *GoEmitter) EmitFutureLink(parent Type, child string) {
 	g.foutputFrag("func (%s *", tv)
 	parent.Emit(g)
 	b := "__b"
-	g.foutputLine(")  ChildBlob(%s []byte) %s {", b, nm)
+	g.foutputLine(") ChildBlob(%s []byte) %s {", b, nm)
 	g.tab()
 	g.foutputLine("return %s(%s)", nm, b)
 	g.untab()
@@ -833,9 +1188,12 @@ func (g *GoEmitter) emitStructInternal(s Struct) {
 	g.emitMsgpackStructOpts()
 	i := 0
 	for _, f := range s.Fields {
-		for i < f.Pos {
-			g.foutputLine("Deprecated%d *struct{}", i)
-			i++
+		// Each gap needs a placeholder, since the positions of later
+		// fields on the wire depend on it. The checker only warns about
+		// gaps that aren't reserved, so those get one too, as they always
+		// have.
+		for ; i < f.Pos; i++ {
+			g.foutputLine("Reserved%d *struct{}", i)
 		}
 		g.emitStructInternalField(f)
 		i++
@@ -848,7 +1206,7 @@ func (g *GoEmitter) emitStructImport(s Struct) {
 	tv, isn, exsym := g.baseTypeNames(s.BaseTypedef)
 	g.foutputLine("func (%s %s) Import() %s {", tv, isn, exsym)
 	g.tab()
-	g.foutputLine("return %s {", exsym)
+	g.foutputLine("return %s{", exsym)
 	g.tab()
 	for _, f := range s.Fields {
 		fn := g.exportSymbol(f.Ident.Name)
@@ -866,7 +1224,7 @@ func (g *GoEmitter) emitStructExport(s Struct) {
 	tv, isn, exsym := g.baseTypeNames(s.BaseTypedef)
 	g.foutputLine("func (%s %s) Export() *%s {", tv, exsym, isn)
 	g.tab()
-	g.foutputLine("return &%s {", isn)
+	g.foutputLine("return &%s{", isn)
 	g.tab()
 	for _, f := range s.Fields {
 		fn := g.exportSymbol(f.Ident.Name)
@@ -997,7 +1355,8 @@ func (g *GoEmitter) emitVariantSwitchAccessorCase(v Variant, c Case, tv string)
 	} else {
 		cda := g.caseDataAccess(v, c)
 		if cda == "" {
-			panic("case data access is nil, should never be")
+			g.fail(errors.New("case data access is nil, should never be"))
+			return
 		}
 		g.foutputLine("if %s == nil {", cda)
 		g.tab()
@@ -1184,7 +1543,7 @@ func (g *GoEmitter) emitVariantImportCase(v Variant, c Case) {
 		return
 	}
 
-	g.outputFrag("(func (x *")
+	g.outputFrag("(func(x *")
 	c.Type.EmitInternal(g)
 	g.outputFrag(") *")
 	c.Type.Emit(g)
@@ -1234,7 +1593,7 @@ func (g *GoEmitter) emitVariantExportCase(v Variant, c Case) {
 		return
 	}
 
-	g.outputFrag("(func (x *")
+	g.outputFrag("(func(x *")
 	c.Type.Emit(g)
 	g.outputFrag(") *")
 	c.Type.EmitInternal(g)
@@ -1487,7 +1846,7 @@ func (g *GoEmitter) emitClientMethod(p Protocol, m Method) {
 	pn := g.exportSymbol(p.Ident.Name)
 	mn := g.exportSymbol(m.Ident.Name)
 
-	g.foutputFrag("func (c %sClient) %s (ctx context.Context", pn, mn)
+	g.foutputFrag("func (c %sClient) %s(ctx context.Context", pn, mn)
 	argStructName := m.makeArgName(g) // mn + "Arg"
 	if len(m.Params) > 0 {
 		g.outputFrag(", ")
@@ -1524,7 +1883,7 @@ func (g *GoEmitter) emitClientMethod(p Protocol, m Method) {
 		g.outputFrag(", *")
 		argType := g.internalStructName(m.makeArgName(g))
 		g.outputFrag(argType)
-		g.outputLine("] {")
+		g.outputLine("]{")
 		g.tab()
 		g.outputLine("Data: arg.Export(),")
 		g.untab()
@@ -1568,7 +1927,7 @@ func (g *GoEmitter) emitClientMethod(p Protocol, m Method) {
 	adapter := g.privateSymbol(p.Ident.Name) + "ErrorUnwrapperAdapter{" +
 		"h: c.ErrorUnwrapper}"
 
-	g.foutputLine("err = c.Cli.Call2(ctx, %s, warg, %s, 0 * time.Millisecond, %s)",
+	g.foutputLine("err = c.Cli.Call2(ctx, %s, warg, %s, 0*time.Millisecond, %s)",
 		method, res, adapter)
 
 	g.outputLine("if err != nil {")
@@ -1623,7 +1982,7 @@ func (g *GoEmitter) emitServerProtocolHandler(p Protocol, m Method) {
 
 	g.outputLine("ServeHandlerDescription: rpc.ServeHandlerDescription{")
 	g.tab()
-	g.outputLine("MakeArg : func() interface{} {")
+	g.outputLine("MakeArg: func() interface{} {")
 	g.tab()
 	if p.Modifiers.ArgHeader != nil {
 		g.outputFrag("var ret rpc.DataWrap[")
@@ -1670,7 +2029,7 @@ func (g *GoEmitter) emitServerProtocolHandler(p Protocol, m Method) {
 		if len(m.Params) == 0 {
 			typedArgs = "_"
 		}
-		g.foutputLine(("%s, ok := args.(*%s)"), typedArgs, argType)
+		g.foutputLine("%s, ok := args.(*%s)", typedArgs, argType)
 		g.outputLine("if !ok {")
 		g.tab()
 		g.foutputLine("err := rpc.NewTypeError((*%s)(nil), args)", argType)
@@ -1723,7 +2082,7 @@ func (g *GoEmitter) emitServerProtocolHandler(p Protocol, m Method) {
 		} else {
 			g.foutputLine("Data: tmp.Export(),")
 		}
-		g.outputLine("Header : i.MakeResHeader(),")
+		g.outputLine("Header: i.MakeResHeader(),")
 		g.untab()
 		g.outputLine("}")
 		if m.ResType.IsList() {
@@ -1860,24 +2219,22 @@ func (g *GoEmitter) MethodArgName(mthd string, argName string) string {
 	return g.exportSymbol(ret)
 }
 
+// EmitImport only records the import; the import block is written by
+// emitPreamble.
 func (g *GoEmitter) EmitImport(i Import) {
 	g.storeImport(i)
-	if i.Lang == LangGo {
-		g.foutputLine("import %s \"%s\"", i.Name, i.Path)
-	}
 }
 
 var _ Emitter = (*GoEmitter)(nil)
 
-func (r Root) emit(g Emitter) {
-	for _, s := range r.Stmts {
-		s.emit(g)
-	}
-}
-
 func (t Typedef) emit(g Emitter)  { g.EmitTypedef(t) }
 func (e Enum) emit(g Emitter)     { g.EmitEnum(e) }
+func (c Const) emit(g Emitter)    { g.EmitConst(c) }
 func (v Variant) emit(g Emitter)  { g.EmitVariant(v) }
 func (s Struct) emit(g Emitter)   { g.EmitStruct(s) }
 func (p Protocol) emit(g Emitter) { g.EmitProtocol(p) }
 func (i Import) emit(g Emitter)   { g.EmitImport(i) }
+
+// The package clause comes from the Metadata, which already accounts for any
+// go:package directive.
+func (p GoPackage) emit(g Emitter) {}
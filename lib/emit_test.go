@@ -0,0 +1,243 @@
+package lib
+
+import (
+	"bytes"
+	"go/parser"
+	gotoken "go/token"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// genGo checks checkHeader+src and generates Go from it, which has to parse.
+func genGo(t *testing.T, src string) string {
+	t.Helper()
+	r, err := Parse([]byte(checkHeader+src), "t.snowp")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return emitGo(t, r)
+}
+
+// emitGo checks r, which must be a standalone file, and generates Go from
+// it.
+func emitGo(t *testing.T, r *Root) string {
+	t.Helper()
+	if errs, _ := splitWarnings(Check(r)); len(errs) > 0 {
+		t.Fatalf("check: %v", Diagnostics(errs))
+	}
+	md := &Metadata{infile: newInfile("t.snowp"), lang: LangGo, pkg: "p", root: r}
+	var buf bytes.Buffer
+	if err := NewGoEmitter(md, &buf).Emit(r); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	out := buf.String()
+	if _, err := parser.ParseFile(gotoken.NewFileSet(), "t.go", out, 0); err != nil {
+		t.Fatalf("generated Go doesn't parse: %v\n%s", err, out)
+	}
+	return out
+}
+
+// importsOf returns the paths in the import block of generated Go.
+func importsOf(t *testing.T, src string) map[string]bool {
+	t.Helper()
+	f, err := parser.ParseFile(gotoken.NewFileSet(), "t.go", src, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make(map[string]bool)
+	for _, i := range f.Imports {
+		ret[strings.Trim(i.Path.Value, `"`)] = true
+	}
+	return ret
+}
+
+func TestEmitMapKeyOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		less      string
+		wantBytes bool
+	}{
+		{name: "text", key: "Text", less: "return keys[i] < keys[j]"},
+		{name: "int", key: "Int", less: "return keys[i] < keys[j]"},
+		{name: "sized", key: "Uint16", less: "return keys[i] < keys[j]"},
+		{name: "enum", key: "E", less: "return keys[i] < keys[j]"},
+		{name: "typedef", key: "Name", less: "return keys[i] < keys[j]"},
+		{name: "bool", key: "Bool", less: "return !keys[i] && keys[j]"},
+		{name: "blob", key: "Blob(4)", less: "return bytes.Compare(keys[i][:], keys[j][:]) < 0", wantBytes: true},
+		{name: "blob typedef", key: "Key", less: "return bytes.Compare(keys[i][:], keys[j][:]) < 0", wantBytes: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := genGo(t, `enum E {
+    A @0;
+}
+typedef Name = Text;
+typedef Key = Blob(4);
+struct S {
+    m @0 : Map(`+tt.key+`, Uint);
+}`)
+			// Keys have to be sorted before the pairs are filled in, so
+			// that the same map always encodes to the same bytes.
+			sortAt := strings.Index(out, "sort.Slice(keys, func(i, j int) bool {")
+			fillAt := strings.Index(out, "ret[i].K = ")
+			if sortAt < 0 || fillAt < 0 || sortAt > fillAt {
+				t.Fatalf("export doesn't sort the keys before filling in pairs:\n%s", out)
+			}
+			if !strings.Contains(out[sortAt:fillAt], tt.less) {
+				t.Errorf("keys not compared with %q:\n%s", tt.less, out[sortAt:fillAt])
+			}
+			imps := importsOf(t, out)
+			if !imps["sort"] {
+				t.Errorf("sort isn't imported")
+			}
+			if imps["bytes"] != tt.wantBytes {
+				t.Errorf("bytes imported = %t; want %t", imps["bytes"], tt.wantBytes)
+			}
+		})
+	}
+}
+
+func TestEmitNoMapNoSort(t *testing.T) {
+	out := genGo(t, `struct S {
+    a @0 : List(Text);
+}`)
+	if imps := importsOf(t, out); imps["sort"] || imps["bytes"] {
+		t.Errorf("sort or bytes imported without any maps:\n%s", out)
+	}
+}
+
+// TestEmitStructGaps makes sure that every position a struct skips gets a
+// placeholder in the internal struct, reserved or not, so that later fields
+// stay where they were on the wire.
+func TestEmitStructGaps(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "reserved",
+			src: `struct S {
+    a @0 : Uint;
+    reserved @1, @2;
+    d @3 : Text;
+}`,
+			want: "Reserved1 *struct{}\n\tReserved2 *struct{}\n\tD",
+		},
+		{
+			name: "not reserved",
+			src: `struct S {
+    reserved @0;
+    c @2 : Text;
+}`,
+			want: "Reserved0 *struct{}\n\tReserved1 *struct{}\n\tC",
+		},
+		{
+			name: "method parameters",
+			src: `protocol P errors Text @0xcccccccc {
+    m @0 (a @0 : Uint, d @3 : Text);
+}`,
+			want: "A         *uint64\n\tReserved1 *struct{}\n\tReserved2 *struct{}\n\tD",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := genGo(t, tt.src)
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("no %q in:\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+// sizedDecodeMain decodes each of its cases, encoded as msgpack, into the
+// generated S, and prints whether that worked.
+const sizedDecodeMain = `package main
+
+import (
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+	"sizedtest/p"
+)
+
+func main() {
+	var mh codec.MsgpackHandle
+	for _, c := range [][]any{
+		{127, 65535, 1.5, []any{-2147483648}},
+		{128, 0, 0, nil},
+		{-129, 0, 0, nil},
+		{0, 65536, 0, nil},
+		{0, -1, 0, nil},
+		{0, 0, 1e300, nil},
+		{0, 0, 0, []any{1 << 40}},
+	} {
+		var b []byte
+		if err := codec.NewEncoderBytes(&b, &mh).Encode(c); err != nil {
+			panic(err)
+		}
+		var s p.S
+		err := s.Decode(codec.NewDecoderBytes(b, &mh))
+		fmt.Println(err == nil)
+	}
+}
+`
+
+// TestEmitSizedDecode builds generated Go against a stand-in for the RPC
+// runtime, decoding with the msgpack codec, and makes sure that a value that
+// doesn't fit a sized field is rejected rather than cut down to size. It
+// needs the codec, so it's skipped if that can't be downloaded.
+func TestEmitSizedDecode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs a Go program")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go")
+	}
+	out := genGo(t, `struct S {
+    a @0 : Int8;
+    b @1 : Uint16;
+    c @2 : Float32;
+    d @3 : List(Int32);
+}`)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": `module sizedtest
+
+go 1.23
+
+require github.com/foks-proj/go-snowpack-rpc v0.0.0
+
+replace github.com/foks-proj/go-snowpack-rpc => ./rpc
+`,
+		"rpc/go.mod": "module github.com/foks-proj/go-snowpack-rpc\n\ngo 1.23\n",
+		"rpc/rpc/rpc.go": `package rpc
+
+type Encoder interface{ Encode(v any) error }
+type Decoder interface{ Decode(v any) error }
+`,
+		"p/p.go":  out,
+		"main.go": sizedDecodeMain,
+	})
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command("go", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+		b, err := cmd.CombinedOutput()
+		return string(b), err
+	}
+	if msg, err := run("get", "github.com/ugorji/go/codec@v1.3.2"); err != nil {
+		t.Skipf("can't get the msgpack codec: %v\n%s", err, msg)
+	}
+	got, err := run("run", ".")
+	if err != nil {
+		t.Fatalf("%v\n%s", err, got)
+	}
+	want := "true\nfalse\nfalse\nfalse\nfalse\nfalse\nfalse\n"
+	if got != want {
+		t.Errorf("decoded:\n%s\nwant:\n%s", got, want)
+	}
+}
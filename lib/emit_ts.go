@@ -0,0 +1,927 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TypeScriptEmitter outputs TypeScript types for a .snowp file, along with
+// functions that convert each type to and from the msgpack-ready values that
+// the Go side puts on the wire, and typed client stubs for protocols.
+//
+// Generated files have no runtime dependencies. The few helpers they need are
+// written into each file, and clients make calls through a SnowpackTransport
+// that the caller supplies.
+type TypeScriptEmitter struct {
+	*BaseEmitter
+
+	// The body is written to a buffer first, so that we know which helpers
+	// it used before we write the top of the file.
+	out     io.Writer
+	body    *bytes.Buffer
+	helpers map[string]bool
+	hasRpc  bool
+}
+
+func NewTypeScriptEmitter(m *Metadata, dst io.Writer) *TypeScriptEmitter {
+	body := &bytes.Buffer{}
+	return &TypeScriptEmitter{
+		BaseEmitter: NewBaseEmitter(m, body),
+		out:         dst,
+		body:        body,
+		helpers:     make(map[string]bool),
+	}
+}
+
+// tsHelpers are the functions that generated code calls to check and convert
+// values off the wire. As in Go, a missing value imports as the zero value.
+var tsHelpers = []struct {
+	name string
+	src  string
+}{
+	{"fail__", `function fail__(what: string, x: unknown): never {
+	throw new TypeError("snowpack: expected " + what + ", got " + typeof x);
+}`},
+	{"text__", `function text__(x: unknown): string {
+	if (x == null) return "";
+	if (typeof x !== "string") return fail__("string", x);
+	return x;
+}`},
+	{"bool__", `function bool__(x: unknown): boolean {
+	if (x == null) return false;
+	if (typeof x !== "boolean") return fail__("boolean", x);
+	return x;
+}`},
+	{"int__", `function int__(x: unknown): bigint {
+	if (x == null) return 0n;
+	if (typeof x === "bigint") return x;
+	if (typeof x === "number" && Number.isInteger(x)) return BigInt(x);
+	return fail__("integer", x);
+}`},
+	{"uint__", `function uint__(x: unknown): bigint {
+	const v = int__(x);
+	if (v < 0n) return fail__("unsigned integer", x);
+	return v;
+}`},
+	{"intN__", `function intN__(x: unknown, bits: number): number {
+	const v = int__(x);
+	const lim = 1n << BigInt(bits - 1);
+	if (v < -lim || v >= lim) return fail__("int" + bits, x);
+	return Number(v);
+}`},
+	{"uintN__", `function uintN__(x: unknown, bits: number): number {
+	const v = uint__(x);
+	if (v >= 1n << BigInt(bits)) return fail__("uint" + bits, x);
+	return Number(v);
+}`},
+	{"float__", `function float__(x: unknown, bits: number): number {
+	if (x == null) return 0;
+	if (typeof x !== "number") return fail__("number", x);
+	if (bits === 32 && Number.isFinite(x) && !Number.isFinite(Math.fround(x))) {
+		return fail__("float32", x);
+	}
+	return x;
+}`},
+	{"enum__", `function enum__(x: unknown): number {
+	if (x == null) return 0;
+	if (typeof x === "number" && Number.isInteger(x)) return x;
+	if (typeof x === "bigint") return Number(x);
+	return fail__("enum value", x);
+}`},
+	{"blob__", `function blob__(x: unknown, n: number): Uint8Array {
+	if (x == null) return new Uint8Array(n);
+	if (!(x instanceof Uint8Array)) return fail__("bytes", x);
+	if (n > 0 && x.length !== n) return fail__(n + " bytes", x);
+	return x;
+}`},
+	{"hex__", `function hex__(x: Uint8Array): string {
+	return Array.from(x, (b) => b.toString(16).padStart(2, "0")).join("");
+}`},
+	{"unhex__", `function unhex__(x: string): Uint8Array {
+	if (!/^([0-9a-f]{2})*$/.test(x)) return fail__("lowercase hex", x);
+	const ret = new Uint8Array(x.length / 2);
+	for (let i = 0; i < ret.length; i++) ret[i] = parseInt(x.slice(2 * i, 2 * i + 2), 16);
+	return ret;
+}`},
+	{"list__", `function list__<T>(x: unknown, f: (v: unknown) => T): T[] {
+	if (x == null) return [];
+	if (!Array.isArray(x)) return fail__("array", x);
+	return x.map(f);
+}`},
+	{"exportList__", `function exportList__<T>(x: T[], f: (v: T) => unknown): unknown[] | null {
+	return x.length === 0 ? null : x.map(f);
+}`},
+	{"tuple__", `function tuple__(x: unknown): unknown[] {
+	if (x == null) return [];
+	if (!Array.isArray(x)) return fail__("array", x);
+	return x;
+}`},
+	{"compare__", `function compare__(a: unknown, b: unknown): number {
+	if (typeof a === "string" && typeof b === "string") {
+		const enc = new TextEncoder();
+		return compare__(enc.encode(a), enc.encode(b));
+	}
+	if (a instanceof Uint8Array && b instanceof Uint8Array) {
+		const n = Math.min(a.length, b.length);
+		for (let i = 0; i < n; i++) {
+			if (a[i] !== b[i]) return a[i] - b[i];
+		}
+		return a.length - b.length;
+	}
+	if (typeof a === "boolean" && typeof b === "boolean") return Number(a) - Number(b);
+	if (a === b) return 0;
+	return (a as number | bigint) < (b as number | bigint) ? -1 : 1;
+}`},
+	{"exportMap__", `function exportMap__<K, V>(x: Map<K, V>, fk: (k: K) => unknown, fv: (v: V) => unknown): unknown[] | null {
+	if (x.size === 0) return null;
+	const keys = Array.from(x.keys()).sort(compare__);
+	return keys.map((k) => [fk(k), fv(x.get(k) as V)]);
+}`},
+	{"map__", `function map__<K, V>(x: unknown, fk: (k: unknown) => K, fv: (v: unknown) => V): Map<K, V> {
+	const ret = new Map<K, V>();
+	for (const p of list__(x, tuple__)) {
+		if (p[0] == null) continue;
+		ret.set(fk(p[0]), fv(p[1]));
+	}
+	return ret;
+}`},
+	{"cases__", `function cases__(x: unknown): Record<string, unknown> {
+	if (x == null) return {};
+	if (typeof x !== "object" || Array.isArray(x)) return fail__("map", x);
+	return x as Record<string, unknown>;
+}`},
+}
+
+// tsHelperDeps lists the helpers that other helpers call.
+var tsHelperDeps = map[string][]string{
+	"text__":      {"fail__"},
+	"bool__":      {"fail__"},
+	"int__":       {"fail__"},
+	"uint__":      {"int__", "fail__"},
+	"intN__":      {"int__", "fail__"},
+	"uintN__":     {"uint__", "int__", "fail__"},
+	"float__":     {"fail__"},
+	"enum__":      {"fail__"},
+	"blob__":      {"fail__"},
+	"unhex__":     {"fail__"},
+	"list__":      {"fail__"},
+	"tuple__":     {"fail__"},
+	"exportMap__": {"compare__"},
+	"map__":       {"list__", "tuple__", "fail__"},
+	"cases__":     {"fail__"},
+}
+
+const tsTransport = `// SnowpackTransport carries calls from the generated clients to a snowpack
+// RPC server. arg, header and the values in the result are msgpack-ready.
+export interface SnowpackTransport {
+	call(req: SnowpackCall): Promise<SnowpackResult>;
+}
+
+export interface SnowpackCall {
+	protocolID: bigint;
+	method: number;
+	name: string;
+	arg: unknown;
+	header?: unknown;
+}
+
+export interface SnowpackResult {
+	res?: unknown;
+	err?: unknown;
+	header?: unknown;
+}`
+
+// use marks a helper as used and returns its name.
+func (g *TypeScriptEmitter) use(h string) string {
+	g.helpers[h] = true
+	for _, d := range tsHelperDeps[h] {
+		g.helpers[d] = true
+	}
+	return h
+}
+
+func (g *TypeScriptEmitter) emitPreamble(r *Root) {
+	g.outputLine(
+		fmt.Sprintf("%s %s %s (%s)",
+			`// Auto-generated to TypeScript types and interfaces using`,
+			name, version, url,
+		),
+	)
+	g.outputLine(`//  Input file:` + g.md.inputName())
+	g.emptyLine()
+
+	var aliases []string
+	for nm, flav := range g.imports {
+		if _, ok := flav.m[LangTypeScript]; ok {
+			aliases = append(aliases, nm)
+		}
+	}
+	sort.Strings(aliases)
+	for _, nm := range aliases {
+		i := g.imports[nm].m[LangTypeScript]
+		g.foutputLine("import * as %s from \"%s\";", i.Name, i.Path)
+	}
+	if len(aliases) > 0 {
+		g.emptyLine()
+	}
+	if g.hasRpc {
+		g.outputLine(tsTransport)
+		g.emptyLine()
+	}
+	for _, h := range tsHelpers {
+		if g.helpers[h.name] {
+			g.outputLine(h.src)
+			g.emptyLine()
+		}
+	}
+}
+
+func (g *TypeScriptEmitter) Emit(r *Root) error {
+	err := g.emitStatements(r, g)
+	if err != nil {
+		return err
+	}
+	body := g.body.String()
+	g.dst = g.out
+	g.emitPreamble(r)
+	g.outputString(body)
+	return g.err
+}
+
+// emptyLine doesn't indent blank lines, unlike in Go, where gofmt cleans
+// them up.
+func (g *TypeScriptEmitter) emptyLine() {
+	if g.isNewline {
+		g.outputString("\n")
+		return
+	}
+	g.BaseEmitter.emptyLine()
+}
+
+func (g *TypeScriptEmitter) exportSymbol(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func (g *TypeScriptEmitter) derivedPrefix(d DerivedType) string {
+	if d.ImportedFrom.Name != "" {
+		return d.ImportedFrom.Name + "."
+	}
+	return ""
+}
+
+func (g *TypeScriptEmitter) exportFunc(nm string) string { return "export" + g.exportSymbol(nm) }
+func (g *TypeScriptEmitter) importFunc(nm string) string { return "import" + g.exportSymbol(nm) }
+
+// emitConverters writes the export and import functions for a named type,
+// given the body of each.
+func (g *TypeScriptEmitter) emitConverters(nm string, exp func(), imp func()) {
+	exsym := g.exportSymbol(nm)
+	g.foutputLine("export function %s(x: %s): unknown {", g.exportFunc(nm), exsym)
+	g.tab()
+	exp()
+	g.untab()
+	g.outputLine("}")
+	g.foutputLine("export function %s(x: unknown): %s {", g.importFunc(nm), exsym)
+	g.tab()
+	imp()
+	g.untab()
+	g.outputLine("}")
+}
+
+func (g *TypeScriptEmitter) emitID(b BaseTypedef) {
+	if !b.UniqueID.IsSet() {
+		return
+	}
+	g.foutputLine("export const %sTypeUniqueID = %sn;", g.exportSymbol(b.Ident.Name), b.UniqueID.Val)
+}
+
+func (g *TypeScriptEmitter) EmitEnum(e Enum) {
+	g.emitStatementPremable(e.BaseStatement)
+	exsym := g.exportSymbol(e.Ident.Name)
+	g.foutputLine("export const %s = {", exsym)
+	g.tab()
+	for _, v := range e.Values {
+		g.foutputLine("%s: %d,", v.Ident.Name, v.Num)
+	}
+	g.untab()
+	g.outputLine("} as const;")
+	g.foutputLine("export type %s = (typeof %s)[keyof typeof %s];", exsym, exsym, exsym)
+	g.emitConverters(e.Ident.Name,
+		func() { g.outputLine("return x;") },
+		func() { g.foutputLine("return %s(x) as %s;", g.use("enum__"), exsym) },
+	)
+	g.emptyLine()
+}
+
+func (g *TypeScriptEmitter) EmitConst(c Const) {
+	g.emitStatementPremable(c.BaseStatement)
+	g.foutputFrag("export const %s: ", g.exportSymbol(c.Ident.Name))
+	c.Type.Emit(g)
+	g.outputFrag(" = ")
+	u := Underlying(c.Type)
+	_, isBlob := u.(Blob)
+	_, isSized := u.(SizedInt)
+	_, isFloat := u.(Float)
+	switch {
+	case isBlob:
+		b, err := c.Value.Bytes()
+		if err != nil {
+			g.fail(err)
+			return
+		}
+		g.foutputFrag("new Uint8Array([%s])", byteList(b))
+	case c.Value.Kind == LiteralText:
+		g.outputFrag(strconv.Quote(c.Value.Raw))
+	case c.Value.Kind == LiteralBool, isSized, isFloat:
+		g.outputFrag(c.Value.Raw)
+	default:
+		g.foutputFrag("%sn", c.Value.Raw)
+	}
+	g.outputLine(";")
+	g.emptyLine()
+}
+
+func (g *TypeScriptEmitter) EmitTypedef(t Typedef) {
+	g.emitStatementPremable(t.BaseStatement)
+	g.foutputFrag("export type %s = ", g.exportSymbol(t.Ident.Name))
+	t.Type.Emit(g)
+	g.outputLine(";")
+	g.emitConverters(t.Ident.Name,
+		func() {
+			g.outputFrag("return ")
+			t.Type.EmitExport(g, "x")
+			g.outputLine(";")
+		},
+		func() {
+			g.outputFrag("return ")
+			t.Type.EmitImport(g, "x")
+			g.outputLine(";")
+		},
+	)
+	g.emitID(t.BaseTypedef)
+	g.emptyLine()
+}
+
+func (g *TypeScriptEmitter) EmitVoid(v Void)     { g.outputFrag("void") }
+func (g *TypeScriptEmitter) EmitBlob(b Blob)     { g.outputFrag("Uint8Array") }
+func (g *TypeScriptEmitter) EmitFuture(f Future) { g.outputFrag("Uint8Array") }
+func (g *TypeScriptEmitter) EmitText(t Text)     { g.outputFrag("string") }
+func (g *TypeScriptEmitter) EmitUint(u Uint)     { g.outputFrag("bigint") }
+func (g *TypeScriptEmitter) EmitInt(i Int)       { g.outputFrag("bigint") }
+func (g *TypeScriptEmitter) EmitBool(b Bool)     { g.outputFrag("boolean") }
+
+// Sized integers all fit in a JavaScript number, unlike Int and Uint.
+func (g *TypeScriptEmitter) EmitSizedInt(s SizedInt) { g.outputFrag("number") }
+func (g *TypeScriptEmitter) EmitFloat(f Float)       { g.outputFrag("number") }
+
+func (g *TypeScriptEmitter) EmitList(l List) {
+	g.outputFrag("Array<")
+	l.Type.Emit(g)
+	g.outputFrag(">")
+}
+
+// EmitMap emits a JavaScript Map. Map compares a Uint8Array by reference, so
+// Blob keys are lowercase hex strings instead, which compare by value, and
+// sort the same way as the bytes do.
+func (g *TypeScriptEmitter) EmitMap(m Map) {
+	g.outputFrag("Map<")
+	if isBlobKey(m) {
+		g.outputFrag("string")
+	} else {
+		m.Key.Emit(g)
+	}
+	g.outputFrag(", ")
+	m.Value.Emit(g)
+	g.outputFrag(">")
+}
+
+// isBlobKey says whether m is keyed by a Blob, or a typedef of one.
+func isBlobKey(m Map) bool {
+	_, ok := Underlying(m.Key).(Blob)
+	return ok
+}
+
+func (g *TypeScriptEmitter) EmitOption(o Option) {
+	o.Type.Emit(g)
+	g.outputFrag(" | null")
+}
+
+func (g *TypeScriptEmitter) EmitDerivedType(d DerivedType) {
+	g.outputFrag(g.derivedPrefix(d) + g.exportSymbol(d.Name.Name))
+}
+
+// Wire values aren't given types of their own in TypeScript.
+func (g *TypeScriptEmitter) EmitListInternal(l List)               { g.outputFrag("unknown") }
+func (g *TypeScriptEmitter) EmitMapInternal(m Map)                 { g.outputFrag("unknown") }
+func (g *TypeScriptEmitter) EmitOptionInternal(o Option)           { g.outputFrag("unknown") }
+func (g *TypeScriptEmitter) EmitDerivedTypeInternal(d DerivedType) { g.outputFrag("unknown") }
+
+func (g *TypeScriptEmitter) EmitExportList(l List, param string) {
+	g.foutputFrag("%s(%s, (v) => ", g.use("exportList__"), param)
+	l.Type.EmitExport(g, "v")
+	g.outputFrag(")")
+}
+
+// EmitExportMap sorts the pairs by key, the same way the Go side does, so
+// that both encode a map to the same bytes.
+func (g *TypeScriptEmitter) EmitExportMap(m Map, param string) {
+	g.foutputFrag("%s(%s, (k) => ", g.use("exportMap__"), param)
+	if isBlobKey(m) {
+		m.Key.EmitExport(g, g.use("unhex__")+"(k)")
+	} else {
+		m.Key.EmitExport(g, "k")
+	}
+	g.outputFrag(", (v) => ")
+	m.Value.EmitExport(g, "v")
+	g.outputFrag(")")
+}
+
+func (g *TypeScriptEmitter) EmitExportOption(o Option, param string) {
+	g.foutputFrag("(%s == null ? null : ", param)
+	o.Type.EmitExport(g, param)
+	g.outputFrag(")")
+}
+
+func (g *TypeScriptEmitter) EmitExportDerivedType(d DerivedType, param string) {
+	g.foutputFrag("%s%s(%s)", g.derivedPrefix(d), g.exportFunc(d.Name.Name), param)
+}
+
+func (g *TypeScriptEmitter) EmitExportBlob(b Blob, param string)         { g.outputFrag(param) }
+func (g *TypeScriptEmitter) EmitExportText(t Text, param string)         { g.outputFrag(param) }
+func (g *TypeScriptEmitter) EmitExportUint(u Uint, param string)         { g.outputFrag(param) }
+func (g *TypeScriptEmitter) EmitExportInt(i Int, param string)           { g.outputFrag(param) }
+func (g *TypeScriptEmitter) EmitExportBool(b Bool, param string)         { g.outputFrag(param) }
+func (g *TypeScriptEmitter) EmitExportSizedInt(s SizedInt, param string) { g.outputFrag(param) }
+func (g *TypeScriptEmitter) EmitExportFloat(f Float, param string)       { g.outputFrag(param) }
+func (g *TypeScriptEmitter) EmitExportFuture(f Future, param string)     { g.outputFrag(param) }
+
+func (g *TypeScriptEmitter) EmitImportList(l List, param string) {
+	g.foutputFrag("%s(%s, (v) => ", g.use("list__"), param)
+	l.Type.EmitImport(g, "v")
+	g.outputFrag(")")
+}
+
+func (g *TypeScriptEmitter) EmitImportMap(m Map, param string) {
+	g.foutputFrag("%s(%s, (k) => ", g.use("map__"), param)
+	if isBlobKey(m) {
+		g.foutputFrag("%s(", g.use("hex__"))
+		m.Key.EmitImport(g, "k")
+		g.outputFrag(")")
+	} else {
+		m.Key.EmitImport(g, "k")
+	}
+	g.outputFrag(", (v) => ")
+	m.Value.EmitImport(g, "v")
+	g.outputFrag(")")
+}
+
+func (g *TypeScriptEmitter) EmitImportOption(o Option, param string) {
+	g.foutputFrag("(%s == null ? null : ", param)
+	o.Type.EmitImport(g, param)
+	g.outputFrag(")")
+}
+
+func (g *TypeScriptEmitter) EmitImportDerivedType(d DerivedType, param string) {
+	g.foutputFrag("%s%s(%s)", g.derivedPrefix(d), g.importFunc(d.Name.Name), param)
+}
+
+func (g *TypeScriptEmitter) emitImportPrimitive(helper string, param string) {
+	g.foutputFrag("%s(%s)", g.use(helper), param)
+}
+
+func (g *TypeScriptEmitter) EmitImportText(t Text, param string) {
+	g.emitImportPrimitive("text__", param)
+}
+func (g *TypeScriptEmitter) EmitImportUint(u Uint, param string) {
+	g.emitImportPrimitive("uint__", param)
+}
+func (g *TypeScriptEmitter) EmitImportInt(i Int, param string) { g.emitImportPrimitive("int__", param) }
+func (g *TypeScriptEmitter) EmitImportBool(b Bool, param string) {
+	g.emitImportPrimitive("bool__", param)
+}
+
+// Sized types are range-checked, so that a value that doesn't fit is an
+// error rather than being silently truncated.
+func (g *TypeScriptEmitter) EmitImportSizedInt(s SizedInt, param string) {
+	helper := "uintN__"
+	if s.Signed {
+		helper = "intN__"
+	}
+	g.foutputFrag("%s(%s, %d)", g.use(helper), param, s.Bits)
+}
+func (g *TypeScriptEmitter) EmitImportFloat(f Float, param string) {
+	g.foutputFrag("%s(%s, %d)", g.use("float__"), param, f.Bits)
+}
+
+func (g *TypeScriptEmitter) EmitImportBlob(b Blob, param string) {
+	g.foutputFrag("%s(%s, %d)", g.use("blob__"), param, b.Count)
+}
+
+func (g *TypeScriptEmitter) EmitImportFuture(f Future, param string) {
+	g.foutputFrag("%s(%s, 0)", g.use("blob__"), param)
+}
+
+// Go needs these to give typedefs a Bytes() method and to link futures to
+// their parents; there's no equivalent in TypeScript.
+func (g *TypeScriptEmitter) EmitBytesDowncast(klass string, varName string) {}
+func (g *TypeScriptEmitter) EmitNil()                                       {}
+func (g *TypeScriptEmitter) EmitBlobToBytes(nm string)                      {}
+func (g *TypeScriptEmitter) EmitFutureLink(t Type, child string)            {}
+
+// sortedFields returns the fields of a struct in wire order.
+func sortedFields(fields []Field) []Field {
+	ret := append([]Field(nil), fields...)
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Pos < ret[j].Pos })
+	return ret
+}
+
+func (g *TypeScriptEmitter) EmitStruct(s Struct) {
+	g.emitStatementPremable(s.BaseStatement)
+	exsym := g.exportSymbol(s.Ident.Name)
+	fields := sortedFields(s.Fields)
+	if len(fields) == 0 {
+		g.foutputLine("export interface %s {}", exsym)
+	} else {
+		g.foutputLine("export interface %s {", exsym)
+		g.tab()
+		for _, f := range s.Fields {
+			g.foutputFrag("%s: ", f.Ident.Name)
+			f.Type.Emit(g)
+			g.outputLine(";")
+		}
+		g.untab()
+		g.outputLine("}")
+	}
+
+	g.emitConverters(s.Ident.Name,
+		func() {
+			if len(fields) == 0 {
+				g.outputLine("return [];")
+				return
+			}
+			// Structs go over the wire as arrays indexed by field position,
+			// with null in the slots of retired fields.
+			g.outputLine("return [")
+			g.tab()
+			i := 0
+			for _, f := range fields {
+				for ; i < f.Pos; i++ {
+					g.outputLine("null,")
+				}
+				f.Type.EmitExport(g, "x."+f.Ident.Name)
+				g.outputLine(",")
+				i++
+			}
+			g.untab()
+			g.outputLine("];")
+		},
+		func() {
+			if len(fields) == 0 {
+				g.foutputLine("%s(x);", g.use("tuple__"))
+				g.outputLine("return {};")
+				return
+			}
+			g.foutputLine("const a = %s(x);", g.use("tuple__"))
+			g.outputLine("return {")
+			g.tab()
+			for _, f := range s.Fields {
+				g.foutputFrag("%s: ", f.Ident.Name)
+				f.Type.EmitImport(g, fmt.Sprintf("a[%d]", f.Pos))
+				g.outputLine(",")
+			}
+			g.untab()
+			g.outputLine("};")
+		},
+	)
+	g.emitID(s.BaseTypedef)
+	g.emptyLine()
+}
+
+// caseValue is the TypeScript value of a variant case label.
+func (g *TypeScriptEmitter) caseValue(l CaseLabel, switchType Type) string {
+	switch l := l.(type) {
+	case CaseLabelNumber:
+		if _, isSized := Underlying(switchType).(SizedInt); isSized {
+			return fmt.Sprintf("%d", l.Num)
+		}
+		return fmt.Sprintf("%dn", l.Num)
+	case CaseLabelBool:
+		return fmt.Sprintf("%t", l.Bool)
+	}
+	return l.CaseLabelToString(g, switchType)
+}
+
+// caseType is the type of the switch field for one case of a variant. The
+// default case gets whatever the other cases leave over.
+func (g *TypeScriptEmitter) caseType(v Variant, c Case) string {
+	var labels []string
+	for _, l := range c.Labels {
+		val := g.caseValue(l, v.SwitchType)
+		if _, isIdent := l.(CaseLabelIdentifier); isIdent {
+			val = "typeof " + val
+		}
+		labels = append(labels, val)
+	}
+	if c.Labels != nil {
+		return strings.Join(labels, " | ")
+	}
+	for _, oc := range v.Cases {
+		if oc.Labels != nil {
+			labels = append(labels, g.caseType(v, oc))
+		}
+	}
+	sw := g.typeString(v.SwitchType)
+	if len(labels) == 0 {
+		return sw
+	}
+	return fmt.Sprintf("Exclude<%s, %s>", sw, strings.Join(labels, " | "))
+}
+
+// typeString renders t on its own, rather than into the output.
+func (g *TypeScriptEmitter) typeString(t Type) string {
+	return g.capture(func() { t.Emit(g) })
+}
+
+// capture runs f with output going to a fresh buffer, and returns what it
+// wrote.
+func (g *TypeScriptEmitter) capture(f func()) string {
+	var buf bytes.Buffer
+	dst, isNewline := g.dst, g.isNewline
+	g.dst, g.isNewline = &buf, false
+	f()
+	g.dst, g.isNewline = dst, isNewline
+	return buf.String()
+}
+
+func (g *TypeScriptEmitter) caseField(c Case) string {
+	return fmt.Sprintf("f%d", *c.Position)
+}
+
+func (g *TypeScriptEmitter) EmitVariant(v Variant) {
+	g.emitStatementPremable(v.BaseStatement)
+	exsym := g.exportSymbol(v.Ident.Name)
+	sv := v.SwitchVar.Name
+
+	g.foutputLine("export type %s =", exsym)
+	g.tab()
+	for i, c := range v.Cases {
+		g.foutputFrag("| { %s: %s", sv, g.caseType(v, c))
+		if c.Position != nil {
+			g.foutputFrag("; %s: ", g.caseField(c))
+			c.Type.Emit(g)
+		}
+		g.outputFrag(" }")
+		if i == len(v.Cases)-1 {
+			g.outputFrag(";")
+		}
+		g.emptyLine()
+	}
+	g.untab()
+
+	var hasData bool
+	for _, c := range v.Cases {
+		hasData = hasData || c.Position != nil
+	}
+
+	g.emitConverters(v.Ident.Name,
+		func() {
+			// The case data goes in a map keyed by the base64 of its
+			// position, holding only the case that's set.
+			g.outputLine("const cases: Record<string, unknown> = {};")
+			for _, c := range v.Cases {
+				if c.Position == nil {
+					continue
+				}
+				fld := g.caseField(c)
+				g.foutputLine("if (\"%s\" in x) {", fld)
+				g.tab()
+				g.foutputFrag("cases[\"%s\"] = ", b64encode(*c.Position))
+				c.Type.EmitExport(g, "x."+fld)
+				g.outputLine(";")
+				g.untab()
+				g.outputLine("}")
+			}
+			g.outputFrag("return [")
+			v.SwitchType.EmitExport(g, "x."+sv)
+			g.outputLine(", cases];")
+		},
+		func() {
+			g.foutputLine("const a = %s(x);", g.use("tuple__"))
+			g.outputFrag("const s = ")
+			v.SwitchType.EmitImport(g, "a[0]")
+			g.outputLine(";")
+			if hasData {
+				g.foutputLine("const cases = %s(a[1]);", g.use("cases__"))
+			}
+			g.outputLine("switch (s) {")
+			var hasDefault bool
+			for _, c := range v.Cases {
+				if c.Labels == nil {
+					hasDefault = true
+					g.outputLine("default:")
+				} else {
+					for _, l := range c.Labels {
+						g.foutputLine("case %s:", g.caseValue(l, v.SwitchType))
+					}
+				}
+				g.tab()
+				g.foutputFrag("return { %s: s as %s", sv, g.caseType(v, c))
+				if c.Position != nil {
+					g.foutputFrag(", %s: ", g.caseField(c))
+					c.Type.EmitImport(g, fmt.Sprintf("cases[\"%s\"]", b64encode(*c.Position)))
+				}
+				g.outputLine(" };")
+				g.untab()
+			}
+			if !hasDefault {
+				g.outputLine("default:")
+				g.tab()
+				g.foutputLine("throw new TypeError(\"snowpack: unexpected switch value for %s: \" + s);",
+					v.Ident.Name)
+				g.untab()
+			}
+			g.outputLine("}")
+		},
+	)
+	g.emitID(v.BaseTypedef)
+	g.emptyLine()
+}
+
+func (g *TypeScriptEmitter) protocolID(p Protocol) string {
+	return g.exportSymbol(p.Ident.Name) + "ProtocolID"
+}
+
+func (g *TypeScriptEmitter) emitClientOptions(p Protocol) {
+	exsym := g.exportSymbol(p.Ident.Name)
+	g.foutputLine("export interface %sClientOptions {", exsym)
+	g.tab()
+	g.outputFrag("unwrapError: (e: ")
+	p.Modifiers.Errors.Type.Emit(g)
+	g.outputLine(") => Error;")
+	if p.Modifiers.ArgHeader != nil {
+		g.outputFrag("makeArgHeader?: () => ")
+		p.Modifiers.ArgHeader.Type.Emit(g)
+		g.outputLine(";")
+	}
+	if p.Modifiers.ResHeader != nil {
+		g.outputFrag("checkResHeader?: (h: ")
+		p.Modifiers.ResHeader.Type.Emit(g)
+		g.outputLine(") => void | Promise<void>;")
+	}
+	g.untab()
+	g.outputLine("}")
+	g.emptyLine()
+}
+
+func (g *TypeScriptEmitter) emitClientMethod(p Protocol, m Method) {
+	g.emitDecorators(m.Dec)
+	argStruct := m.makeArgName(g)
+	g.foutputFrag("async %s(", m.Ident.Name)
+	if len(m.Params) > 0 {
+		if m.singleArg() {
+			g.foutputFrag("%s: ", m.Params[0].Ident.Name)
+			m.Params[0].Type.Emit(g)
+		} else {
+			g.foutputFrag("arg: %s", argStruct)
+		}
+	}
+	g.outputFrag("): Promise<")
+	m.ResType.Emit(g)
+	g.outputLine("> {")
+	g.tab()
+
+	var arg string
+	switch {
+	case m.singleArg():
+		arg = fmt.Sprintf("{ %s }", m.Params[0].Ident.Name)
+	case len(m.Params) == 0:
+		arg = "{}"
+	default:
+		arg = "arg"
+	}
+	g.outputLine("const ret = await this.transport.call({")
+	g.tab()
+	g.foutputLine("protocolID: %s,", g.protocolID(p))
+	g.foutputLine("method: %d,", m.Pos)
+	g.foutputLine("name: \"%s.%s\",", p.Ident.Name, m.Ident.Name)
+	g.foutputLine("arg: %s(%s),", g.exportFunc(argStruct), arg)
+	if h := p.Modifiers.ArgHeader; h != nil {
+		g.outputFrag("header: this.opts.makeArgHeader ? ")
+		h.Type.EmitExport(g, "this.opts.makeArgHeader()")
+		g.outputLine(" : undefined,")
+	}
+	g.untab()
+	g.outputLine("});")
+
+	g.outputLine("if (ret.err != null) {")
+	g.tab()
+	g.outputFrag("throw this.opts.unwrapError(")
+	p.Modifiers.Errors.Type.EmitImport(g, "ret.err")
+	g.outputLine(");")
+	g.untab()
+	g.outputLine("}")
+
+	if h := p.Modifiers.ResHeader; h != nil {
+		g.outputLine("if (this.opts.checkResHeader) {")
+		g.tab()
+		g.outputFrag("await this.opts.checkResHeader(")
+		h.Type.EmitImport(g, "ret.header")
+		g.outputLine(");")
+		g.untab()
+		g.outputLine("}")
+	}
+	if !m.ResType.IsVoid() {
+		g.outputFrag("return ")
+		m.ResType.EmitImport(g, "ret.res")
+		g.outputLine(";")
+	}
+	g.untab()
+	g.outputLine("}")
+}
+
+func (g *TypeScriptEmitter) EmitProtocol(p Protocol) {
+	g.hasRpc = true
+	exsym := g.exportSymbol(p.Ident.Name)
+	g.foutputLine("export const %s = %sn;", g.protocolID(p), p.UniqueID.Val)
+	g.emptyLine()
+	for _, m := range p.Methods {
+		s := m.ParamsToStruct(m.makeArgName(g))
+		s.emit(g)
+	}
+	g.emitClientOptions(p)
+
+	g.emitStatementPremable(p.BaseStatement)
+	g.foutputLine("export class %sClient {", exsym)
+	g.tab()
+	g.outputLine("constructor(")
+	g.tab()
+	g.outputLine("private readonly transport: SnowpackTransport,")
+	g.foutputLine("private readonly opts: %sClientOptions,", exsym)
+	g.untab()
+	g.outputLine(") {}")
+	for _, m := range p.Methods {
+		g.emptyLine()
+		g.emitClientMethod(p, m)
+	}
+	g.untab()
+	g.outputLine("}")
+	g.emptyLine()
+}
+
+func (g *TypeScriptEmitter) ToEnumConstant(t Type, nm string) string {
+	return t.DerivedPrefix() + g.exportSymbol(t.EnumPrefix()) + "." + nm
+}
+
+// The getter and constructor names are only used by the Go emitter's variant
+// accessors; they're implemented the same way here to satisfy Emitter.
+func (g *TypeScriptEmitter) GetterMethodNameForBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+func (g *TypeScriptEmitter) GetterMethodNameForInt(i int) string {
+	if i >= 0 {
+		return fmt.Sprintf("P%d", i)
+	}
+	return fmt.Sprintf("N%d", -i)
+}
+
+func (g *TypeScriptEmitter) GetterMethodNameForConstant(s string) string {
+	return g.exportSymbol(s)
+}
+
+func (g *TypeScriptEmitter) ConstructorNameForConstant(vrnt string, cnst string) string {
+	return "new" + g.exportSymbol(vrnt) + "With" + g.exportSymbol(cnst)
+}
+
+func (g *TypeScriptEmitter) ConstructorNameForInt(vrnt string, i int) string {
+	return g.ConstructorNameForConstant(vrnt, g.GetterMethodNameForInt(i))
+}
+
+func (g *TypeScriptEmitter) ConstructorNameForBool(vrnt string, b bool) string {
+	return g.ConstructorNameForConstant(vrnt, g.GetterMethodNameForBool(b))
+}
+
+func (g *TypeScriptEmitter) MethodArgName(mthd string, argName string) string {
+	if len(argName) > 0 {
+		return g.exportSymbol(argName)
+	}
+	return g.exportSymbol(mthd + "Arg")
+}
+
+// EmitImport only records the import; they're written at the top of the file
+// by emitPreamble. The checker makes sure every import has a ts: flavor.
+func (g *TypeScriptEmitter) EmitImport(i Import) {
+	g.storeImport(i)
+}
+
+var _ Emitter = (*TypeScriptEmitter)(nil)
@@ -0,0 +1,365 @@
+package lib
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// genTS checks checkHeader+src and generates TypeScript from it.
+func genTS(t *testing.T, src string) string {
+	t.Helper()
+	r, err := Parse([]byte(checkHeader+src), "t.snowp")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if errs, _ := splitWarnings(Check(r)); len(errs) > 0 {
+		t.Fatalf("check: %v", Diagnostics(errs))
+	}
+	md := &Metadata{infile: newInfile("t.snowp"), lang: LangTypeScript, root: r}
+	var buf bytes.Buffer
+	if err := NewTypeScriptEmitter(md, &buf).Emit(r); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	return buf.String()
+}
+
+// TestEmitTSMapKeys makes sure that Blob keys are hex strings in TypeScript,
+// since a Map compares Uint8Arrays by reference.
+func TestEmitTSMapKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want []string
+		not  []string
+	}{
+		{
+			name: "blob",
+			key:  "Blob(4)",
+			want: []string{
+				"m: Map<string, bigint>",
+				"map__(a[0], (k) => hex__(blob__(k, 4)), ",
+				"exportMap__(x.m, (k) => unhex__(k), ",
+				"function hex__(",
+				"function unhex__(",
+			},
+		},
+		{
+			name: "blob typedef",
+			key:  "Key",
+			want: []string{
+				"m: Map<string, bigint>",
+				"map__(a[0], (k) => hex__(importKey(k)), ",
+				"exportMap__(x.m, (k) => exportKey(unhex__(k)), ",
+			},
+		},
+		{
+			name: "text",
+			key:  "Text",
+			want: []string{
+				"m: Map<string, bigint>",
+				"map__(a[0], (k) => text__(k), ",
+				"exportMap__(x.m, (k) => k, ",
+			},
+			not: []string{"hex__"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := genTS(t, `typedef Key = Blob(4);
+struct S {
+    m @0 : Map(`+tt.key+`, Uint);
+}`)
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("no %q in:\n%s", w, out)
+				}
+			}
+			for _, n := range tt.not {
+				if strings.Contains(out, n) {
+					t.Errorf("%q in:\n%s", n, out)
+				}
+			}
+		})
+	}
+}
+
+// TestEmitTSSizedDecode makes sure that generated TypeScript checks sized
+// values with the helpers of the right size, and runs those helpers under
+// node to make sure that they reject values that don't fit.
+func TestEmitTSSizedDecode(t *testing.T) {
+	out := genTS(t, `struct S {
+    a @0 : Int8;
+    b @1 : Uint16;
+    c @2 : Float32;
+    d @3 : List(Int32);
+}`)
+	for _, w := range []string{
+		"a: intN__(a[0], 8),",
+		"b: uintN__(a[1], 16),",
+		"c: float__(a[2], 32),",
+		"d: list__(a[3], (v) => intN__(v, 32)),",
+	} {
+		if !strings.Contains(out, w) {
+			t.Errorf("no %q in:\n%s", w, out)
+		}
+	}
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("no node")
+	}
+	// These helpers only have simple type annotations, which come off with
+	// a regexp, leaving JavaScript.
+	types := regexp.MustCompile(`: (unknown|number|bigint|never|string)\b`)
+	var js strings.Builder
+	for _, h := range tsHelpers {
+		switch h.name {
+		case "fail__", "int__", "uint__", "intN__", "uintN__", "float__":
+			js.WriteString(types.ReplaceAllString(h.src, "") + "\n")
+		}
+	}
+	js.WriteString(`for (const [f, x, bits] of [
+	[intN__, 127, 8], [intN__, 128, 8], [intN__, -128, 8], [intN__, -129, 8],
+	[intN__, 2147483648n, 32], [intN__, 1.5, 8],
+	[uintN__, 65535, 16], [uintN__, 65536, 16], [uintN__, -1, 16],
+	[float__, 1.5, 32], [float__, 1e300, 32], [float__, 1e300, 64],
+	[float__, Infinity, 32], [float__, "1", 32],
+]) {
+	try {
+		f(x, bits);
+		console.log("ok");
+	} catch (e) {
+		console.log(e.message);
+	}
+}
+`)
+	cmd := exec.Command(node, "-e", js.String())
+	got, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, got)
+	}
+	want := `ok
+snowpack: expected int8, got number
+ok
+snowpack: expected int8, got number
+snowpack: expected int32, got bigint
+snowpack: expected integer, got number
+ok
+snowpack: expected uint16, got number
+snowpack: expected unsigned integer, got number
+ok
+snowpack: expected float32, got number
+ok
+ok
+snowpack: expected number, got string
+`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEmitTS(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+		not  []string
+	}{
+		{
+			name: "struct",
+			src: `struct S @0xabcdef01 {
+    name @0 : Text;
+    reserved @1;
+    n @2 : Uint;
+    o @3 : Option(Int);
+    l @4 : List(Bool);
+}`,
+			want: []string{
+				"export interface S {\n\tname: string;\n\tn: bigint;\n\to: bigint | null;\n\tl: Array<boolean>;\n}",
+				// The reserved position goes over the wire as a nil.
+				"\treturn [\n\t\tx.name,\n\t\tnull,\n\t\tx.n,\n\t\t(x.o == null ? null : x.o),\n\t\texportList__(x.l, (v) => v),\n\t];",
+				"\t\tname: text__(a[0]),\n\t\tn: uint__(a[2]),\n\t\to: (a[3] == null ? null : int__(a[3])),\n\t\tl: list__(a[4], (v) => bool__(v)),",
+				"export const STypeUniqueID = 0xabcdef01n;",
+			},
+		},
+		{
+			name: "enum",
+			src:  "enum Color { Red @0; Green @7; }",
+			want: []string{
+				"export const Color = {\n\tRed: 0,\n\tGreen: 7,\n} as const;",
+				"export type Color = (typeof Color)[keyof typeof Color];",
+				"return enum__(x) as Color;",
+			},
+		},
+		{
+			name: "variant",
+			src: `enum Color { Red @0; Green @1; Blue @2; }
+variant V switch (t : Color) {
+    case Red @10 : Text;
+    case Green @64 : Uint;
+    case Blue : void;
+}`,
+			want: []string{
+				"\t| { t: typeof Color.Red; f10: string }\n\t| { t: typeof Color.Green; f64: bigint }\n\t| { t: typeof Color.Blue };",
+				// Case keys are the positions in the snowpack base64.
+				`cases["a"] = x.f10;`,
+				`cases["01"] = x.f64;`,
+				`f10: text__(cases["a"])`,
+				`f64: uint__(cases["01"])`,
+				// Without a default, an unknown switch value is an error.
+				`throw new TypeError("snowpack: unexpected switch value for V: " + s);`,
+			},
+		},
+		{
+			name: "variant with a default",
+			src: `variant W switch (t : Uint) {
+    case 1 @0 : Bool;
+    default : void;
+}`,
+			want: []string{
+				"\t| { t: 1n; f0: boolean }\n\t| { t: Exclude<bigint, 1n> };",
+				"\tdefault:\n\t\treturn { t: s as Exclude<bigint, 1n> };",
+			},
+			not: []string{"unexpected switch value"},
+		},
+		{
+			name: "blobs",
+			src: `struct S {
+    k @0 : Blob(4);
+    b @1 : Blob;
+}`,
+			want: []string{
+				"\tk: Uint8Array;\n\tb: Uint8Array;",
+				"k: blob__(a[0], 4),",
+				"b: blob__(a[1], 0),",
+				"if (n > 0 && x.length !== n) return fail__(n + \" bytes\", x);",
+			},
+		},
+		{
+			name: "maps",
+			src: `enum Color { Red @0; }
+struct S {
+    m @0 : Map(Text, List(Color));
+}`,
+			want: []string{
+				"m: Map<string, Array<Color>>;",
+				"exportMap__(x.m, (k) => k, (v) => exportList__(v, (v) => exportColor(v))),",
+				"m: map__(a[0], (k) => text__(k), (v) => list__(v, (v) => importColor(v))),",
+				// Keys are sorted the same way as in Go, so that encodings match.
+				"const keys = Array.from(x.keys()).sort(compare__);",
+			},
+		},
+		{
+			name: "protocol",
+			src: `struct P { x @0 : Uint; }
+protocol Prot errors Text @0xcccccccc {
+    get @0 (id @0 : Uint) -> P;
+    find @1 (id @0 : Uint, who @1 : Text) -> P;
+    ping @3 ();
+}`,
+			want: []string{
+				"export const ProtProtocolID = 0xccccccccn;",
+				"export interface GetArg {\n\tid: bigint;\n}",
+				"export interface PingArg {}",
+				// One parameter is passed on its own, and more in an object.
+				"async get(id: bigint): Promise<P> {",
+				"\t\t\tprotocolID: ProtProtocolID,\n\t\t\tmethod: 0,\n\t\t\tname: \"Prot.get\",\n\t\t\targ: exportGetArg({ id }),",
+				"async find(arg: FindArg): Promise<P> {",
+				"\t\t\tmethod: 1,\n\t\t\tname: \"Prot.find\",\n\t\t\targ: exportFindArg(arg),",
+				"throw this.opts.unwrapError(text__(ret.err));",
+				"return importP(ret.res);",
+				"async ping(): Promise<void> {",
+				"\t\t\tmethod: 3,\n\t\t\tname: \"Prot.ping\",\n\t\t\targ: exportPingArg({}),",
+				"export interface SnowpackTransport {",
+			},
+		},
+		{
+			name: "no protocol",
+			src:  "struct P { x @0 : Uint; }",
+			not:  []string{"SnowpackTransport", "unwrapError"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := genTS(t, tt.src)
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("no %q in:\n%s", w, out)
+				}
+			}
+			for _, n := range tt.not {
+				if strings.Contains(out, n) {
+					t.Errorf("%q in:\n%s", n, out)
+				}
+			}
+		})
+	}
+}
+
+// TestEmitTSCaseKeys makes sure that variant cases have the same keys in
+// TypeScript as in Go, since that's what's on the wire.
+func TestEmitTSCaseKeys(t *testing.T) {
+	src := `variant V switch (t : Uint) {
+    case 0 @0 : Uint;
+    case 1 @9 : Uint;
+    case 2 @10 : Uint;
+    case 3 @63 : Uint;
+    case 4 @64 : Uint;
+    case 5 @4095 : Uint;
+    case 6 @4096 : Uint;
+}`
+	goOut := genGo(t, src)
+	tsOut := genTS(t, src)
+	for _, key := range []string{"0", "9", "a", "_", "01", "__", "001"} {
+		if !strings.Contains(goOut, "`codec:\""+key+"\"`") {
+			t.Errorf("Go has no case key %q", key)
+		}
+		if !strings.Contains(tsOut, `cases["`+key+`"] = `) {
+			t.Errorf("TypeScript has no case key %q", key)
+		}
+	}
+}
+
+func TestEmitTSImports(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "ts:import",
+			src: `import "../lib" as lib;
+ts:import "./lib" as lib;`,
+		},
+		{
+			name: "generic import only",
+			src:  `import "../lib" as lib;`,
+			want: []string{"2:1: lib has no ts:import; TypeScript can't import a .snowp package by itself, " +
+				"so add `ts:import \"...\" as lib;` naming the generated module"},
+		},
+		{
+			name: "go:import only",
+			src:  `go:import "example.com/lib" as lib;`,
+			want: []string{"2:1: lib has no ts:import"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse([]byte(checkHeader+tt.src), "t.snowp")
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			var got []string
+			for _, d := range checkTargetImports(r, LangTypeScript) {
+				got = append(got, strings.TrimPrefix(d.Error(), "t.snowp:"))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n\t%s\nwant:\n\t%s",
+					strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package lib
 
 import (
+	"bytes"
+	"cmp"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 type File struct {
@@ -69,15 +74,52 @@ func (o *Outfile) Name() string {
 	return o.name
 }
 
-func (o *Outfile) Writer() (io.WriteCloser, error) {
+// WriteFile replaces the output file with dat. It writes a temporary file and
+// renames it into place, so nobody ever sees a partly-written file, and it
+// leaves the file alone if it already holds dat, so that build caches and
+// file watchers aren't disturbed.
+func (o *Outfile) WriteFile(dat []byte) (err error) {
 	if o.isStdPipe() {
-		return os.Stdout, nil
+		_, err = os.Stdout.Write(dat)
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(o.name); err == nil {
+		mode = st.Mode().Perm()
+		if st.Size() == int64(len(dat)) {
+			curr, err := os.ReadFile(o.name)
+			if err == nil && bytes.Equal(curr, dat) {
+				return nil
+			}
+		}
+	}
+
+	dir, base := filepath.Split(o.name)
+	if dir == "" {
+		dir = "."
 	}
-	f, err := os.Create(o.name)
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp*")
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return f, nil
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	_, err = tmp.Write(dat)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	cerr := tmp.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), o.name)
 }
 
 func newOutfile(f string) Outfile { return Outfile{File: File{name: f}} }
@@ -88,32 +130,149 @@ type FilePair struct {
 	outfile Outfile
 }
 
+// PackageFiles are the input files that compile together into one package
+// in the target language.
+type PackageFiles struct {
+	key    string // what we tell the Loader: the directory, or the lone file
+	dir    string // where the input files are
+	outdir string // where the output files go; empty when writing one file
+	pkg    string // Go package name, unless a go:package says otherwise
+	files  []FilePair
+}
+
 type FileSet struct {
-	files []FilePair
+	pkgs []*PackageFiles
 }
 
+// buildFromDir walks the input directory. Each directory with input files
+// in it becomes a package, whose output goes in the same place relative to
+// the output directory. Hidden files and directories are skipped, which
+// takes care of editors' lock and backup files too.
 func (f *FileSet) buildFromDir(o *Options) error {
-	ents, err := os.ReadDir(o.indir)
-	if err != nil {
-		return err
-	}
-	for _, ent := range ents {
+	byDir := make(map[string]*PackageFiles)
+	return filepath.WalkDir(o.indir, func(p string, ent fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(o.indir, p)
+		if err != nil {
+			return err
+		}
 		if ent.IsDir() {
-			continue
+			if rel != "." && (strings.HasPrefix(ent.Name(), ".") || matchAny(o.exclude, rel)) {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		ext := filepath.Ext(ent.Name())
-		if ext != o.ext {
-			continue
+		if ext != o.ext || strings.HasPrefix(ent.Name(), ".") || matchAny(o.exclude, rel) {
+			return nil
+		}
+		if len(o.include) > 0 && !matchAny(o.include, rel) {
+			return nil
+		}
+		if o.inputGlob != "" && !matchGlob(o.inputGlob, rel) {
+			return nil
+		}
+
+		relDir := filepath.Dir(rel)
+		pf := byDir[relDir]
+		if pf == nil {
+			dir := filepath.Join(o.indir, relDir)
+			var outdir string
+			if o.outdir != "" {
+				outdir = filepath.Join(o.outdir, relDir)
+			}
+			pkg := o.pkg
+			if relDir != "." || pkg == "" {
+				pkg = goPackageName(cmp.Or(outdir, dir))
+			}
+			pf = &PackageFiles{key: dir, dir: dir, outdir: outdir, pkg: pkg}
+			byDir[relDir] = pf
+			f.pkgs = append(f.pkgs, pf)
 		}
 		basename := ent.Name()[:len(ent.Name())-len(ext)]
+		pf.files = append(pf.files, FilePair{
+			infile:  newInfile(p),
+			outfile: newOutfile(filepath.Join(pf.outdir, basename+"."+o.lang.OutExt())),
+		})
+		return nil
+	})
+}
 
-		fp := FilePair{
-			infile:  newInfile(filepath.Join(o.indir, ent.Name())),
-			outfile: newOutfile(filepath.Join(o.outdir, basename+"."+o.lang.OutExt())),
+// matchAny reports whether any of the glob patterns match rel, a path
+// relative to the input directory. Patterns with a slash in them are matched
+// against the whole path, and others just against the last element, so
+// `*_test.snowp` works at any depth.
+func matchAny(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pat := range patterns {
+		target := rel
+		if !strings.Contains(pat, "/") {
+			target = path.Base(rel)
+		}
+		if ok, _ := path.Match(pat, target); ok {
+			return true
 		}
-		f.files = append(f.files, fp)
 	}
-	return nil
+	return false
+}
+
+// matchGlob reports whether glob matches rel, a path relative to the input
+// directory, an element at a time. An element that's just `**` matches any
+// number of directories.
+func matchGlob(glob, rel string) bool {
+	return matchElems(strings.Split(glob, "/"), strings.Split(filepath.ToSlash(rel), "/"))
+}
+
+func matchElems(glob, elems []string) bool {
+	if len(glob) == 0 {
+		return len(elems) == 0
+	}
+	if glob[0] == "**" {
+		for i := range len(elems) + 1 {
+			if matchElems(glob[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	ok, _ := path.Match(glob[0], elems[0])
+	return ok && matchElems(glob[1:], elems[1:])
+}
+
+// validGlob is false if glob is malformed, which path.Match only notices
+// when it gets that far.
+func validGlob(glob string) bool {
+	for _, e := range strings.Split(glob, "/") {
+		if _, err := path.Match(e, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// goPackageName makes a Go package name out of the name of dir, or returns
+// "" if there's nothing usable in it.
+func goPackageName(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(filepath.Base(abs)) {
+		if r == '_' || isDigit(r) || (r >= 'a' && r <= 'z') {
+			b.WriteRune(r)
+		}
+	}
+	ret := b.String()
+	if ret == "" || isDigit(rune(ret[0])) {
+		return ""
+	}
+	return ret
 }
 
 func (f *FileSet) Build(opts *Options) error {
@@ -124,58 +283,129 @@ func (f *FileSet) Build(opts *Options) error {
 		infile:  newInfile(opts.infile),
 		outfile: newOutfile(opts.outfile),
 	}
-	f.files = append(f.files, fp)
+	key := fp.infile.Filename(".")
+	f.pkgs = append(f.pkgs, &PackageFiles{
+		key:   key,
+		dir:   filepath.Dir(key),
+		pkg:   opts.pkg,
+		files: []FilePair{fp},
+	})
 	return nil
 }
 
-func (f *FilePair) run(o *Options) error {
-	md := NewMetadata(f, o)
-	return md.run()
-}
-
 type Metadata struct {
 	infile  Infile
 	outfile Outfile
 	lang    Language
 	pkg     string
 	verbose bool
+	root    *Root
+	input   []byte
+	output  []byte
+	deps    []string // see packageDeps
+	fromAST bool
+	baseDir string
+
+	goImports      map[string]string // see addGoImports
+	warnExhaustive bool
 }
 
-func NewMetadata(fp *FilePair, o *Options) Metadata {
-	return Metadata{
+func NewMetadata(fp *FilePair, o *Options) *Metadata {
+	return &Metadata{
 		infile:  fp.infile,
 		outfile: fp.outfile,
 		lang:    o.lang,
 		pkg:     o.pkg,
 		verbose: o.verbose,
+		fromAST: o.fromAST,
+		baseDir: o.baseDir,
+
+		goImports:      o.goImports,
+		warnExhaustive: o.warnExhaustive,
 	}
 }
 
-func (m *Metadata) run() error {
-
-	if m.verbose && !m.infile.isStdPipe() && !m.outfile.isStdPipe() {
-		fmt.Fprintf(os.Stderr, "🏗️  %s → %s\n", m.infile.Name(), m.outfile.Name())
+// inputName is what to call the input file in the header of the generated
+// code. Targets from a config name it relative to the config's directory, so
+// the output is the same wherever snowpc is run from.
+func (m *Metadata) inputName() string {
+	nm := m.infile.Name()
+	if m.baseDir == "" || m.infile.isStdPipe() {
+		return nm
+	}
+	rel, err := filepath.Rel(m.baseDir, absOr(nm))
+	if err != nil {
+		return nm
 	}
+	return filepath.ToSlash(rel)
+}
 
+func (m *Metadata) parse() error {
 	indat, err := m.infile.Read()
 	if err != nil {
 		return err
 	}
-	out, err := m.outfile.Writer()
-	if err != nil {
-		return err
+	m.input = indat
+	if m.fromAST {
+		m.root, err = UnmarshalAST(indat)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.infile.Name(), err)
+		}
+		return nil
+	}
+	m.root, err = Parse(indat, m.infile.Name())
+	return err
+}
+
+// check resolves the file against its package's scope, loading imports as
+// needed, and then validates it.
+func (m *Metadata) check(scope *Scope, imp Importer) []Diagnostic {
+	if m.lang == LangGo {
+		addGoImports(m.root, m.goImports)
 	}
-	dat, err := Parse(indat, m.infile.Name())
+	diags := Resolve(m.root, scope, imp)
+	diags = append(diags, validate(m.root, m.warnExhaustive)...)
+	diags = append(diags, checkTargetImports(m.root, m.lang)...)
+	return diags
+}
+
+// emit generates the output for the file into memory. Nothing is written
+// until write is called.
+func (m *Metadata) emit() error {
+	var buf bytes.Buffer
+	err := m.emitTo(&buf)
 	if err != nil {
 		return err
 	}
-	if m.lang != LangGo {
-		return errors.New("only go target langauge is currently supported")
+	m.output = buf.Bytes()
+	return nil
+}
+
+// logWrite says what we're about to write, if asked to. It isn't part of
+// emit, since files are emitted in parallel, and the log should come out in
+// the same order every time.
+func (m *Metadata) logWrite() {
+	if m.verbose && !m.infile.isStdPipe() && !m.outfile.isStdPipe() {
+		fmt.Fprintf(os.Stderr, "🏗️  %s → %s\n", m.infile.Name(), m.outfile.Name())
 	}
-	emitter := NewGoEmitter(m, out)
+}
 
-	// Will panic() if we hit any error
-	emitter.Emit(dat)
+func (m *Metadata) write() error {
+	if !m.outfile.isStdPipe() {
+		err := os.MkdirAll(filepath.Dir(m.outfile.name), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	return m.outfile.WriteFile(m.output)
+}
 
-	return nil
+func (m *Metadata) emitTo(out io.Writer) error {
+	switch m.lang {
+	case LangGo:
+		return NewGoEmitter(m, out).Emit(m.root)
+	case LangTypeScript:
+		return NewTypeScriptEmitter(m, out).Emit(m.root)
+	}
+	return errors.New("unsupported target language")
 }
@@ -0,0 +1,312 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dirNames lists the names in dir, including hidden ones.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret []string
+	for _, e := range ents {
+		ret = append(ret, e.Name())
+	}
+	return ret
+}
+
+func TestWriteFile(t *testing.T) {
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name     string
+		old      string // "" for no file there yet
+		mode     os.FileMode
+		dat      string
+		wantMode os.FileMode
+		touched  bool
+	}{
+		{name: "new file", dat: "new", wantMode: 0644, touched: true},
+		{name: "changed", old: "old", mode: 0644, dat: "new", wantMode: 0644, touched: true},
+		{name: "same size, changed", old: "abc", mode: 0644, dat: "abd", wantMode: 0644, touched: true},
+		{name: "unchanged", old: "same", mode: 0644, dat: "same", wantMode: 0644},
+		{name: "keeps its mode", old: "old", mode: 0600, dat: "new", wantMode: 0600, touched: true},
+		{name: "keeps the x bit", old: "old", mode: 0755, dat: "new", wantMode: 0755, touched: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p := filepath.Join(dir, "out.go")
+			if tt.old != "" {
+				if err := os.WriteFile(p, []byte(tt.old), tt.mode); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(p, tt.mode); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(p, past, past); err != nil {
+					t.Fatal(err)
+				}
+			}
+			of := newOutfile(p)
+			if err := of.WriteFile([]byte(tt.dat)); err != nil {
+				t.Fatal(err)
+			}
+			dat, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(dat) != tt.dat {
+				t.Errorf("got %q; want %q", dat, tt.dat)
+			}
+			st, err := os.Stat(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.Mode().Perm() != tt.wantMode {
+				t.Errorf("mode %v; want %v", st.Mode().Perm(), tt.wantMode)
+			}
+			if touched := !st.ModTime().Equal(past); touched != tt.touched {
+				t.Errorf("touched: %t; want %t", touched, tt.touched)
+			}
+			if names := dirNames(t, dir); len(names) != 1 {
+				t.Errorf("left behind: %v", names)
+			}
+		})
+	}
+}
+
+// TestWriteFileFails makes sure that a failed write leaves no temporary
+// file behind, and whatever was there before alone.
+func TestWriteFileFails(t *testing.T) {
+	t.Run("can't rename over it", func(t *testing.T) {
+		dir := t.TempDir()
+		p := filepath.Join(dir, "out.go")
+		writeFiles(t, dir, map[string]string{"out.go/keep": "kept"})
+		of := newOutfile(p)
+		if err := of.WriteFile([]byte("new")); err == nil {
+			t.Fatal("no error")
+		}
+		if names := dirNames(t, dir); strings.Join(names, " ") != "out.go" {
+			t.Errorf("left behind: %v", names)
+		}
+		if dat, err := os.ReadFile(filepath.Join(p, "keep")); err != nil || string(dat) != "kept" {
+			t.Errorf("got %q, %v", dat, err)
+		}
+	})
+	t.Run("no directory", func(t *testing.T) {
+		dir := t.TempDir()
+		of := newOutfile(filepath.Join(dir, "missing", "out.go"))
+		if err := of.WriteFile([]byte("new")); err == nil {
+			t.Fatal("no error")
+		}
+		if names := dirNames(t, dir); len(names) != 0 {
+			t.Errorf("left behind: %v", names)
+		}
+	})
+}
+
+// TestFailedBuildKeepsOutputs makes sure that a file that doesn't compile
+// leaves its old output alone, rather than truncating it.
+func TestFailedBuildKeepsOutputs(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{name: "syntax error", src: "struct S { a @0 : ; }", want: "syntax error"},
+		{name: "check error", src: "struct S { a @0 : T; }", want: `undefined type "T"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in, out := filepath.Join(dir, "a.snowp"), filepath.Join(dir, "a.go")
+			writeFiles(t, dir, map[string]string{
+				"a.snowp": checkHeader + tt.src,
+				"a.go":    "package a // from last time\n",
+			})
+			o := &Options{langRaw: "go", infile: in, outfile: out, pkg: "a", ext: ".snowp"}
+			if err := o.check(); err != nil {
+				t.Fatal(err)
+			}
+			err := NewRunner(o).Run()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v; want %q", err, tt.want)
+			}
+			dat, err := os.ReadFile(out)
+			if err != nil || string(dat) != "package a // from last time\n" {
+				t.Errorf("output is now %q, %v", dat, err)
+			}
+			if names := dirNames(t, dir); strings.Join(names, " ") != "a.go a.snowp" {
+				t.Errorf("left behind: %v", names)
+			}
+		})
+	}
+}
+
+// TestBuildFromDir checks which files a walk of the input directory finds,
+// and where their outputs go.
+func TestBuildFromDir(t *testing.T) {
+	tree := map[string]string{
+		"in/a.snowp":            "",
+		"in/b.snowp":            "",
+		"in/notes.txt":          "",
+		"in/lib/c.snowp":        "",
+		"in/lib/c_test.snowp":   "",
+		"in/lib/deep/d.snowp":   "",
+		"in/rem/e.snowp":        "",
+		"in/rem/.hidden.snowp":  "",
+		"in/rem/.#e.snowp":      "",
+		"in/.git/f.snowp":       "",
+		"in/.cache/lib/g.snowp": "",
+		"in/123/h.snowp":        "",
+		"in/gen/i.snowp":        "",
+	}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		glob    string
+		want    []string // each package, as "dir (pkg): in>out in>out"
+	}{
+		{
+			name: "everything",
+			want: []string{
+				"in/123 (): 123/h.snowp>out/123/h.go",
+				"in (top): a.snowp>out/a.go b.snowp>out/b.go",
+				"in/gen (gen): gen/i.snowp>out/gen/i.go",
+				"in/lib (lib): lib/c.snowp>out/lib/c.go lib/c_test.snowp>out/lib/c_test.go",
+				"in/lib/deep (deep): lib/deep/d.snowp>out/lib/deep/d.go",
+				"in/rem (rem): rem/e.snowp>out/rem/e.go",
+			},
+		},
+		{
+			name:    "exclude by name at any depth, and a directory",
+			exclude: []string{"*_test.snowp", "lib/deep", "123", "gen"},
+			want: []string{
+				"in (top): a.snowp>out/a.go b.snowp>out/b.go",
+				"in/lib (lib): lib/c.snowp>out/lib/c.go",
+				"in/rem (rem): rem/e.snowp>out/rem/e.go",
+			},
+		},
+		{
+			name:    "include by path",
+			include: []string{"lib/*.snowp", "a.snowp"},
+			want: []string{
+				"in (top): a.snowp>out/a.go",
+				"in/lib (lib): lib/c.snowp>out/lib/c.go lib/c_test.snowp>out/lib/c_test.go",
+			},
+		},
+		{
+			name:    "include and exclude",
+			include: []string{"lib/*"},
+			exclude: []string{"c.snowp"},
+			want: []string{
+				"in/lib (lib): lib/c_test.snowp>out/lib/c_test.go",
+			},
+		},
+		{
+			name: "input glob",
+			glob: "*/*.snowp",
+			want: []string{
+				"in/123 (): 123/h.snowp>out/123/h.go",
+				"in/gen (gen): gen/i.snowp>out/gen/i.go",
+				"in/lib (lib): lib/c.snowp>out/lib/c.go lib/c_test.snowp>out/lib/c_test.go",
+				"in/rem (rem): rem/e.snowp>out/rem/e.go",
+			},
+		},
+		{
+			name:    "nothing matches",
+			include: []string{"*.proto"},
+		},
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, tree)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &Options{
+				lang:      LangGo,
+				indir:     filepath.Join(dir, "in"),
+				outdir:    filepath.Join(dir, "out"),
+				pkg:       "top",
+				ext:       ".snowp",
+				include:   tt.include,
+				exclude:   tt.exclude,
+				inputGlob: tt.glob,
+			}
+			var fs FileSet
+			if err := fs.buildFromDir(o); err != nil {
+				t.Fatal(err)
+			}
+			rel := func(p string) string {
+				r, err := filepath.Rel(dir, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return filepath.ToSlash(r)
+			}
+			var got []string
+			for _, pf := range fs.pkgs {
+				s := rel(pf.dir) + " (" + pf.pkg + "):"
+				for _, fp := range pf.files {
+					s += " " + strings.TrimPrefix(rel(fp.infile.name), "in/") + ">" + rel(fp.outfile.name)
+				}
+				if pf.key != pf.dir || pf.outdir != filepath.Join(o.outdir, strings.TrimPrefix(rel(pf.dir), "in")) {
+					t.Errorf("%s: key %s, outdir %s", rel(pf.dir), pf.key, pf.outdir)
+				}
+				got = append(got, s)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n\t%s\nwant:\n\t%s",
+					strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+
+	t.Run("no input directory", func(t *testing.T) {
+		var fs FileSet
+		err := fs.buildFromDir(&Options{indir: filepath.Join(dir, "missing"), ext: ".snowp"})
+		if err == nil {
+			t.Error("no error")
+		}
+	})
+}
+
+// TestGoPackageDirective makes sure that a go:package in a subdirectory's
+// files names its package in place of the directory's name.
+func TestGoPackageDirective(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"in/a.snowp":        "@0x8a9f2b3c4d5e6f70;\nstruct A { x @0 : Uint; }",
+		"in/lib/b.snowp":    "@0x8a9f2b3c4d5e6f71;\ngo:package mylib;\nstruct B { x @0 : Uint; }",
+		"in/lib/c.snowp":    "@0x8a9f2b3c4d5e6f72;\nstruct C { x @0 : Uint; }",
+		"in/my-dir/d.snowp": "@0x8a9f2b3c4d5e6f73;\nstruct D { x @0 : Uint; }",
+	})
+	o := manifestOptions(dir)
+	for _, od := range []string{"out/lib", "out/my-dir"} {
+		if err := os.MkdirAll(filepath.Join(dir, od), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buildTree(t, o)
+	for file, want := range map[string]string{
+		"out/a.go":        "package top\n",
+		"out/lib/b.go":    "package mylib\n",
+		"out/lib/c.go":    "package mylib\n",
+		"out/my-dir/d.go": "package mydir\n",
+	} {
+		dat, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(dat), want) {
+			t.Errorf("%s: no %q in:\n%s", file, want, dat)
+		}
+	}
+}
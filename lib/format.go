@@ -0,0 +1,555 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Format prints a parsed file back out in canonical style: four-space
+// indents, `name @N : Type;` spacing, and at most one blank line in a row.
+// Comments and docstrings are kept where they were, and formatting a file
+// that's already formatted leaves it alone.
+func Format(r *Root) []byte {
+	p := &printer{comments: r.Comments}
+	p.commentsBefore(r.Id.Span.Start)
+	p.line(r.Id.Span, "@%s;", uniqueIDText(r.Id))
+	for _, s := range r.Stmts {
+		p.stmt(s)
+	}
+	p.commentsBefore(Pos{Line: math.MaxInt, Offset: math.MaxInt})
+	return []byte(strings.Join(p.lines, "\n") + "\n")
+}
+
+// printer builds up the formatted file line by line, tracking where in the
+// source it is, so that comments and blank lines land in the right place.
+type printer struct {
+	lines    []string
+	comments []Comment
+	indent   int
+
+	// lastLine is the source line of whatever we printed most recently, and
+	// is 0 before we've printed anything.
+	lastLine int
+
+	// opened is set just after we print a `{`, since blank lines don't belong
+	// at the start of a block.
+	opened bool
+}
+
+func (p *printer) line(sp Span, format string, args ...any) {
+	p.gap(sp.Start.Line)
+	p.lines = append(p.lines, strings.Repeat("    ", p.indent)+fmt.Sprintf(format, args...))
+	p.lastLine = sp.End.Line
+	p.opened = false
+}
+
+// gap prints a blank line if the source had one or more between what we
+// printed last and the line we're about to print.
+func (p *printer) gap(line int) {
+	if p.lastLine > 0 && !p.opened && line > p.lastLine+1 {
+		p.lines = append(p.lines, "")
+	}
+}
+
+// commentsBefore prints every comment that starts before pos. A comment on
+// the same source line as what we printed last goes at the end of that line;
+// the rest get their own lines.
+func (p *printer) commentsBefore(pos Pos) {
+	for len(p.comments) > 0 && p.comments[0].Span.Start.Offset < pos.Offset {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		if len(p.lines) > 0 && c.Span.Start.Line == p.lastLine {
+			p.lines[len(p.lines)-1] += " " + c.Text
+			p.lastLine = c.Span.End.Line
+			continue
+		}
+		p.line(c.Span, "%s", c.Text)
+	}
+}
+
+// close prints the `}` that ends a block running through end, after any
+// comments left inside the block.
+func (p *printer) close(end Pos) {
+	p.commentsBefore(end)
+	p.indent--
+	p.opened = false
+	p.lines = append(p.lines, strings.Repeat("    ", p.indent)+"}")
+	p.lastLine = end.Line
+}
+
+// hasCommentsBefore is true if there's a comment left to print that starts
+// before pos.
+func (p *printer) hasCommentsBefore(pos Pos) bool {
+	return len(p.comments) > 0 && p.comments[0].Span.Start.Offset < pos.Offset
+}
+
+func (p *printer) doc(d Docstring) {
+	if d.Span.IsValid() {
+		p.line(d.Span, "/**%s*/", d.Raw)
+	}
+}
+
+// item prints the docstring and comments that precede a declaration or a
+// member, then the line it starts on.
+func (p *printer) item(d Docstring, sp Span, format string, args ...any) {
+	p.commentsBefore(itemStart(d, sp))
+	p.doc(d)
+	p.line(sp, format, args...)
+}
+
+func itemStart(d Docstring, sp Span) Pos {
+	if d.Span.IsValid() {
+		return d.Span.Start
+	}
+	return sp.Start
+}
+
+func (p *printer) stmt(s Statement) {
+	switch s := s.(type) {
+	case Import:
+		kw := "import"
+		switch s.Lang {
+		case LangGo:
+			kw = "go:import"
+		case LangTypeScript:
+			kw = "ts:import"
+		}
+		p.item(Docstring{}, s.Span, "%s \"%s\" as %s;", kw, s.Path, s.Name)
+	case GoPackage:
+		p.item(Docstring{}, s.Span, "go:package %s;", s.Name.Name)
+	case Typedef:
+		p.item(s.Dec.Doc, s.Span, "typedef %s%s = %s;",
+			s.Ident.Name, uniqueIDSuffix(s.UniqueID), formatType(s.Type))
+	case Const:
+		p.item(s.Dec.Doc, s.Span, "const %s : %s = %s;",
+			s.Ident.Name, formatType(s.Type), formatLiteral(s.Value))
+	case Struct:
+		p.struct_(s)
+	case Enum:
+		p.enum(s)
+	case Variant:
+		p.variant(s)
+	case Protocol:
+		p.protocol(s)
+	}
+}
+
+func uniqueIDSuffix(u UniqueID) string {
+	if u.IsZero() && !u.Pending {
+		return ""
+	}
+	return " @" + uniqueIDText(u)
+}
+
+func uniqueIDText(u UniqueID) string {
+	if u.Pending {
+		return "new"
+	}
+	return u.Val
+}
+
+// header prints the line that opens a struct, enum, variant or protocol
+// declaration, which is all of it if it's empty. Otherwise, what follows is
+// indented, up until the matching close.
+func (p *printer) header(d Docstring, sp Span, end Pos, n int, head string) bool {
+	p.commentsBefore(itemStart(d, sp))
+	empty := n == 0 && !p.hasCommentsBefore(end)
+	if empty {
+		p.item(d, sp, "%s {}", head)
+		p.lastLine = end.Line
+		return false
+	}
+	p.item(d, sp, "%s {", head)
+	p.opened = true
+	p.indent++
+	return true
+}
+
+// headerSpan runs from the start of a declaration to the last thing before
+// its `{`, which is where trailing comments and blank lines are measured
+// from.
+func headerSpan(s Span, last ...Span) Span {
+	ret := Span{Start: s.Start, End: s.Start}
+	for _, l := range last {
+		if l.IsValid() {
+			ret.End = l.End
+		}
+	}
+	return ret
+}
+
+// member is one thing inside a block. Reserved clauses are stored apart from
+// the fields, cases or methods they're mixed in with, so we put them back in
+// source order before printing.
+type member struct {
+	span  Span
+	print func()
+}
+
+func (p *printer) members(ms []member) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		return ms[i].span.Start.Offset < ms[j].span.Start.Offset
+	})
+	for _, m := range ms {
+		m.print()
+	}
+}
+
+// reservedMembers groups the items of reserved clauses back into clauses.
+// Items on the same source line, with nothing else in between, go in one
+// clause; that's a good guess at how they were written, and it makes the
+// output stable.
+func (p *printer) reservedMembers(rs []Reserved, others []member) []member {
+	between := func(a, b Span) bool {
+		for _, o := range others {
+			if o.span.Start.Offset > a.Start.Offset && o.span.Start.Offset < b.Start.Offset {
+				return true
+			}
+		}
+		return p.hasCommentsBetween(a.Start, b.Start)
+	}
+	var ret []member
+	for i := 0; i < len(rs); {
+		j := i + 1
+		for j < len(rs) && rs[j].Span.Start.Line == rs[j-1].Span.Start.Line &&
+			!between(rs[j-1].Span, rs[j].Span) {
+			j++
+		}
+		group := rs[i:j]
+		ret = append(ret, member{span: group[0].Span, print: func() {
+			var items []string
+			for _, r := range group {
+				if r.Pos != nil {
+					items = append(items, fmt.Sprintf("@%d", *r.Pos))
+				} else {
+					items = append(items, r.Name)
+				}
+			}
+			sp := group[0].Span.Extend(group[len(group)-1].Span)
+			p.item(Docstring{}, sp, "reserved %s;", strings.Join(items, ", "))
+		}})
+		i = j
+	}
+	return ret
+}
+
+func (p *printer) struct_(s Struct) {
+	sp := headerSpan(s.Span, s.Ident.Span, s.UniqueID.Span)
+	head := "struct " + s.Ident.Name + uniqueIDSuffix(s.UniqueID)
+	if !p.header(s.Dec.Doc, sp, s.Span.End, len(s.Fields)+len(s.Reserved), head) {
+		return
+	}
+	var ms []member
+	for _, f := range s.Fields {
+		ms = append(ms, member{span: f.Span, print: func() {
+			p.item(Docstring{}, f.Span, "%s;", formatField(f.Ident, f.Pos, f.Type))
+		}})
+	}
+	ms = append(ms, p.reservedMembers(s.Reserved, ms)...)
+	p.members(ms)
+	p.close(s.Span.End)
+}
+
+func formatField(id Identifier, pos int, t Type) string {
+	return fmt.Sprintf("%s @%d : %s", id.Name, pos, formatType(t))
+}
+
+func (p *printer) enum(e Enum) {
+	sp := headerSpan(e.Span, e.Ident.Span)
+	if !p.header(e.Dec.Doc, sp, e.Span.End, len(e.Values), "enum "+e.Ident.Name) {
+		return
+	}
+	for _, v := range e.Values {
+		num := fmt.Sprintf("%d", v.Num)
+		if v.NumConst.Name != "" {
+			num = v.NumConst.Name
+		}
+		p.item(Docstring{}, v.Span, "%s @%s;", v.Ident.Name, num)
+	}
+	p.close(e.Span.End)
+}
+
+func (p *printer) variant(v Variant) {
+	sp := headerSpan(v.Span, v.SwitchType.GetSpan(), v.UniqueID.Span)
+	head := fmt.Sprintf("variant %s switch (%s : %s)%s",
+		v.Ident.Name, v.SwitchVar.Name, formatType(v.SwitchType), uniqueIDSuffix(v.UniqueID))
+	if !p.header(v.Dec.Doc, sp, v.Span.End, len(v.Cases)+len(v.Reserved), head) {
+		return
+	}
+	var ms []member
+	for _, c := range v.Cases {
+		ms = append(ms, member{span: c.Span, print: func() {
+			p.item(Docstring{}, c.Span, "%s", formatCase(c))
+		}})
+	}
+	ms = append(ms, p.reservedMembers(v.Reserved, ms)...)
+	p.members(ms)
+	p.close(v.Span.End)
+}
+
+func formatCase(c Case) string {
+	var b strings.Builder
+	if c.Labels == nil {
+		b.WriteString("default")
+	} else {
+		var labels []string
+		for _, l := range c.Labels {
+			labels = append(labels, formatCaseLabel(l))
+		}
+		b.WriteString("case " + strings.Join(labels, ", "))
+	}
+	if c.Position != nil {
+		fmt.Fprintf(&b, " @%d", *c.Position)
+	}
+	b.WriteString(" : " + formatType(c.Type) + ";")
+	return b.String()
+}
+
+func formatCaseLabel(l CaseLabel) string {
+	switch l := l.(type) {
+	case CaseLabelIdentifier:
+		return l.Ident.Name
+	case CaseLabelNumber:
+		return fmt.Sprintf("%d", l.Num)
+	case CaseLabelBool:
+		return fmt.Sprintf("%t", l.Bool)
+	}
+	return ""
+}
+
+// protocol keeps the modifiers on the first line if that's where they
+// started out, and otherwise puts each on its own line, indented like the
+// methods that follow.
+func (p *printer) protocol(pr Protocol) {
+	mods := pr.Modifiers
+	parts := []string{"errors " + formatType(mods.Errors.Type)}
+	if mods.ArgHeader != nil {
+		parts = append(parts, "argHeader "+formatType(mods.ArgHeader.Type))
+	}
+	if mods.ResHeader != nil {
+		parts = append(parts, "resHeader "+formatType(mods.ResHeader.Type))
+	}
+	sep := " "
+	if mods.Errors.Span.Start.Line != pr.Span.Start.Line {
+		sep = "\n" + strings.Repeat("    ", p.indent+1)
+	}
+	head := "protocol " + pr.Ident.Name + sep + strings.Join(parts, sep) + uniqueIDSuffix(pr.UniqueID)
+
+	sp := headerSpan(pr.Span, pr.UniqueID.Span)
+	if !p.header(pr.Dec.Doc, sp, pr.Span.End, len(pr.Methods)+len(pr.Reserved), head) {
+		return
+	}
+	var ms []member
+	for _, m := range pr.Methods {
+		ms = append(ms, member{span: m.Span, print: func() { p.method(m) }})
+	}
+	ms = append(ms, p.reservedMembers(pr.Reserved, ms)...)
+	p.members(ms)
+	p.close(pr.Span.End)
+}
+
+// method prints the parameters on one line with the method if they started
+// out that way, and one per line otherwise.
+func (p *printer) method(m Method) {
+	tail := ")"
+	if m.ArgType.Name != "" {
+		tail += " : " + m.ArgType.Name
+	}
+	if m.ResType.GetSpan().IsValid() && !m.ResType.IsVoid() {
+		tail += " -> " + formatType(m.ResType)
+	}
+	tail += ";"
+
+	head := fmt.Sprintf("%s @%d (", m.Ident.Name, m.Pos)
+	var end Pos
+	if n := len(m.Params); n > 0 {
+		end = m.Params[n-1].Span.End
+	}
+	multiline := false
+	for _, prm := range m.Params {
+		if prm.Span.Start.Line != m.Ident.Span.Start.Line {
+			multiline = true
+		}
+	}
+	if len(m.Params) > 0 && p.hasCommentsBetween(m.Ident.Span.Start, end) {
+		multiline = true
+	}
+	if !multiline {
+		var params []string
+		for _, prm := range m.Params {
+			params = append(params, formatField(prm.Ident, prm.Pos, prm.Type))
+		}
+		p.item(m.Dec.Doc, m.Span, "%s%s%s", head, strings.Join(params, ", "), tail)
+		return
+	}
+
+	p.item(m.Dec.Doc, m.Ident.Span, "%s", head)
+	p.opened = true
+	p.indent++
+	for i, prm := range m.Params {
+		comma := ","
+		if i == len(m.Params)-1 {
+			comma = ""
+		}
+		p.item(Docstring{}, prm.Span, "%s%s", formatField(prm.Ident, prm.Pos, prm.Type), comma)
+	}
+	p.commentsBefore(m.Span.End)
+	p.indent--
+	p.lines = append(p.lines, strings.Repeat("    ", p.indent)+tail)
+	p.lastLine = m.Span.End.Line
+}
+
+func (p *printer) hasCommentsBetween(a, b Pos) bool {
+	for _, c := range p.comments {
+		if c.Span.Start.Offset > a.Offset && c.Span.Start.Offset < b.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// formatType writes t out the way it appears in source, so constants stay
+// constants.
+func formatType(t Type) string {
+	switch t := t.(type) {
+	case Void:
+		return "void"
+	case Text:
+		return "Text"
+	case Int:
+		return "Int"
+	case Uint:
+		return "Uint"
+	case Bool:
+		return "Bool"
+	case SizedInt:
+		return t.Name()
+	case Float:
+		return fmt.Sprintf("Float%d", t.Bits)
+	case Blob:
+		switch {
+		case t.CountConst.Name != "":
+			return "Blob(" + t.CountConst.Name + ")"
+		case t.Count > 0:
+			return fmt.Sprintf("Blob(%d)", t.Count)
+		}
+		return "Blob"
+	case List:
+		return "List(" + formatType(t.Type) + ")"
+	case Option:
+		return "Option(" + formatType(t.Type) + ")"
+	case Future:
+		return "Future(" + formatType(t.Type) + ")"
+	case Map:
+		return "Map(" + formatType(t.Key) + ", " + formatType(t.Value) + ")"
+	case DerivedType:
+		return t.FullTypeName()
+	}
+	return ""
+}
+
+func formatLiteral(l Literal) string {
+	if l.Kind == LiteralText {
+		return `"` + l.Raw + `"`
+	}
+	return l.Raw
+}
+
+// FmtOptions says which files to format, and what to do with the result.
+// With neither Write nor Check set, formatted files go to the writer.
+type FmtOptions struct {
+	Files []string // none means stdin
+	Write bool     // rewrite files in place
+	Check bool     // list files that aren't formatted, and fail if any
+}
+
+// formatFile formats one file's contents, and makes sure the output still
+// parses, so a bug here can't cost anyone their schema.
+func formatFile(dat []byte, nm string) ([]byte, error) {
+	r, err := Parse(dat, nm)
+	if err != nil {
+		return nil, err
+	}
+	ret := Format(r)
+	_, err = Parse(ret, nm)
+	if err != nil {
+		return nil, fmt.Errorf("%s: formatted output doesn't parse: %w", nm, err)
+	}
+	return ret, nil
+}
+
+// RunFmt formats the files in o, writing results or the names of unformatted
+// files to w.
+func RunFmt(o FmtOptions, w io.Writer) error {
+	if o.Write && o.Check {
+		return errors.New("can't use -w with --check")
+	}
+	if len(o.Files) == 0 {
+		if o.Write {
+			return errors.New("can't use -w without files")
+		}
+		dat, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		out, err := formatFile(dat, "<stdin>")
+		if err != nil {
+			return err
+		}
+		if o.Check {
+			if !bytes.Equal(dat, out) {
+				return errors.New("<stdin> is not formatted")
+			}
+			return nil
+		}
+		_, err = w.Write(out)
+		return err
+	}
+
+	var unformatted int
+	for _, f := range o.Files {
+		dat, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		out, err := formatFile(dat, f)
+		if err != nil {
+			return err
+		}
+		changed := !bytes.Equal(dat, out)
+		switch {
+		case o.Check:
+			if changed {
+				fmt.Fprintln(w, f)
+				unformatted++
+			}
+		case o.Write:
+			if !changed {
+				continue
+			}
+			st, err := os.Stat(f)
+			if err != nil {
+				return err
+			}
+			err = os.WriteFile(f, out, st.Mode().Perm())
+			if err != nil {
+				return err
+			}
+		default:
+			_, err = w.Write(out)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if unformatted > 0 {
+		return fmt.Errorf("%d file(s) not formatted", unformatted)
+	}
+	return nil
+}
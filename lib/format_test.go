@@ -0,0 +1,249 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// commentTexts lists the comments in src, in order.
+func commentTexts(t *testing.T, src []byte) []string {
+	t.Helper()
+	r, err := Parse(src, "t.snowp")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var ret []string
+	for _, c := range r.Comments {
+		ret = append(ret, c.Text)
+	}
+	return ret
+}
+
+// checkFormatted makes sure that out is in, formatted: formatting it again
+// leaves it alone, and every comment in in is still there, in order.
+func checkFormatted(t *testing.T, in, out []byte) {
+	t.Helper()
+	again, err := formatFile(out, "t.snowp")
+	if err != nil {
+		t.Fatalf("formatting again: %v", err)
+	}
+	if string(again) != string(out) {
+		t.Errorf("formatting isn't idempotent; first:\n%s\nsecond:\n%s", out, again)
+	}
+	if ic, oc := commentTexts(t, in), commentTexts(t, out); !slices.Equal(ic, oc) {
+		t.Errorf("comments changed:\n\t%q\nto:\n\t%q", ic, oc)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "spacing",
+			in: `@0x8a9f2b3c4d5e6f70;
+const   Max:Uint=   64;
+struct S{a@0:Text;b @1 : Map(Text,  List(Blob(Max)));}
+ts:import "./x" as x ;
+`,
+			want: `@0x8a9f2b3c4d5e6f70;
+const Max : Uint = 64;
+struct S {
+    a @0 : Text;
+    b @1 : Map(Text, List(Blob(Max)));
+}
+ts:import "./x" as x;
+`,
+		},
+		{
+			name: "blank lines",
+			in: `@0x8a9f2b3c4d5e6f70;
+
+
+
+struct S {
+
+
+    a @0 : Text;
+
+    b @1 : Text;
+}
+struct Empty {
+}
+`,
+			want: `@0x8a9f2b3c4d5e6f70;
+
+struct S {
+    a @0 : Text;
+
+    b @1 : Text;
+}
+struct Empty {}
+`,
+		},
+		{
+			name: "comments",
+			in: `// header
+
+@0x8a9f2b3c4d5e6f70; // trailing on id
+/* block
+   comment */
+const Max : Uint = 64; // max
+
+/** S is a thing.
+ *  with lines */
+struct S @0xaaaaaaaaaaaaaaaa { // after brace
+    a@0:Text;
+    // about b
+    b @1 : Text;
+    // dangling at end
+}
+struct E {
+   // only a comment
+}
+// end of file
+`,
+			want: `// header
+
+@0x8a9f2b3c4d5e6f70; // trailing on id
+/* block
+   comment */
+const Max : Uint = 64; // max
+
+/** S is a thing.
+ *  with lines */
+struct S @0xaaaaaaaaaaaaaaaa { // after brace
+    a @0 : Text;
+    // about b
+    b @1 : Text;
+    // dangling at end
+}
+struct E {
+    // only a comment
+}
+// end of file
+`,
+		},
+		{
+			name: "enums, variants and reserved",
+			in: `@0x8a9f2b3c4d5e6f70;
+enum Color { Red @0; Green @1; }
+struct S {
+    a @0 : Text;
+    reserved @1, old;   reserved @2;
+    d @3 : Text;
+}
+variant V switch (c : Color) {
+    case Red, Green @0 : List(Text);
+    reserved @1;
+    default : void;
+}
+`,
+			want: `@0x8a9f2b3c4d5e6f70;
+enum Color {
+    Red @0;
+    Green @1;
+}
+struct S {
+    a @0 : Text;
+    reserved @1, old, @2;
+    d @3 : Text;
+}
+variant V switch (c : Color) {
+    case Red, Green @0 : List(Text);
+    reserved @1;
+    default : void;
+}
+`,
+		},
+		{
+			name: "protocols",
+			in: `@0x8a9f2b3c4d5e6f70;
+protocol P errors Text @0xcccccccc {
+    /** doc for m */
+    m @0 (a @0 : Int, /* inline */ b @1 : Uint32) : MArg -> void;
+    n @1 () -> Float64;
+    o @2 (
+      x @0 : Int8, // x
+      y @1 : Bool
+      // trailing in params
+    ) -> Int;
+    p @3 (x @0 : Int) -> Int;
+}
+`,
+			want: `@0x8a9f2b3c4d5e6f70;
+protocol P errors Text @0xcccccccc {
+    /** doc for m */
+    m @0 (
+        a @0 : Int, /* inline */
+        b @1 : Uint32
+    ) : MArg;
+    n @1 () -> Float64;
+    o @2 (
+        x @0 : Int8, // x
+        y @1 : Bool
+        // trailing in params
+    ) -> Int;
+    p @3 (x @0 : Int) -> Int;
+}
+`,
+		},
+		{
+			name: "pending IDs",
+			in: `@new;
+struct S @ new { a @0 : Text; }
+protocol P errors Text {
+    m @0 ();
+}
+`,
+			want: `@new;
+struct S @new {
+    a @0 : Text;
+}
+protocol P errors Text {
+    m @0 ();
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatFile([]byte(tt.in), "t.snowp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			checkFormatted(t, []byte(tt.in), got)
+		})
+	}
+}
+
+// TestFormatExamples formats the example schemas that ship with snowpc.
+func TestFormatExamples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "snowpc", "*.snowp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no examples")
+	}
+	for _, f := range files {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			in, err := os.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := formatFile(in, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFormatted(t, in, out)
+		})
+	}
+}
@@ -1,37 +1,86 @@
 package lib
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 //go:generate go tool golang.org/x/tools/cmd/goyacc -o parser.go -p "snowp" parser.y
 
+// snowpLex adapts our Lexer to the goyacc-generated parser. It also carries all
+// of the state for one parse (the resulting root and any errors), so that
+// parses never share state and can safely run concurrently.
 type snowpLex struct {
-	l *Lexer
+	l     *Lexer
+	root  *Root
+	last  token // most recent token, where errors are reported
+	diags Diagnostics
+
+	// Once the lexer fails it stops producing tokens, so any syntax errors
+	// that follow are just noise.
+	lexFailed bool
 }
 
 func (s *snowpLex) Lex(yylval *snowpSymType) int {
 	tok := s.l.next()
+	s.last = tok
+	if tok.typ == TokenError {
+		s.report(tok.span, tok.val)
+		s.lexFailed = true
+		return int(TokenEOF)
+	}
 	yylval.rawval = tok.val
+	yylval.span = tok.span
 	return int(tok.typ)
 }
 
-var lexErr error
-var parseErr error
-var top *Root
+// state recovers the per-parse state from inside a grammar action, where
+// goyacc only gives us the lexer interface.
+func state(l snowpLexer) *snowpLex {
+	return l.(*snowpLex)
+}
+
+// setRoot is called once the whole file has been parsed; the last token we
+// saw was EOF, so the root spans through it.
+func (s *snowpLex) setRoot(r Root) {
+	r.Filename = s.l.filename
+	r.Span = Span{
+		Start: Pos{Line: 1, Column: 1, Offset: 0},
+		End:   s.last.span.End,
+	}
+	s.root = &r
+}
 
-type LexerError struct {
-	Line     int
-	Filename string
-	Msg      string
+func (s *snowpLex) report(sp Span, msg string) {
+	s.diags = append(s.diags, Diagnostic{
+		Filename: s.l.filename,
+		Pos:      sp.Start,
+		Msg:      msg,
+	})
 }
 
-func (e LexerError) Error() string {
-	return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Msg)
+// setParseErr records a semantic error found in a grammar action, located at
+// the given span of source.
+func (s *snowpLex) setParseErr(sp Span, err error) {
+	s.report(sp, err.Error())
 }
 
 func (s *snowpLex) Error(es string) {
-	lexErr = LexerError{Msg: es, Filename: s.l.filename, Line: s.l.lineno}
+	if s.lexFailed {
+		return
+	}
+	// Names that are keywords now, like Map or const, used to be fine, so
+	// say why they aren't.
+	if keywordType(s.last.val) != TokenIdentifier && strings.Contains(es, "TokenIdentifier") {
+		es += fmt.Sprintf("; %s is a keyword, so it can't be used as a name", s.last.val)
+	}
+	s.report(s.last.span, es)
 }
 
+// Parse parses one .snowp file. It keeps no package-level state, so it can be
+// called from many goroutines at once. The parser recovers from syntax errors
+// at statement, field, case and method boundaries, so on failure the returned
+// error is a Diagnostics list covering every problem found.
 func Parse(
 	indat []byte,
 	nm string,
@@ -41,14 +90,20 @@ func Parse(
 ) {
 	lexer := Lex(indat, nm)
 	l := &snowpLex{l: lexer}
-	snowpParse(l)
-	if parseErr != nil {
-		return nil, parseErr
+	snowpNewParser().Parse(l)
+
+	// If the parser bailed out early, the lexer goroutine might be blocked
+	// trying to send us more tokens; let it run to completion.
+	lexer.drain()
+
+	if len(l.diags) > 0 {
+		return nil, l.diags
 	}
-	if lexErr != nil {
-		return nil, lexErr
+	if l.root == nil {
+		return nil, fmt.Errorf("%s: parse failed", nm)
 	}
-	return top, nil
+	l.root.Comments = lexer.comments
+	return l.root, nil
 }
 
 func init() {
@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// parseResult sums up what Parse returned, so that results from different
+// calls can be compared.
+func parseResult(t *testing.T, src, nm string) string {
+	t.Helper()
+	r, err := Parse([]byte(src), nm)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	dat, err := MarshalAST(r)
+	if err != nil {
+		t.Error(err)
+	}
+	return string(dat)
+}
+
+// TestParseConcurrent parses good and bad files from many goroutines at
+// once, which the race detector checks, and makes sure that each call gets
+// the same result as it would on its own: no errors or statements leak from
+// one call into another.
+func TestParseConcurrent(t *testing.T) {
+	srcs := []string{
+		checkHeader + "struct S { a @0 : Uint; b @1 : List(Text); }",
+		checkHeader + "struct S { a @0 : ; }\nenum E { A @0; B; }",
+		checkHeader + "variant V switch (t : Uint) { case 1 @0 : Text; default : void; }",
+		checkHeader + "struct S { a @0 : Uint; ",
+		"@0x8a9f2b3c4d5e6f70;\nprotocol P errors Text @0xcccccccc { a @0 (x @0 : Uint) -> Text; }",
+		"@0x8a9f2b3c4d5e6f70;\nstruct S { a @0 : Uint; } $",
+	}
+	want := make([]string, len(srcs))
+	for i, src := range srcs {
+		want[i] = parseResult(t, src, fmt.Sprintf("f%d.snowp", i))
+	}
+
+	var wg sync.WaitGroup
+	for g := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range 20 {
+				i := (g + n) % len(srcs)
+				if got := parseResult(t, srcs[i], fmt.Sprintf("f%d.snowp", i)); got != want[i] {
+					t.Errorf("file %d:\n%s\nwant:\n%s", i, got, want[i])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestParseErrors makes sure that the parser recovers from syntax errors at
+// statement, field, case and method level, and reports each of them.
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string // after checkHeader, so line numbers start at 2
+		want []string
+	}{
+		{
+			name: "one per statement",
+			src: `struct S { a @0 : ; }
+struct T { b @0 : Uint; }
+enum E { A @0 B @1; }
+typedef U = ;`,
+			want: []string{
+				"2:19: syntax error: unexpected TokenSemicolon",
+				"4:15: syntax error: unexpected TokenIdentifier",
+				"5:13: syntax error: unexpected TokenSemicolon",
+			},
+		},
+		{
+			name: "fields",
+			src: `struct S {
+    a @0 : ;
+    b @1 : Uint;
+    c @ : Text;
+    d @3 : Text
+}`,
+			want: []string{
+				"3:12: syntax error: unexpected TokenSemicolon",
+				"5:9: syntax error: unexpected TokenColon",
+				"7:1: syntax error: unexpected TokenRBrace",
+			},
+		},
+		{
+			name: "cases",
+			src: `variant V switch (t : Uint) {
+    case 1 @0 : ;
+    case @1 : Text;
+    case 3 @2 : Uint;
+}`,
+			want: []string{
+				"3:17: syntax error: unexpected TokenSemicolon",
+				"4:10: syntax error: unexpected TokenAt",
+			},
+		},
+		{
+			name: "methods",
+			src: `protocol P errors Text @0xcccccccc {
+    a @0 (x @0 : ) -> Text;
+    b @1 () -> ;
+    c @2 ();
+}`,
+			want: []string{
+				"3:18: syntax error: unexpected TokenRParen",
+				"4:16: syntax error: unexpected TokenSemicolon",
+			},
+		},
+		{
+			name: "keywords as names",
+			src: `struct S {
+    const @0 : Uint;
+    Map @1 : Uint;
+}
+struct reserved { a @0 : Uint; }
+enum Float64 { A @0; }`,
+			want: []string{
+				"3:5: syntax error: unexpected TokenConst, expecting TokenRBrace or TokenReserved or " +
+					"TokenIdentifier; const is a keyword, so it can't be used as a name",
+				"4:5: syntax error: unexpected TokenMap, expecting TokenRBrace or TokenReserved or " +
+					"TokenIdentifier; Map is a keyword, so it can't be used as a name",
+				"6:8: syntax error: unexpected TokenReserved, expecting TokenIdentifier; " +
+					"reserved is a keyword, so it can't be used as a name",
+				"7:6: syntax error: unexpected TokenFloat64, expecting TokenIdentifier; " +
+					"Float64 is a keyword, so it can't be used as a name",
+			},
+		},
+		{
+			name: "lexer error",
+			src:  "struct S { a @0 : Uint; } $\nstruct T { b @0 : ; }",
+			want: []string{`2:27: unexpected character '$'`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(checkHeader+tt.src), "t.snowp")
+			diags, ok := err.(Diagnostics)
+			if !ok {
+				t.Fatalf("got %v; want diagnostics", err)
+			}
+			if len(diags) != len(tt.want) {
+				t.Fatalf("got %d diagnostics; want %d:\n%v", len(diags), len(tt.want), err)
+			}
+			for i, d := range diags {
+				got := d.Error()
+				if d.Filename != "t.snowp" || !strings.HasPrefix(got, "t.snowp:"+tt.want[i]) {
+					t.Errorf("got %s\nwant t.snowp:%s", got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestParseSpans checks where nodes start and end, down to the column and
+// byte offset. Columns count runes, so a tab is one column, and so is the é
+// in the comment, though it takes two bytes.
+func TestParseSpans(t *testing.T) {
+	src := "@0x8a9f2b3c4d5e6f70;\n" +
+		"struct S {\n" +
+		"\ta @0 : Uint;\n" +
+		"    /* é */ b @1 : T;\n" +
+		"}\n" +
+		"enum E { A @0; B @1; }\n" +
+		"variant V switch (t : Uint) {\n" +
+		"  case 1 @0 : Text;\n" +
+		"  default : void;\n" +
+		"}\n" +
+		"protocol P errors Text @0xcccccccc {\n" +
+		"\tget @0 (id @0 : Uint) -> S;\n" +
+		"}\n"
+	r, err := Parse([]byte(src), "t.snowp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := func(s Span) string {
+		return fmt.Sprintf("%v-%v (%d-%d)", s.Start, s.End, s.Start.Offset, s.End.Offset)
+	}
+	var got []string
+	add := func(what string, s Span) {
+		got = append(got, what+" "+span(s))
+	}
+	add("root", r.Span)
+	add("id", r.Id.Span)
+	for _, st := range r.Stmts {
+		switch st := st.(type) {
+		case Struct:
+			add("struct "+st.Ident.Name, st.Span)
+			for _, f := range st.Fields {
+				add("field "+f.Ident.Name, f.Span)
+				add("type of "+f.Ident.Name, f.Type.GetSpan())
+			}
+		case Enum:
+			add("enum "+st.Ident.Name, st.Span)
+			for _, v := range st.Values {
+				add("value "+v.Ident.Name, v.Span)
+			}
+		case Variant:
+			add("variant "+st.Ident.Name, st.Span)
+			for _, c := range st.Cases {
+				add("case", c.Span)
+			}
+		case Protocol:
+			add("protocol "+st.Ident.Name, st.Span)
+			for _, m := range st.Methods {
+				add("method "+m.Ident.Name, m.Span)
+				for _, p := range m.Params {
+					add("param "+p.Ident.Name, p.Span)
+				}
+				add("result of "+m.Ident.Name, m.ResType.GetSpan())
+			}
+		}
+	}
+	for _, c := range r.Comments {
+		add("comment", c.Span)
+	}
+	want := []string{
+		"root 1:1-14:1 (0-232)",
+		"id 1:1-1:20 (0-19)",
+		"struct S 2:1-5:2 (21-70)",
+		"field a 3:2-3:14 (33-45)",
+		"type of a 3:9-3:13 (40-44)",
+		"field b 4:13-4:22 (59-68)",
+		"type of b 4:20-4:21 (66-67)",
+		"enum E 6:1-6:23 (71-93)",
+		"value A 6:10-6:15 (80-85)",
+		"value B 6:16-6:21 (86-91)",
+		"variant V 7:1-10:2 (94-163)",
+		"case 8:3-8:20 (126-143)",
+		"case 9:3-9:18 (146-161)",
+		"protocol P 11:1-13:2 (164-231)",
+		"method get 12:2-12:29 (202-229)",
+		"param id 12:10-12:22 (210-222)",
+		"result of get 12:27-12:28 (227-228)",
+		"comment 4:5-4:12 (50-58)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n\t%s\nwant:\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+	if len(src) != 232 {
+		t.Errorf("source is %d bytes", len(src))
+	}
+}
@@ -1,7 +1,9 @@
 package lib
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -10,21 +12,30 @@ type Lexer struct {
 	filename string
 
 	start       int
+	startPos    Pos
 	pos         int
 	width       int
 	lineno      int
+	col         int // runes consumed so far on the current line
+	prevCol     int // col before the last nextRune(), for backup()
 	emitNewline bool
 
 	savePoint       int
 	savePointLineno int
+	savePointCol    int
 
 	tokens  chan token
 	chanEof bool
+
+	// comments are the // and /* */ comments we skipped over; the parser
+	// never sees them, but the formatter needs to put them back.
+	comments []Comment
 }
 
 type token struct {
-	typ TokenType
-	val string
+	typ  TokenType
+	val  string
+	span Span
 }
 
 type TokenType int
@@ -50,8 +61,9 @@ const (
 )
 
 type nextState struct {
-	t transitionType
-	f stateFn
+	t   transitionType
+	f   stateFn
+	msg string // for ttErr
 }
 
 type stateFn func(*Lexer) nextState
@@ -65,6 +77,7 @@ func newLexer(
 		filename: filename,
 		tokens:   make(chan token),
 		lineno:   1,
+		startPos: Pos{Line: 1, Column: 1, Offset: 0},
 	}
 }
 
@@ -78,8 +91,12 @@ func (l *Lexer) nextRune() rune {
 	l.width = w
 	l.pos += w
 	l.emitNewline = (r == '\n')
+	l.prevCol = l.col
 	if l.emitNewline {
 		l.lineno++
+		l.col = 0
+	} else {
+		l.col++
 	}
 	return r
 }
@@ -135,7 +152,7 @@ func initialState(l *Lexer) nextState {
 		case '"':
 			return nextState{t: ttPush, f: lexDQuotedString}
 		default:
-			return nextState{t: ttErr}
+			return l.errorf("unexpected character %q", r)
 		}
 	}
 }
@@ -148,7 +165,7 @@ func lexFrontSlash(l *Lexer) nextState {
 	case '*':
 		return lexCStyleComment(l)
 	}
-	return nextState{t: ttErr}
+	return l.errorf("unexpected character after '/'")
 }
 
 func lexDQuotedString(l *Lexer) nextState {
@@ -159,11 +176,11 @@ func lexDQuotedString(l *Lexer) nextState {
 		case RuneEOF:
 			return nextState{t: ttEof}
 		case '\n':
-			return nextState{t: ttErr}
+			return l.errorf("unterminated string")
 		case '"':
 			str := l.input[start : l.pos-1]
-			l.tokens <- token{typ: TokenDQoutedString, val: str}
-			l.start = l.pos
+			l.tokens <- token{typ: TokenDQoutedString, val: str, span: l.span()}
+			l.markStart()
 			return nextState{t: ttPop}
 		}
 	}
@@ -176,7 +193,7 @@ func lexCStyleComment(l *Lexer) nextState {
 	case '*':
 		return nextState{t: ttPush, f: func(l *Lexer) nextState { return lexDocString(l, true) }}
 	case RuneEOF:
-		return nextState{t: ttErr}
+		return l.errorf("unterminated comment")
 	default:
 		l.backup()
 		return nextState{t: ttPush, f: func(l *Lexer) nextState { return lexDocString(l, false) }}
@@ -200,9 +217,11 @@ func lexDocString(l *Lexer, emit bool) nextState {
 		}
 		if r == '/' {
 			if emit {
-				l.tokens <- token{typ: TokenDoc, val: l.input[start:loopPos]}
-				l.start = l.pos
+				l.tokens <- token{typ: TokenDoc, val: l.input[start:loopPos], span: l.span()}
+			} else {
+				l.addComment()
 			}
+			l.markStart()
 			return nextState{t: ttPop}
 		}
 	}
@@ -211,11 +230,25 @@ func lexCppStyleComment(l *Lexer) nextState {
 	for {
 		r := l.nextRune()
 		if r == RuneEOF || r == '\n' {
+			if r == '\n' {
+				l.backup()
+			}
+			l.addComment()
+			l.markStart()
 			return nextState{t: ttPop}
 		}
 	}
 }
 
+// addComment records the comment running from the start of the current
+// token up to the current position.
+func (l *Lexer) addComment() {
+	l.comments = append(l.comments, Comment{
+		Text: strings.TrimRight(l.txt(), " \t\r"),
+		Span: l.span(),
+	})
+}
+
 func lexDash(l *Lexer) nextState {
 	l.markSavePoint()
 	r := l.nextRune()
@@ -230,12 +263,32 @@ func lexDash(l *Lexer) nextState {
 		l.restoreSavePoint()
 		return lexNumber(l)
 	default:
-		return nextState{t: ttErr}
+		return l.errorf("unexpected character after '-'")
 	}
 }
 
 func (l *Lexer) eat() {
+	l.markStart()
+}
+
+// markStart begins a new token at the current position.
+func (l *Lexer) markStart() {
 	l.start = l.pos
+	l.startPos = l.curPos()
+}
+
+func (l *Lexer) curPos() Pos {
+	return Pos{Line: l.lineno, Column: l.col + 1, Offset: l.pos}
+}
+
+// span covers the text from the start of the current token up to the current
+// position.
+func (l *Lexer) span() Span {
+	return Span{Start: l.startPos, End: l.curPos()}
+}
+
+func (l *Lexer) errorf(format string, args ...any) nextState {
+	return nextState{t: ttErr, msg: fmt.Sprintf(format, args...)}
 }
 
 func Lex(
@@ -262,6 +315,14 @@ func (l *Lexer) next() token {
 	return ret
 }
 
+// drain discards any tokens the parser did not consume, so that the lexing
+// goroutine can finish.
+func (l *Lexer) drain() {
+	for !l.chanEof {
+		l.next()
+	}
+}
+
 func (l *Lexer) run() {
 	var stack []stateFn
 	for state := initialState; state != nil; {
@@ -280,7 +341,7 @@ func (l *Lexer) run() {
 		case ttSwitch:
 			state = ns.f
 		case ttErr:
-			l.emit(TokenError)
+			l.tokens <- token{typ: TokenError, val: ns.msg, span: l.span()}
 			state = nil
 		case ttEof:
 			l.emit(TokenEOF)
@@ -296,22 +357,25 @@ func (l *Lexer) backup() {
 	if l.emitNewline {
 		l.lineno--
 	}
+	l.col = l.prevCol
 	l.pos -= l.width
 }
 
 func (l *Lexer) markSavePoint() {
 	l.savePoint = l.pos
 	l.savePointLineno = l.lineno
+	l.savePointCol = l.col
 }
 
 func (l *Lexer) restoreSavePoint() {
 	l.pos = l.savePoint
 	l.lineno = l.savePointLineno
+	l.col = l.savePointCol
 }
 
 func (l *Lexer) emit(t TokenType) {
-	l.tokens <- token{typ: t, val: l.txt()}
-	l.start = l.pos
+	l.tokens <- token{typ: t, val: l.txt(), span: l.span()}
+	l.markStart()
 }
 
 func isLetter(r rune) bool {
@@ -328,15 +392,17 @@ func (l *Lexer) txt() string {
 
 var uint32rxx = regexp.MustCompile(`^0x[0-9a-fA-F]{8}$`)
 var uint64rxx = regexp.MustCompile(`^0x[0-9a-fA-F]{16}$`)
+var hexrxx = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
 var intrxx = regexp.MustCompile(`^-?[0-9]+$`)
 
 func lexNumber(l *Lexer) nextState {
 	for {
 		r, w := utf8.DecodeRuneInString(l.input[l.pos:])
-		if !isDigit(r) && r != '-' && r != 'x' && (r < 'a' || r > 'f') {
+		if !isDigit(r) && r != '-' && r != 'x' && (r < 'a' || r > 'f') && (r < 'A' || r > 'F') {
 			break
 		}
 		l.pos += w
+		l.col++
 	}
 	var typ TokenType
 	switch {
@@ -344,10 +410,12 @@ func lexNumber(l *Lexer) nextState {
 		typ = TokenUint64Val
 	case uint32rxx.MatchString(l.txt()):
 		typ = TokenUint32Val
+	case hexrxx.MatchString(l.txt()):
+		typ = TokenHexVal
 	case intrxx.MatchString(l.txt()):
 		typ = TokenIntVal
 	default:
-		return nextState{t: ttErr}
+		return l.errorf("malformed number %q", l.txt())
 	}
 	l.emit(typ)
 	return nextState{t: ttKeep}
@@ -355,12 +423,27 @@ func lexNumber(l *Lexer) nextState {
 
 func (l *Lexer) emitIdentifier(start int) {
 	txt := l.input[start:l.pos]
+	l.tokens <- token{typ: keywordType(txt), val: txt, span: l.span()}
+	l.markStart()
+}
+
+// keywordType returns the token type for txt, which is TokenIdentifier
+// unless it's a keyword. Keywords can't be used as names. const, reserved,
+// Map, and the sized types Uint8 through Float64 are newer than the rest, so
+// schemas that used them as names have to rename them.
+func keywordType(txt string) TokenType {
 	var typ TokenType
 	switch txt {
 	case "typedef":
 		typ = TokenTypedef
 	case "List":
 		typ = TokenList
+	case "const":
+		typ = TokenConst
+	case "reserved":
+		typ = TokenReserved
+	case "Map":
+		typ = TokenMap
 	case "Option":
 		typ = TokenOption
 	case "Blob":
@@ -373,6 +456,22 @@ func (l *Lexer) emitIdentifier(start int) {
 		typ = TokenUint
 	case "Int":
 		typ = TokenInt
+	case "Uint8":
+		typ = TokenUint8
+	case "Uint16":
+		typ = TokenUint16
+	case "Uint32":
+		typ = TokenUint32
+	case "Int8":
+		typ = TokenInt8
+	case "Int16":
+		typ = TokenInt16
+	case "Int32":
+		typ = TokenInt32
+	case "Float32":
+		typ = TokenFloat32
+	case "Float64":
+		typ = TokenFloat64
 	case "Bool":
 		typ = TokenBool
 	case "enum":
@@ -403,6 +502,8 @@ func (l *Lexer) emitIdentifier(start int) {
 		typ = TokenImport
 	case "go:import":
 		typ = TokenGoImport
+	case "go:package":
+		typ = TokenGoPackage
 	case "ts:import":
 		typ = TokenTypeScriptImport
 	case "as":
@@ -412,8 +513,18 @@ func (l *Lexer) emitIdentifier(start int) {
 	default:
 		typ = TokenIdentifier
 	}
-	l.tokens <- token{typ: typ, val: txt}
-	l.start = l.pos
+	return typ
+}
+
+// isIdentifier reports whether s would lex as a single identifier: a letter,
+// then letters, digits and underscores, and not a keyword.
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !isLetter(r) && (i == 0 || (!isDigit(r) && r != '_')) {
+			return false
+		}
+	}
+	return s != "" && keywordType(s) == TokenIdentifier
 }
 
 func lexIdentifier(l *Lexer) nextState {
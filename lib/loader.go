@@ -0,0 +1,274 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Package is a set of .snowp files that share a namespace, and that compile
+// into one package in the target language. Usually that's all of the files in
+// a directory, but a single file can be imported on its own too.
+type Package struct {
+	Dir   string
+	Roots []*Root
+	Scope *Scope
+
+	// GoPath is the Go import path of the code generated from this package,
+	// or empty if we couldn't work it out. See goImportPath.
+	GoPath string
+}
+
+// NewPackage makes a package out of roots that have already been parsed, and
+// declares all of their types in its scope.
+func NewPackage(dir string, roots []*Root) (*Package, []Diagnostic) {
+	p := &Package{
+		Dir:    dir,
+		Roots:  roots,
+		Scope:  NewScope(),
+		GoPath: goImportPath(dir),
+	}
+	var diags []Diagnostic
+	for _, r := range roots {
+		diags = append(diags, p.Scope.Declare(r)...)
+	}
+	return p, diags
+}
+
+// Loader finds, parses and resolves the packages named by generic `import`
+// statements. Paths are looked up relative to the importing file first, and
+// then in each directory of the search path. Each package is loaded only
+// once per Loader.
+type Loader struct {
+	searchPath []string
+	ext        string
+	pkgs       map[string]*Package
+	loading    map[string]bool
+
+	// outDirs maps the input directories of packages we're generating code
+	// for onto their output directories, which is where their Go code is.
+	outDirs map[string]string
+
+	// goPaths maps input directories onto Go import paths we were told
+	// about, which win over anything we'd work out.
+	goPaths map[string]string
+}
+
+func NewLoader(searchPath []string, ext string) *Loader {
+	return &Loader{
+		searchPath: searchPath,
+		ext:        ext,
+		pkgs:       make(map[string]*Package),
+		loading:    make(map[string]bool),
+		outDirs:    make(map[string]string),
+		goPaths:    make(map[string]string),
+	}
+}
+
+func absOr(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	return abs
+}
+
+// SetOutputDir says that code for the package in dir is generated into
+// outdir, rather than next to its .snowp files.
+func (l *Loader) SetOutputDir(dir, outdir string) {
+	l.outDirs[absOr(dir)] = outdir
+}
+
+// SetGoPath says that the Go code for the package in dir is imported with
+// goPath.
+func (l *Loader) SetGoPath(dir, goPath string) {
+	l.goPaths[absOr(dir)] = goPath
+}
+
+// goPath works out the Go import path for the package in dir.
+func (l *Loader) goPath(dir string) string {
+	if gp, ok := l.goPaths[absOr(dir)]; ok {
+		return gp
+	}
+	if od, ok := l.outDirs[absOr(dir)]; ok {
+		return goImportPath(od)
+	}
+	return goImportPath(dir)
+}
+
+// Add tells the loader about the package that's being compiled. key is the
+// package's directory, or its file for a single-file package. The package
+// stays marked as loading, since if one of its imports imports it back, that's
+// a cycle.
+func (l *Loader) Add(key string, p *Package) {
+	abs := absOr(key)
+	p.GoPath = l.goPath(p.Dir)
+	l.pkgs[abs] = p
+	l.loading[abs] = true
+}
+
+// Done says that the package added under key has been resolved, so other
+// packages can import it.
+func (l *Loader) Done(key string) {
+	delete(l.loading, absOr(key))
+}
+
+func (l *Loader) ImportPackage(from *Root, imp Import) (*Package, error) {
+	key, isDir, err := l.find(from, imp.Path)
+	if err != nil {
+		return nil, err
+	}
+	if l.loading[key] {
+		return nil, errors.New("import cycle")
+	}
+	if p := l.pkgs[key]; p != nil {
+		return p, nil
+	}
+	l.loading[key] = true
+	defer delete(l.loading, key)
+
+	p, err := l.load(key, isDir)
+	if err != nil {
+		return nil, err
+	}
+	l.pkgs[key] = p
+	return p, nil
+}
+
+// find returns the absolute path of the directory or file that an import
+// path refers to.
+func (l *Loader) find(from *Root, p string) (string, bool, error) {
+	var dirs []string
+	if filepath.IsAbs(p) {
+		dirs = []string{""}
+	} else {
+		from := from.Filename
+		if from == "<stdin>" {
+			from = ""
+		}
+		dirs = append([]string{filepath.Dir(from)}, l.searchPath...)
+	}
+	for _, d := range dirs {
+		cand := filepath.Join(d, filepath.FromSlash(p))
+		for _, c := range []string{cand, cand + l.ext} {
+			st, err := os.Stat(c)
+			if err != nil {
+				continue
+			}
+			abs, err := filepath.Abs(c)
+			if err != nil {
+				return "", false, err
+			}
+			return abs, st.IsDir(), nil
+		}
+	}
+	return "", false, fmt.Errorf("%w (searched %s)", errImportNotFound, strings.Join(dirs, ", "))
+}
+
+var errImportNotFound = errors.New("not found")
+
+func (l *Loader) load(key string, isDir bool) (*Package, error) {
+	dir := key
+	files := []string{key}
+	if isDir {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		files = nil
+		for _, ent := range ents {
+			if !ent.IsDir() && filepath.Ext(ent.Name()) == l.ext {
+				files = append(files, filepath.Join(dir, ent.Name()))
+			}
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no %s files in %s", l.ext, dir)
+		}
+		sort.Strings(files)
+	} else {
+		dir = filepath.Dir(key)
+	}
+
+	var diags Diagnostics
+	var roots []*Root
+	for _, f := range files {
+		dat, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		r, err := Parse(dat, f)
+		var ds Diagnostics
+		switch {
+		case errors.As(err, &ds):
+			diags = append(diags, ds...)
+		case err != nil:
+			return nil, err
+		default:
+			roots = append(roots, r)
+		}
+	}
+	if len(diags) > 0 {
+		return nil, diags
+	}
+
+	p, ds := NewPackage(dir, roots)
+	p.GoPath = l.goPath(dir)
+	diags = append(diags, ds...)
+	for _, r := range roots {
+		diags = append(diags, Resolve(r, p.Scope, l)...)
+	}
+	if len(diags) > 0 {
+		sortDiagnostics(diags)
+		return nil, diags
+	}
+	return p, nil
+}
+
+// goImportPath works out the Go import path for dir by finding the Go module
+// that it's in. It assumes that the generated .go files sit alongside the
+// .snowp files; if they don't, use a go:import to say where they are.
+func goImportPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for d := abs; ; d = filepath.Dir(d) {
+		dat, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			mod := goModulePath(dat)
+			if mod == "" {
+				return ""
+			}
+			rel, err := filepath.Rel(d, abs)
+			if err != nil {
+				return ""
+			}
+			return path.Join(mod, filepath.ToSlash(rel))
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+func goModulePath(gomod []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(gomod))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		rest, found := strings.CutPrefix(line, "module")
+		if !found || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+			continue
+		}
+		if i := strings.Index(rest, "//"); i >= 0 {
+			rest = rest[:i]
+		}
+		return strings.Trim(strings.TrimSpace(rest), `"`)
+	}
+	return ""
+}
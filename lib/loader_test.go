@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// loaderTree has a package in top that imports one in lib, over files that
+// each test adds to or changes.
+var loaderTree = map[string]string{
+	"top/a.snowp": `@0x8a9f2b3c4d5e6f70;
+import "../lib" as lib;
+struct A {
+    b @0 : lib.B;
+    a2 @1 : A2;
+}`,
+	"top/a2.snowp": `@0x8a9f2b3c4d5e6f71;
+struct A2 { k @0 : Blob(8); }`,
+	"lib/b.snowp": `@0x8a9f2b3c4d5e6f72;
+struct B { x @0 : Uint; }`,
+}
+
+// checkTree checks the package in dir/top for Go, with a loader that setup
+// can adjust, and returns what it found, with paths relative to dir.
+func checkTree(t *testing.T, dir string, setup func(l *Loader)) []string {
+	t.Helper()
+	top := filepath.Join(dir, "top")
+	files, err := filepath.Glob(filepath.Join(top, "*.snowp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	var roots []*Root
+	for _, f := range files {
+		dat, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := Parse(dat, f)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		roots = append(roots, r)
+	}
+	l := NewLoader(nil, ".snowp")
+	if setup != nil {
+		setup(l)
+	}
+	pkg, diags := NewPackage(top, roots)
+	l.Add(top, pkg)
+	for _, r := range roots {
+		diags = append(diags, Resolve(r, pkg.Scope, l)...)
+		diags = append(diags, validate(r, false)...)
+		diags = append(diags, checkTargetImports(r, LangGo)...)
+	}
+	l.Done(top)
+	sortDiagnostics(diags)
+
+	var ret []string
+	for _, d := range diags {
+		ret = append(ret, strings.ReplaceAll(d.Error(), dir+string(filepath.Separator), ""))
+	}
+	return ret
+}
+
+func TestLoader(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string // on top of loaderTree; "" removes a file
+		setup func(dir string, l *Loader)
+		want  []string
+	}{
+		{
+			name: "Go path from go.mod",
+			files: map[string]string{
+				"go.mod": "module example.com/m\n",
+			},
+		},
+		{
+			name: "Go path we were told",
+			setup: func(dir string, l *Loader) {
+				l.SetGoPath(filepath.Join(dir, "lib"), "example.com/lib")
+			},
+		},
+		{
+			name: "no Go path",
+			want: []string{
+				"top/a.snowp:2:1: cannot work out the Go import path for \"../lib\"; " +
+					"add `go:import \"...\" as lib;`",
+			},
+		},
+		{
+			name: "go:import instead of a Go path",
+			files: map[string]string{
+				"top/a.snowp": `@0x8a9f2b3c4d5e6f70;
+import "../lib" as lib;
+go:import "example.com/lib" as lib;
+struct A { b @0 : lib.B; }`,
+			},
+		},
+		{
+			name:  "missing from another file",
+			files: map[string]string{"go.mod": "module example.com/m\n", "top/a2.snowp": ""},
+			want:  []string{`top/a.snowp:5:13: undefined type "A2"`},
+		},
+		{
+			name: "missing from another package",
+			files: map[string]string{
+				"go.mod":      "module example.com/m\n",
+				"lib/b.snowp": "@0x8a9f2b3c4d5e6f72;\nstruct C { x @0 : Uint; }",
+			},
+			want: []string{`top/a.snowp:4:12: undefined type "lib.B"`},
+		},
+		{
+			name: "errors in another package",
+			files: map[string]string{
+				"go.mod":      "module example.com/m\n",
+				"lib/b.snowp": "@0x8a9f2b3c4d5e6f72;\nstruct B { x @0 : C; }",
+			},
+			want: []string{
+				`lib/b.snowp:2:19: undefined type "C"`,
+				`top/a.snowp:2:1: cannot import "../lib" due to errors in imported files`,
+			},
+		},
+		{
+			name: "a single file",
+			files: map[string]string{
+				"go.mod": "module example.com/m\n",
+				"top/a.snowp": `@0x8a9f2b3c4d5e6f70;
+import "../lib/b" as lib;
+struct A { b @0 : lib.B; }`,
+			},
+		},
+		{
+			name: "from the search path",
+			files: map[string]string{
+				"go.mod": "module example.com/m\n",
+				"top/a.snowp": `@0x8a9f2b3c4d5e6f70;
+import "lib" as lib;
+struct A { b @0 : lib.B; }`,
+			},
+			setup: func(dir string, l *Loader) {
+				l.searchPath = []string{dir}
+			},
+		},
+		{
+			name: "not found",
+			files: map[string]string{
+				"top/a.snowp": `@0x8a9f2b3c4d5e6f70;
+import "example.com/pickle" as pickle;
+struct A { j @0 : pickle.Jar; }`,
+			},
+			want: []string{
+				`top/a.snowp:2:1: cannot import "example.com/pickle": not found (searched top); ` +
+					"if it isn't a .snowp package, add a `go:import` or `ts:import` as pickle",
+			},
+		},
+		{
+			name: "not found, but there's a go:import",
+			files: map[string]string{
+				"top/a.snowp": `@0x8a9f2b3c4d5e6f70;
+import "example.com/pickle" as pickle;
+go:import "example.com/pickle" as pickle;
+struct A { j @0 : pickle.Jar; }`,
+			},
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"go.mod":      "module example.com/m\n",
+				"lib/b.snowp": "@0x8a9f2b3c4d5e6f72;\nimport \"../top\" as top;\nstruct B { x @0 : Uint; }",
+			},
+			want: []string{
+				`lib/b.snowp:2:1: cannot import "../top": import cycle`,
+				`top/a.snowp:2:1: cannot import "../lib" due to errors in imported files`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := make(map[string]string)
+			for nm, dat := range loaderTree {
+				files[nm] = dat
+			}
+			for nm, dat := range tt.files {
+				files[nm] = dat
+				if dat == "" {
+					delete(files, nm)
+				}
+			}
+			writeFiles(t, dir, files)
+			got := checkTree(t, dir, func(l *Loader) {
+				if tt.setup != nil {
+					tt.setup(dir, l)
+				}
+			})
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n\t%s\nwant:\n\t%s",
+					strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
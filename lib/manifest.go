@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestName is the file, at the top of the output directory, in which
+// Runner records what it built from what.
+const manifestName = ".snowpc-manifest.json"
+
+// Manifest records, for each input file, the hashes of everything its output
+// was generated from. A package whose files and dependencies all still match
+// doesn't need compiling again. Paths are relative to the manifest's
+// directory, so the output tree can be moved around with it.
+type Manifest struct {
+	Version string                   `json:"version"`
+	Options string                   `json:"options"`
+	Files   map[string]ManifestEntry `json:"files"`
+
+	dir     string            // where the manifest lives
+	options string            // what Options should be, for this run
+	hashes  map[string]string // file hashes we know, by absolute path
+	seen    map[string]bool   // the input files in this run
+}
+
+type ManifestEntry struct {
+	Hash       string            `json:"hash"`
+	Output     string            `json:"output"`
+	OutputHash string            `json:"outputHash"`
+	Deps       map[string]string `json:"deps,omitempty"` // other input files it used, and their hashes
+}
+
+// optionsKey sums up the options that affect what gets generated, or from
+// which files. If they change, everything is built again.
+func optionsKey(o *Options) string {
+	var goPaths []string
+	for alias, gp := range o.goImports {
+		goPaths = append(goPaths, alias+"="+gp)
+	}
+	sort.Strings(goPaths)
+	return fmt.Sprintf("lang=%s ext=%s pkg=%s from-ast=%t import-path=%s input-glob=%s include=%s exclude=%s go-import-paths=%s",
+		o.lang.OutExt(), o.ext, o.pkg, o.fromAST,
+		strings.Join(o.importPath, ","),
+		o.inputGlob,
+		strings.Join(o.include, ","),
+		strings.Join(o.exclude, ","),
+		strings.Join(goPaths, ","))
+}
+
+// loadManifest reads the manifest from the output directory. A missing or
+// unreadable one is the same as an empty one; it just means a full build.
+// With --force, whatever's there is ignored, but a new one is still written.
+func loadManifest(o *Options) *Manifest {
+	m := &Manifest{
+		dir:     o.outdir,
+		options: optionsKey(o),
+		hashes:  make(map[string]string),
+		seen:    make(map[string]bool),
+	}
+	dat, err := os.ReadFile(m.path())
+	if err == nil && !o.force {
+		_ = json.Unmarshal(dat, m)
+	}
+	if m.Version != version || m.Options != m.options || m.Files == nil {
+		m.Files = make(map[string]ManifestEntry)
+	}
+	m.Version = version
+	m.Options = m.options
+	return m
+}
+
+func (m *Manifest) path() string {
+	return filepath.Join(m.dir, manifestName)
+}
+
+// rel turns p into a key for the manifest.
+func (m *Manifest) rel(p string) string {
+	ret, err := filepath.Rel(absOr(m.dir), absOr(p))
+	if err != nil {
+		return filepath.ToSlash(absOr(p))
+	}
+	return filepath.ToSlash(ret)
+}
+
+// abs undoes rel.
+func (m *Manifest) abs(k string) string {
+	p := filepath.FromSlash(k)
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(absOr(m.dir), p)
+}
+
+func hashBytes(dat []byte) string {
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:])
+}
+
+// hash returns the hash of the file at p, or "" if it can't be read.
+func (m *Manifest) hash(p string) string {
+	abs := absOr(p)
+	if h, ok := m.hashes[abs]; ok {
+		return h
+	}
+	var h string
+	dat, err := os.ReadFile(abs)
+	if err == nil {
+		h = hashBytes(dat)
+	}
+	m.hashes[abs] = h
+	return h
+}
+
+// see notes that pf's files are still inputs, so their entries are kept,
+// whether or not they're built this time.
+func (m *Manifest) see(pf *PackageFiles) {
+	for _, fp := range pf.files {
+		m.seen[m.rel(fp.infile.name)] = true
+	}
+}
+
+// fresh reports whether everything that pf's output was made from, and the
+// output itself, is as it was when the manifest was written. A package is
+// built as a whole, or not at all, since its files share a scope.
+func (m *Manifest) fresh(pf *PackageFiles) bool {
+	for _, fp := range pf.files {
+		if !m.freshFile(&fp) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Manifest) freshFile(fp *FilePair) bool {
+	e, ok := m.Files[m.rel(fp.infile.name)]
+	if !ok || e.Output != m.rel(fp.outfile.name) {
+		return false
+	}
+	if m.hash(fp.infile.name) != e.Hash || m.hash(fp.outfile.name) != e.OutputHash {
+		return false
+	}
+	for dep, h := range e.Deps {
+		if m.hash(m.abs(dep)) != h {
+			return false
+		}
+	}
+	return true
+}
+
+// record notes what md was just built from. deps are the files of its own
+// package and of every package it imports, directly or not.
+func (m *Manifest) record(md *Metadata, deps []string) {
+	k := m.rel(md.infile.name)
+	e := ManifestEntry{
+		Hash:       hashBytes(md.input),
+		Output:     m.rel(md.outfile.name),
+		OutputHash: hashBytes(md.output),
+		Deps:       make(map[string]string),
+	}
+	for _, d := range deps {
+		if dk := m.rel(d); dk != k {
+			e.Deps[dk] = m.hash(d)
+		}
+	}
+	m.Files[k] = e
+}
+
+// noteInput makes sure the hash we record for an input file is of what we
+// read and compiled, even if it's changed on disk since.
+func (m *Manifest) noteInput(md *Metadata) {
+	m.hashes[absOr(md.infile.name)] = hashBytes(md.input)
+}
+
+// save writes the manifest out, leaving out files that weren't part of this
+// run.
+func (m *Manifest) save() error {
+	for k := range m.Files {
+		if !m.seen[k] {
+			delete(m.Files, k)
+		}
+	}
+	dat, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	out := newOutfile(m.path())
+	return out.WriteFile(append(dat, '\n'))
+}
+
+// packageDeps lists the files of p, and of every package that it imports,
+// directly or not.
+func packageDeps(p *Package) []string {
+	var ret []string
+	seen := make(map[*Package]bool)
+	var walk func(p *Package)
+	walk = func(p *Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		for _, r := range p.Roots {
+			ret = append(ret, r.Filename)
+			for _, s := range r.Stmts {
+				if i, ok := s.(Import); ok && i.Pkg != nil {
+					walk(i.Pkg)
+				}
+			}
+		}
+	}
+	walk(p)
+	return ret
+}
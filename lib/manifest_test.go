@@ -0,0 +1,221 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// manifestTree is a small input tree: top imports lib, and other stands
+// alone.
+var manifestTree = map[string]string{
+	"in/top/a.snowp": `@0x8a9f2b3c4d5e6f70;
+import "../lib" as lib;
+struct A { b @0 : lib.B; }`,
+	"in/top/a2.snowp": `@0x8a9f2b3c4d5e6f71;
+struct A2 { a @0 : A; }`,
+	"in/lib/b.snowp": `@0x8a9f2b3c4d5e6f72;
+struct B { x @0 : Uint; }`,
+	"in/other/c.snowp": `@0x8a9f2b3c4d5e6f73;
+struct C { x @0 : Text; }`,
+}
+
+func manifestOptions(dir string) *Options {
+	return &Options{
+		langRaw:   "go",
+		indir:     filepath.Join(dir, "in"),
+		outdir:    filepath.Join(dir, "out"),
+		pkg:       "top",
+		ext:       ".snowp",
+		goImports: map[string]string{"lib": "example.com/out/lib"},
+	}
+}
+
+// buildTree builds the tree in dir with o, as snowpc gen would.
+func buildTree(t *testing.T, o *Options) {
+	t.Helper()
+	if err := o.check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRunner(o).Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rebuilt lists the input files, relative to dir, that a run with o would
+// compile rather than skip as up to date.
+func rebuilt(t *testing.T, dir string, o *Options) []string {
+	t.Helper()
+	if err := o.check(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRunner(o)
+	r.manifest = loadManifest(o)
+	mds, err := r.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret []string
+	for _, md := range mds {
+		rel, err := filepath.Rel(dir, md.infile.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, filepath.ToSlash(rel))
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+func TestManifestFresh(t *testing.T) {
+	all := []string{"in/lib/b.snowp", "in/other/c.snowp", "in/top/a.snowp", "in/top/a2.snowp"}
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dir string, o *Options)
+		want   []string
+	}{
+		{
+			name:   "nothing changed",
+			change: func(t *testing.T, dir string, o *Options) {},
+		},
+		{
+			name: "touched but the same",
+			change: func(t *testing.T, dir string, o *Options) {
+				writeFiles(t, dir, map[string]string{"in/other/c.snowp": manifestTree["in/other/c.snowp"]})
+			},
+		},
+		{
+			name: "one file of a package",
+			change: func(t *testing.T, dir string, o *Options) {
+				writeFiles(t, dir, map[string]string{"in/top/a2.snowp": manifestTree["in/top/a2.snowp"] + "\n// more"})
+			},
+			want: []string{"in/top/a.snowp", "in/top/a2.snowp"},
+		},
+		{
+			name: "an imported package",
+			change: func(t *testing.T, dir string, o *Options) {
+				writeFiles(t, dir, map[string]string{"in/lib/b.snowp": manifestTree["in/lib/b.snowp"] + "\n// more"})
+			},
+			want: []string{"in/lib/b.snowp", "in/top/a.snowp", "in/top/a2.snowp"},
+		},
+		{
+			name: "a new file",
+			change: func(t *testing.T, dir string, o *Options) {
+				writeFiles(t, dir, map[string]string{"in/other/d.snowp": "@0x8a9f2b3c4d5e6f74;\nstruct D { c @0 : C; }"})
+			},
+			want: []string{"in/other/c.snowp", "in/other/d.snowp"},
+		},
+		{
+			name: "output edited",
+			change: func(t *testing.T, dir string, o *Options) {
+				writeFiles(t, dir, map[string]string{"out/other/c.go": "package other\n"})
+			},
+			want: []string{"in/other/c.snowp"},
+		},
+		{
+			name: "output removed",
+			change: func(t *testing.T, dir string, o *Options) {
+				if err := os.Remove(filepath.Join(dir, "out", "lib", "b.go")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"in/lib/b.snowp"},
+		},
+		{
+			name: "options changed",
+			change: func(t *testing.T, dir string, o *Options) {
+				o.pkg = "top2"
+			},
+			want: all,
+		},
+		{
+			name: "forced",
+			change: func(t *testing.T, dir string, o *Options) {
+				o.force = true
+			},
+			want: all,
+		},
+		{
+			name: "manifest garbled",
+			change: func(t *testing.T, dir string, o *Options) {
+				writeFiles(t, dir, map[string]string{"out/" + manifestName: "{"})
+			},
+			want: all,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, manifestTree)
+			if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			buildTree(t, manifestOptions(dir))
+			if got := rebuilt(t, dir, manifestOptions(dir)); len(got) > 0 {
+				t.Fatalf("right after a build, rebuilt %v", got)
+			}
+
+			o := manifestOptions(dir)
+			tt.change(t, dir, o)
+			got := rebuilt(t, dir, o)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("rebuilt %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// readManifest loads the manifest that a build of dir left behind.
+func readManifest(t *testing.T, dir string) *Manifest {
+	t.Helper()
+	o := manifestOptions(dir)
+	if err := o.check(); err != nil {
+		t.Fatal(err)
+	}
+	return loadManifest(o)
+}
+
+// TestManifestSave checks that a run records what it built, and forgets
+// files that are gone.
+func TestManifestSave(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, manifestTree)
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	buildTree(t, manifestOptions(dir))
+
+	m := readManifest(t, dir)
+	var keys []string
+	for k := range m.Files {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	want := []string{"../in/lib/b.snowp", "../in/other/c.snowp", "../in/top/a.snowp", "../in/top/a2.snowp"}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("manifest has %v; want %v", keys, want)
+	}
+	e := m.Files["../in/top/a.snowp"]
+	if e.Output != "top/a.go" {
+		t.Errorf("output of a.snowp is %q; want top/a.go", e.Output)
+	}
+	if _, found := e.Deps["../in/lib/b.snowp"]; !found {
+		t.Errorf("a.snowp doesn't depend on b.snowp: %v", e.Deps)
+	}
+	if _, found := e.Deps["../in/top/a2.snowp"]; !found {
+		t.Errorf("a.snowp doesn't depend on a2.snowp: %v", e.Deps)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "in", "other", "c.snowp")); err != nil {
+		t.Fatal(err)
+	}
+	buildTree(t, manifestOptions(dir))
+	m = readManifest(t, dir)
+	if _, found := m.Files["../in/other/c.snowp"]; found {
+		t.Errorf("manifest still has c.snowp after it was removed")
+	}
+	if len(m.Files) != 3 {
+		t.Errorf("manifest has %d files; want 3", len(m.Files))
+	}
+}
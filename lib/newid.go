@@ -0,0 +1,239 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var errNewIDArgs = errors.New("usage: snowpc newid [--bits 64|32] [-n COUNT], or snowpc newid --assign FILE...")
+
+// NewUniqueID makes up a random unique ID of the given size, written the
+// way the lexer wants it: 64-bit IDs for files and types, and 32-bit ones
+// for protocols.
+func NewUniqueID(bits int) (string, error) {
+	var buf [8]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return "", err
+	}
+	v := binary.BigEndian.Uint64(buf[:])
+	switch bits {
+	case 64:
+		return fmt.Sprintf("0x%016x", v), nil
+	case 32:
+		return fmt.Sprintf("0x%08x", uint32(v)), nil
+	}
+	return "", fmt.Errorf("can't make a %d-bit unique ID; only 64 and 32 bits", bits)
+}
+
+type NewIDOptions struct {
+	Bits   int
+	Count  int
+	Assign bool
+	Files  []string
+}
+
+// RunNewID prints fresh IDs, or with Assign, fills them in.
+func RunNewID(o NewIDOptions, w io.Writer) error {
+	if !o.Assign {
+		if o.Count < 1 {
+			return fmt.Errorf("can't print %d IDs; the count has to be at least 1", o.Count)
+		}
+		for range o.Count {
+			id, err := NewUniqueID(o.Bits)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, id)
+		}
+		return nil
+	}
+
+	// IDs only have to be unique among those that get registered together,
+	// but the more files we look at, the less chance of a clash, so avoid
+	// any ID already used in any of the files.
+	a := &idAssigner{used: make(map[string]string)}
+	var files []*idFile
+	var dups Diagnostics
+	for _, f := range o.Files {
+		dat, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		r, err := Parse(dat, f)
+		if err != nil {
+			return err
+		}
+		dups = append(dups, a.note(r)...)
+		files = append(files, &idFile{name: f, src: dat, root: r})
+	}
+	// A clash is most likely an ID that was copied along with the code
+	// around it, and only the user knows which copy should keep it, so
+	// don't write anything.
+	if len(dups) > 0 {
+		return dups
+	}
+	for _, f := range files {
+		err := a.assign(f, w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type idAssigner struct {
+	used map[string]string // what each ID was used for, and where
+}
+
+type idFile struct {
+	name string
+	src  []byte
+	root *Root
+}
+
+// idEdit replaces src[start:end] with text.
+type idEdit struct {
+	start, end int
+	text       string
+}
+
+// note records the IDs that r already has, and reports any that were used
+// before, in r or in an earlier file.
+func (a *idAssigner) note(r *Root) []Diagnostic {
+	var diags []Diagnostic
+	note := func(what string, u UniqueID) {
+		if !u.IsSet() {
+			return
+		}
+		key := strings.ToLower(u.Val)
+		if prev, ok := a.used[key]; ok {
+			diags = append(diags, Diagnostic{
+				Filename: r.Filename,
+				Pos:      u.Span.Start,
+				Msg: fmt.Sprintf("unique ID %s of %s is already used by %s; "+
+					"change one of them to @new", u.Val, what, prev),
+			})
+			return
+		}
+		a.used[key] = fmt.Sprintf("%s at %s:%s", what, r.Filename, u.Span.Start)
+	}
+	note("file", r.Id)
+	for _, s := range r.Stmts {
+		if u, ok := statementUniqueID(s); ok {
+			note(statementDescription(s), u)
+		}
+	}
+	return diags
+}
+
+func (a *idAssigner) fresh(bits int) (string, error) {
+	for {
+		id, err := NewUniqueID(bits)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := a.used[id]; !ok {
+			a.used[id] = "a new ID"
+			return id, nil
+		}
+	}
+}
+
+// assign fills in every `@new` in f, and gives an ID to every protocol that
+// doesn't have one, and writes f back out. The rest of the file is left as
+// it was, byte for byte.
+func (a *idAssigner) assign(f *idFile, w io.Writer) error {
+	var edits []idEdit
+	add := func(what string, bits int, start, end int) error {
+		id, err := a.fresh(bits)
+		if err != nil {
+			return err
+		}
+		text := "@" + id
+		if start == end {
+			text = " " + text
+		}
+		edits = append(edits, idEdit{start: start, end: end, text: text})
+		fmt.Fprintf(w, "%s:%d: %s @%s\n", f.name, lineAt(f.src, start), what, id)
+		return nil
+	}
+
+	if u := f.root.Id; u.Pending {
+		err := add("file", 64, u.Span.Start.Offset, u.Span.End.Offset)
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range f.root.Stmts {
+		u, ok := statementUniqueID(s)
+		if !ok {
+			continue
+		}
+		bits := 64
+		p, isProto := s.(Protocol)
+		if isProto {
+			bits = 32
+		}
+		var err error
+		switch {
+		case u.Pending:
+			err = add(statementDescription(s), bits, u.Span.Start.Offset, u.Span.End.Offset)
+		case isProto && !u.IsSet():
+			off, found := lbraceAfter(f.src, f.name, p.Ident.Span.End.Offset)
+			if !found {
+				return fmt.Errorf("%s: can't find the start of protocol %s", f.name, p.Ident.Name)
+			}
+			err = add(statementDescription(s), bits, off, off)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+
+	// Go from the end, so that earlier offsets stay good.
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := f.src
+	for _, e := range edits {
+		out = append(out[:e.start:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+	if _, err := Parse(out, f.name); err != nil {
+		return fmt.Errorf("%s: assigning IDs broke the file; please report this: %w", f.name, err)
+	}
+	of := newOutfile(f.name)
+	return of.WriteFile(out)
+}
+
+// lbraceAfter finds the first `{` at or after off, and returns the offset
+// just past the token before it, which is where a protocol's ID goes.
+func lbraceAfter(src []byte, nm string, off int) (int, bool) {
+	l := Lex(src, nm)
+	defer l.drain()
+	prevEnd := off
+	for {
+		t := l.next()
+		switch {
+		case t.typ == TokenEOF || t.typ == TokenError:
+			return 0, false
+		case t.span.Start.Offset < off:
+		case t.typ == TokenLBrace:
+			return prevEnd, true
+		default:
+			prevEnd = t.span.End.Offset
+		}
+	}
+}
+
+// lineAt returns the line number of offset off in src.
+func lineAt(src []byte, off int) int {
+	return 1 + strings.Count(string(src[:off]), "\n")
+}
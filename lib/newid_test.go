@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunNewID(t *testing.T) {
+	tests := []struct {
+		name    string
+		o       NewIDOptions
+		want    string // each line of output, as a regexp
+		wantErr string
+	}{
+		{name: "64 bits", o: NewIDOptions{Bits: 64, Count: 1}, want: `0x[0-9a-f]{16}`},
+		{name: "32 bits", o: NewIDOptions{Bits: 32, Count: 3}, want: `0x[0-9a-f]{8}`},
+		{name: "no count", o: NewIDOptions{Bits: 64}, wantErr: "can't print 0 IDs; the count has to be at least 1"},
+		{name: "negative count", o: NewIDOptions{Bits: 64, Count: -2}, wantErr: "can't print -2 IDs"},
+		{name: "bad size", o: NewIDOptions{Bits: 16, Count: 1}, wantErr: "can't make a 16-bit unique ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := RunNewID(tt.o, &out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v; want %q", err, tt.wantErr)
+				}
+				if out.Len() > 0 {
+					t.Errorf("printed %q", out.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != tt.o.Count {
+				t.Fatalf("got %d IDs; want %d", len(lines), tt.o.Count)
+			}
+			re := regexp.MustCompile(`^` + tt.want + `$`)
+			for _, l := range lines {
+				if !re.MatchString(l) {
+					t.Errorf("%q doesn't match %s", l, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// hexIDs matches the IDs that --assign fills in.
+var hexIDs = regexp.MustCompile(`@0x[0-9a-f]{8}([0-9a-f]{8})?\b`)
+
+func TestRunNewIDAssign(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    map[string]string // files afterwards, with new IDs as @ID32 or @ID64
+		log     []string          // what's printed, with the same substitution
+		wantErr string
+	}{
+		{
+			name: "@new",
+			files: map[string]string{"a.snowp": `@new;
+// Comments and spacing stay as they were.
+struct S @new {
+    a @0 : Uint;   // a
+}
+struct T @0x8a9f2b3c4d5e6f71 { a @0 : Uint; }
+variant V switch (t : Uint) @new { default : void; }
+`},
+			want: map[string]string{"a.snowp": `@ID64;
+// Comments and spacing stay as they were.
+struct S @ID64 {
+    a @0 : Uint;   // a
+}
+struct T @0x8a9f2b3c4d5e6f71 { a @0 : Uint; }
+variant V switch (t : Uint) @ID64 { default : void; }
+`},
+			log: []string{
+				"a.snowp:1: file @ID64",
+				"a.snowp:3: struct S @ID64",
+				"a.snowp:7: variant V @ID64",
+			},
+		},
+		{
+			name: "protocols with no ID",
+			files: map[string]string{"a.snowp": `@0x8a9f2b3c4d5e6f70;
+protocol P
+    errors Text {
+    a @0 ();
+}
+protocol Q errors Text @0xcccccccc { b @0 (); }
+protocol R errors Text @new { c @0 (); }
+`},
+			want: map[string]string{"a.snowp": `@0x8a9f2b3c4d5e6f70;
+protocol P
+    errors Text @ID32 {
+    a @0 ();
+}
+protocol Q errors Text @0xcccccccc { b @0 (); }
+protocol R errors Text @ID32 { c @0 (); }
+`},
+			log: []string{
+				"a.snowp:3: protocol P @ID32",
+				"a.snowp:7: protocol R @ID32",
+			},
+		},
+		{
+			name:  "nothing to do",
+			files: map[string]string{"a.snowp": "@0x8a9f2b3c4d5e6f70;\nstruct S { a @0 : Uint; }\n"},
+			want:  map[string]string{"a.snowp": "@0x8a9f2b3c4d5e6f70;\nstruct S { a @0 : Uint; }\n"},
+		},
+		{
+			name: "duplicate in one file",
+			files: map[string]string{"a.snowp": `@0x8a9f2b3c4d5e6f70;
+struct S @0x8A9F2B3C4D5E6F70 { a @0 : Uint; }
+struct T @new { a @0 : Uint; }
+`},
+			wantErr: "a.snowp:2:10: unique ID 0x8A9F2B3C4D5E6F70 of struct S is already used by " +
+				"file at a.snowp:1:1; change one of them to @new",
+		},
+		{
+			name: "duplicate across files",
+			files: map[string]string{
+				"a.snowp": "@0x8a9f2b3c4d5e6f70;\nstruct S @new { a @0 : Uint; }\n",
+				"b.snowp": "@0x8a9f2b3c4d5e6f70;\nstruct T @new { a @0 : Uint; }\n",
+			},
+			wantErr: "b.snowp:1:1: unique ID 0x8a9f2b3c4d5e6f70 of file is already used by " +
+				"file at a.snowp:1:1; change one of them to @new",
+		},
+		{
+			name:    "doesn't parse",
+			files:   map[string]string{"a.snowp": "@new;\nstruct S @new { a @0 : ; }\n"},
+			wantErr: "a.snowp:2:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			var files []string
+			for _, nm := range []string{"a.snowp", "b.snowp"} {
+				if _, ok := tt.files[nm]; ok {
+					files = append(files, filepath.Join(dir, nm))
+				}
+			}
+			var out strings.Builder
+			err := RunNewID(NewIDOptions{Assign: true, Files: files}, &out)
+			clean := func(s string) string {
+				s = strings.ReplaceAll(s, dir+string(filepath.Separator), "")
+				return hexIDs.ReplaceAllStringFunc(s, func(id string) string {
+					if strings.Contains(tt.files["a.snowp"]+tt.files["b.snowp"], id) {
+						return id
+					}
+					if len(id) == len("@0x")+8 {
+						return "@ID32"
+					}
+					return "@ID64"
+				})
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(clean(err.Error()), tt.wantErr) {
+					t.Errorf("got error %v; want %q", err, tt.wantErr)
+				}
+				tt.want = tt.files // nothing is written
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Split(clean(out.String()), "\n"); strings.Join(got, "\n") !=
+				strings.Join(append(tt.log, ""), "\n") {
+				t.Errorf("printed:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.log, "\n"))
+			}
+			seen := make(map[string]bool)
+			for nm, want := range tt.want {
+				dat, err := os.ReadFile(filepath.Join(dir, nm))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := clean(string(dat)); got != want {
+					t.Errorf("%s:\n%s\nwant:\n%s", nm, got, want)
+				}
+				if tt.wantErr != "" {
+					continue
+				}
+				for _, id := range hexIDs.FindAllString(string(dat), -1) {
+					if seen[id] {
+						t.Errorf("%s used twice", id)
+					}
+					seen[id] = true
+				}
+				if _, err := Parse(dat, nm); err != nil {
+					t.Errorf("%s doesn't parse: %v", nm, err)
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package lib
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -28,7 +31,25 @@ type Options struct {
 	pkg     string
 	ext     string
 
+	importPath []string
+	goImports  map[string]string // Go import paths for `import` aliases, from the config
+	inputGlob  string            // from the config; if set, only input files whose paths under indir match it are used
+	include    []string          // globs; if any, only matching input files are used
+	exclude    []string          // globs for input files and directories to skip
+
+	warnExhaustive bool
+	fromAST        bool // the input files are JSON syntax trees, as written by snowpc ast
+
 	verbose bool
+	jobs    int // how many files to work on at once; 0 means one per CPU
+
+	watch         bool
+	watchInterval time.Duration
+	force         bool // rebuild everything, even what the manifest says is up to date
+
+	configFile string   // the project config; if empty, we look for one
+	baseDir    string   // if set, generated headers name input files relative to it
+	targets    []string // which of the config's targets to build; all if none
 }
 
 func (l Language) OutExt() string {
@@ -44,18 +65,10 @@ func (l Language) OutExt() string {
 
 var ErrNotImplemented = errors.New("not implemented")
 
-func ParseOpts() (*Options, error) {
+// Execute parses the command line and runs whichever subcommand it names.
+func Execute() error {
 	var opts Options
-	err := makeCommand(&opts).Execute()
-	if err != nil {
-		return nil, err
-	}
-	return &opts, nil
-}
-
-func (o *Options) Run() error {
-	runner := NewRunner(o)
-	return runner.Run()
+	return makeCommand(&opts).Execute()
 }
 
 func isDir(d string) bool {
@@ -66,8 +79,7 @@ func isDir(d string) bool {
 	return st.IsDir()
 }
 
-func (o *Options) check() error {
-
+func (o *Options) checkLang() error {
 	switch o.langRaw {
 	case "go":
 		o.lang = LangGo
@@ -76,6 +88,32 @@ func (o *Options) check() error {
 	default:
 		return fmt.Errorf("unsupported language: %s", o.langRaw)
 	}
+	return nil
+}
+
+// checkInput validates the flags that say what to read, which is all that
+// check needs.
+func (o *Options) checkInput() error {
+	if o.indir != "" && o.infile != "" {
+		return errors.New("cannot use input file with input directory")
+	}
+	if o.indir != "" && !isDir(o.indir) {
+		return fmt.Errorf("input directory %s does not exist", o.indir)
+	}
+	if o.indir == "" && o.infile == "" {
+		o.infile = "-"
+	}
+	if o.ext == "" {
+		o.ext = "snowp"
+	}
+	return nil
+}
+
+func (o *Options) check() error {
+	err := o.checkLang()
+	if err != nil {
+		return err
+	}
 
 	if (o.indir != "" && o.outdir == "") || (o.indir == "" && o.outdir != "") {
 		return errors.New("must specify output directory with input directory")
@@ -84,46 +122,330 @@ func (o *Options) check() error {
 		return errors.New("cannot use input or output file with input directory")
 	}
 
-	if o.indir != "" && !isDir(o.indir) {
-		return fmt.Errorf("input directory %s does not exist", o.indir)
+	err = o.checkInput()
+	if err != nil {
+		return err
 	}
 	if o.outdir != "" && !isDir(o.outdir) {
 		return fmt.Errorf("output directory %s does not exist", o.outdir)
 	}
-
-	if o.indir == "" && o.infile == "" {
-		o.infile = "-"
-	}
 	if o.outdir == "" && o.outfile == "" {
 		o.outfile = "-"
 	}
-
-	if o.pkg == "" {
-		return errors.New("must specify package name")
+	if o.watch && o.infile == "-" {
+		return errors.New("--watch needs an input file or directory")
 	}
-
-	if o.ext == "" {
-		o.ext = "snowp"
+	if o.indir == "" && (len(o.include) > 0 || len(o.exclude) > 0) {
+		return errors.New("can only use --include and --exclude with an input directory")
 	}
 
+	// The package name can also come from a go:package directive, or from
+	// the directory name, so it's checked once the files are parsed.
 	return nil
 }
 
+// loadConfig reads the project config, from --config or else from wherever
+// we find one, and fills in o's defaults from it. It returns nil if there's
+// no config. Once there's a config, errors are about it rather than the
+// flags, so there's no point showing usage.
+func (o *Options) loadConfig(cmd *cobra.Command) (*Config, error) {
+	path := o.configFile
+	if path == "" {
+		path = findConfig(".")
+	}
+	if path == "" {
+		if len(o.targets) > 0 {
+			return nil, errors.New("--target needs a snowpc.yaml or snowpc.json")
+		}
+		return nil, nil
+	}
+	cmd.SilenceUsage = true
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.applyDefaults(o, cmd.Flags().Changed)
+	return cfg, nil
+}
+
+// usesInputFlags reports whether the command line says what to compile, in
+// which case the config's targets aren't used.
+func usesInputFlags(changed func(string) bool) bool {
+	for _, f := range []string{"infile", "outfile", "input-dir", "output-dir"} {
+		if changed(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// makeCommand builds the command tree. Running snowpc with no subcommand is
+// the same as running `snowpc gen`, so existing build scripts keep working.
+// Errors are printed by main, once, and usage only for errors in the flags.
 func makeCommand(opts *Options) *cobra.Command {
 	ret := &cobra.Command{
-		Use:   "snowpc",
-		Short: "Snowpack RPC compiler compile .snowp files",
+		Use:           "snowpc",
+		Short:         "Snowpack RPC compiler compile .snowp files",
+		SilenceErrors: true,
+		RunE:          runGen(opts),
+	}
+	addGenFlags(ret, opts)
+
+	pf := ret.PersistentFlags()
+	pf.StringVarP(&opts.ext, "ext", "e", ".snowp", "file extension")
+	pf.StringArrayVar(&opts.importPath, "import-path", nil,
+		"directory to search for imported .snowp files (repeatable)")
+	pf.BoolVar(&opts.warnExhaustive, "warn-exhaustive", false,
+		"warn about enum-switched variants that miss values and have no default")
+	pf.BoolVarP(&opts.verbose, "verbose", "v", false, "verbose output")
+	pf.IntVarP(&opts.jobs, "jobs", "j", 0,
+		"how many files to parse and generate at once (default: one per CPU)")
+	pf.StringVar(&opts.configFile, "config", "",
+		"project config file (default: snowpc.yaml, .yml or .json, here or in a parent directory)")
+
+	ret.AddCommand(
+		makeGenCommand(opts),
+		makeCheckCommand(opts),
+		makeFmtCommand(),
+		makeASTCommand(),
+		makeCompatCommand(),
+		makeNewIDCommand(),
+		makeVersionCommand(),
+	)
+	return ret
+}
+
+func addGenFlags(cmd *cobra.Command, opts *Options) {
+	cmd.Flags().StringVarP(&opts.langRaw, "lang", "l", "go", "output language")
+	cmd.Flags().StringVarP(&opts.infile, "infile", "i", "", "input file")
+	cmd.Flags().StringVarP(&opts.outfile, "outfile", "o", "", "output file")
+	cmd.Flags().StringVarP(&opts.indir, "input-dir", "I", "", "input directory")
+	cmd.Flags().StringVarP(&opts.outdir, "output-dir", "O", "", "output directory")
+	cmd.Flags().StringVarP(&opts.pkg, "package", "p", "",
+		"package name (for -I, just the top directory; others are named after their directory)")
+	addFilterFlags(cmd, opts)
+	addTargetFlag(cmd, opts)
+	cmd.Flags().BoolVar(&opts.fromAST, "from-ast", false,
+		"read the input as JSON syntax trees, as written by `snowpc ast`, rather than as .snowp source")
+	cmd.Flags().BoolVar(&opts.force, "force", false,
+		"with -I, rebuild every file, even those that haven't changed since the last run")
+	cmd.Flags().BoolVar(&opts.watch, "watch", false,
+		"keep running, and rebuild whenever an input file changes")
+	cmd.Flags().DurationVar(&opts.watchInterval, "watch-interval", 500*time.Millisecond,
+		"how often --watch looks for changes")
+}
+
+func addFilterFlags(cmd *cobra.Command, opts *Options) {
+	cmd.Flags().StringArrayVar(&opts.include, "include", nil,
+		"with -I, only use input files matching this glob (repeatable)")
+	cmd.Flags().StringArrayVar(&opts.exclude, "exclude", nil,
+		"with -I, skip input files and directories matching this glob (repeatable)")
+}
+
+func addTargetFlag(cmd *cobra.Command, opts *Options) {
+	cmd.Flags().StringArrayVarP(&opts.targets, "target", "t", nil,
+		"build just this target from the project config (repeatable)")
+}
+
+// runGen builds what the flags say to, or else every target in the project
+// config.
+func runGen(opts *Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		changed := cmd.Flags().Changed
+		cfg, err := opts.loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		if cfg == nil || usesInputFlags(changed) {
+			if len(opts.targets) > 0 {
+				return errors.New("cannot use --target with input or output flags")
+			}
+			err = opts.check()
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			return gen(cmd, opts)
+		}
+
+		ts, err := cfg.selectTargets(opts.targets)
+		if err != nil {
+			return err
+		}
+		var tos []*Options
+		for _, t := range ts {
+			to := cfg.targetOptions(opts, t, changed)
+			err = to.check()
+			if err != nil {
+				return fmt.Errorf("target %s: %w", cmp.Or(t.Name, t.Input), err)
+			}
+			tos = append(tos, to)
+		}
+		if opts.watch && len(tos) != 1 {
+			return errors.New("--watch builds one target at a time; pick one with --target")
+		}
+		cmd.SilenceUsage = true
+		var errs []error
+		for _, to := range tos {
+			errs = append(errs, gen(cmd, to))
+		}
+		return collectErrors(errs)
+	}
+}
+
+func gen(cmd *cobra.Command, opts *Options) error {
+	if opts.watch {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+		return RunWatch(ctx, opts, os.Stderr)
+	}
+	return NewRunner(opts).Run()
+}
+
+func makeGenCommand(opts *Options) *cobra.Command {
+	ret := &cobra.Command{
+		Use:   "gen",
+		Short: "generate code from .snowp files",
+		Args:  cobra.NoArgs,
+		RunE:  runGen(opts),
+	}
+	addGenFlags(ret, opts)
+	return ret
+}
+
+func makeCheckCommand(opts *Options) *cobra.Command {
+	ret := &cobra.Command{
+		Use:   "check [files or directories...]",
+		Short: "check .snowp files for errors without generating code",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return opts.check()
+			changed := cmd.Flags().Changed
+			cfg, err := opts.loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			if cfg == nil || len(args) > 0 {
+				err = opts.checkLang()
+				if err != nil {
+					return err
+				}
+				cmd.SilenceUsage = true
+				return RunCheck(opts, args)
+			}
+
+			// With no arguments, check the input of each target.
+			ts, err := cfg.selectTargets(opts.targets)
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			var errs []error
+			for _, t := range ts {
+				to := cfg.targetOptions(opts, t, changed)
+				err = to.checkLang()
+				if err == nil {
+					err = RunCheck(to, []string{cmp.Or(to.indir, to.infile)})
+				}
+				errs = append(errs, err)
+			}
+			return collectErrors(errs)
 		},
 	}
-	ret.Flags().StringVarP(&opts.langRaw, "lang", "l", "go", "output language")
-	ret.Flags().StringVarP(&opts.infile, "infile", "i", "", "input file")
-	ret.Flags().StringVarP(&opts.outfile, "outfile", "o", "", "output file")
-	ret.Flags().StringVarP(&opts.indir, "input-dir", "I", "", "input directory")
-	ret.Flags().StringVarP(&opts.outdir, "output-dir", "O", "", "output directory")
-	ret.Flags().StringVarP(&opts.pkg, "package", "p", "", "package name")
-	ret.Flags().StringVarP(&opts.ext, "ext", "e", ".snowp", "file extension")
-	ret.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "verbose output")
+	ret.Flags().StringVarP(&opts.langRaw, "lang", "l", "go",
+		"language that imports must be usable from")
+	addFilterFlags(ret, opts)
+	addTargetFlag(ret, opts)
 	return ret
 }
+
+func makeFmtCommand() *cobra.Command {
+	var o FmtOptions
+	ret := &cobra.Command{
+		Use:   "fmt [files...]",
+		Short: "format .snowp files in the canonical style",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.Files = args
+			cmd.SilenceUsage = true
+			return RunFmt(o, cmd.OutOrStdout())
+		},
+	}
+	ret.Flags().BoolVarP(&o.Write, "write", "w", false,
+		"write the result back to the files instead of stdout")
+	ret.Flags().BoolVar(&o.Check, "check", false,
+		"list files that aren't formatted, and fail if there are any")
+	return ret
+}
+
+func makeASTCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ast [file]",
+		Short: "print the syntax tree of a .snowp file as JSON",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f := newInfile("-")
+			if len(args) > 0 {
+				f = newInfile(args[0])
+			}
+			cmd.SilenceUsage = true
+			return RunAST(&f, cmd.OutOrStdout())
+		},
+	}
+}
+
+func makeCompatCommand() *cobra.Command {
+	var o CompatOptions
+	ret := &cobra.Command{
+		Use:   "compat OLD NEW | compat --rev REV FILE",
+		Short: "report changes between two versions of a .snowp file that break the wire format",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case o.Rev != "" && len(args) == 1:
+				o.New = args[0]
+			case o.Rev == "" && len(args) == 2:
+				o.Old, o.New = args[0], args[1]
+			default:
+				return errCompatArgs
+			}
+			cmd.SilenceUsage = true
+			return RunCompat(o, cmd.OutOrStdout())
+		},
+	}
+	ret.Flags().StringVar(&o.Rev, "rev", "",
+		"compare FILE against its copy at this git revision")
+	return ret
+}
+
+func makeNewIDCommand() *cobra.Command {
+	var o NewIDOptions
+	ret := &cobra.Command{
+		Use:   "newid [--bits 64|32] [-n COUNT] | newid --assign FILE...",
+		Short: "print new random unique IDs, or fill in the missing ones in .snowp files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case o.Assign && len(args) > 0:
+				o.Files = args
+			case !o.Assign && len(args) == 0 && (o.Bits == 64 || o.Bits == 32):
+			default:
+				return errNewIDArgs
+			}
+			cmd.SilenceUsage = true
+			return RunNewID(o, cmd.OutOrStdout())
+		},
+	}
+	ret.Flags().IntVar(&o.Bits, "bits", 64,
+		"size of the IDs: 64 for files and types, 32 for protocols")
+	ret.Flags().IntVarP(&o.Count, "count", "n", 1, "how many IDs to print")
+	ret.Flags().BoolVar(&o.Assign, "assign", false,
+		"give an ID to each @new, and to each protocol without one, and rewrite the files")
+	return ret
+}
+
+func makeVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "print the compiler version",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprintf(cmd.OutOrStdout(), "snowpc %s\n", version)
+		},
+	}
+}
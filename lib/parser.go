@@ -12,7 +12,16 @@ import (
 	"strconv"
 )
 
-//line parser.y:12
+// members is the body of a struct, variant or protocol, along with any
+// reserved clauses mixed in with it.
+type members struct {
+	fields   []Field
+	cases    []Case
+	methods  []Method
+	reserved []Reserved
+}
+
+//line parser.y:21
 type snowpSymType struct {
 	yys            int
 	root           Root
@@ -24,25 +33,26 @@ type snowpSymType struct {
 	imprt          Import
 	dec            Decorators
 	doc            Docstring
-	docRaw         string
 	ident          Identifier
 	typ            Type
 	num            int
 	field          Field
-	fields         []Field
-	cases          []Case
+	members        members
+	reserved       []Reserved
+	rsv            Reserved
 	cas            Case
 	intp           *int
 	caseLabel      CaseLabel
 	caseLabels     []CaseLabel
 	enumValues     []EnumValue
 	enumValue      EnumValue
+	literal        Literal
 	protoModifiers []ProtocolModifier
 	protoModifier  ProtocolModifier
 	params         []Param
 	param          Param
 	method         Method
-	methods        []Method
+	span           Span
 }
 
 const TokenAt = 57346
@@ -53,41 +63,54 @@ const TokenDot = 57350
 const TokenImport = 57351
 const TokenTypeScriptImport = 57352
 const TokenGoImport = 57353
-const TokenList = 57354
-const TokenLParen = 57355
-const TokenRParen = 57356
-const TokenText = 57357
-const TokenUint = 57358
-const TokenInt = 57359
-const TokenBool = 57360
-const TokenBlob = 57361
-const TokenFuture = 57362
-const TokenLBrace = 57363
-const TokenRBrace = 57364
-const TokenStruct = 57365
-const TokenOption = 57366
-const TokenColon = 57367
-const TokenVariant = 57368
-const TokenSwitch = 57369
-const TokenCase = 57370
-const TokenTrue = 57371
-const TokenFalse = 57372
-const TokenDefault = 57373
-const TokenVoid = 57374
-const TokenEnum = 57375
-const TokenProtocol = 57376
-const TokenErrors = 57377
-const TokenArgHeader = 57378
-const TokenResHeader = 57379
-const TokenArrow = 57380
-const TokenComma = 57381
-const TokenUint64Val = 57382
-const TokenIntVal = 57383
-const TokenUint32Val = 57384
-const TokenDQoutedString = 57385
-const TokenIdentifier = 57386
-const TokenDoc = 57387
-const TokenTypedef = 57388
+const TokenGoPackage = 57354
+const TokenList = 57355
+const TokenMap = 57356
+const TokenLParen = 57357
+const TokenRParen = 57358
+const TokenText = 57359
+const TokenUint = 57360
+const TokenInt = 57361
+const TokenBool = 57362
+const TokenBlob = 57363
+const TokenFuture = 57364
+const TokenUint8 = 57365
+const TokenUint16 = 57366
+const TokenUint32 = 57367
+const TokenInt8 = 57368
+const TokenInt16 = 57369
+const TokenInt32 = 57370
+const TokenFloat32 = 57371
+const TokenFloat64 = 57372
+const TokenLBrace = 57373
+const TokenRBrace = 57374
+const TokenStruct = 57375
+const TokenOption = 57376
+const TokenColon = 57377
+const TokenVariant = 57378
+const TokenSwitch = 57379
+const TokenCase = 57380
+const TokenTrue = 57381
+const TokenFalse = 57382
+const TokenDefault = 57383
+const TokenVoid = 57384
+const TokenEnum = 57385
+const TokenConst = 57386
+const TokenProtocol = 57387
+const TokenErrors = 57388
+const TokenArgHeader = 57389
+const TokenResHeader = 57390
+const TokenReserved = 57391
+const TokenArrow = 57392
+const TokenComma = 57393
+const TokenUint64Val = 57394
+const TokenIntVal = 57395
+const TokenUint32Val = 57396
+const TokenHexVal = 57397
+const TokenDQoutedString = 57398
+const TokenIdentifier = 57399
+const TokenDoc = 57400
+const TokenTypedef = 57401
 
 var snowpToknames = [...]string{
 	"$end",
@@ -101,7 +124,9 @@ var snowpToknames = [...]string{
 	"TokenImport",
 	"TokenTypeScriptImport",
 	"TokenGoImport",
+	"TokenGoPackage",
 	"TokenList",
+	"TokenMap",
 	"TokenLParen",
 	"TokenRParen",
 	"TokenText",
@@ -110,6 +135,14 @@ var snowpToknames = [...]string{
 	"TokenBool",
 	"TokenBlob",
 	"TokenFuture",
+	"TokenUint8",
+	"TokenUint16",
+	"TokenUint32",
+	"TokenInt8",
+	"TokenInt16",
+	"TokenInt32",
+	"TokenFloat32",
+	"TokenFloat64",
 	"TokenLBrace",
 	"TokenRBrace",
 	"TokenStruct",
@@ -123,15 +156,18 @@ var snowpToknames = [...]string{
 	"TokenDefault",
 	"TokenVoid",
 	"TokenEnum",
+	"TokenConst",
 	"TokenProtocol",
 	"TokenErrors",
 	"TokenArgHeader",
 	"TokenResHeader",
+	"TokenReserved",
 	"TokenArrow",
 	"TokenComma",
 	"TokenUint64Val",
 	"TokenIntVal",
 	"TokenUint32Val",
+	"TokenHexVal",
 	"TokenDQoutedString",
 	"TokenIdentifier",
 	"TokenDoc",
@@ -144,143 +180,196 @@ const snowpEofCode = 1
 const snowpErrCode = 2
 const snowpInitialStackSize = 16
 
-//line parser.y:499
+//line parser.y:628
 
 //line yacctab:1
-var snowpExca = [...]int8{
+var snowpExca = [...]int16{
 	-1, 1,
 	1, -1,
 	-2, 0,
 	-1, 5,
 	1, 1,
-	-2, 8,
+	33, 9,
+	36, 9,
+	43, 9,
+	44, 9,
+	45, 9,
+	58, 9,
+	59, 9,
+	-2, 0,
+	-1, 146,
+	57, 9,
+	58, 9,
+	-2, 0,
 }
 
 const snowpPrivate = 57344
 
-const snowpLast = 218
+const snowpLast = 331
 
 var snowpAct = [...]uint8{
-	168, 81, 167, 122, 144, 150, 155, 156, 152, 135,
-	44, 20, 70, 57, 79, 34, 36, 75, 73, 74,
-	76, 80, 75, 73, 74, 76, 80, 98, 35, 37,
-	38, 39, 40, 79, 169, 52, 75, 73, 74, 76,
-	80, 45, 3, 51, 50, 125, 36, 85, 46, 36,
-	58, 36, 33, 32, 68, 31, 27, 83, 58, 28,
-	102, 161, 88, 89, 90, 36, 29, 30, 79, 36,
-	84, 75, 73, 74, 76, 80, 72, 109, 8, 26,
-	9, 158, 79, 173, 99, 75, 73, 74, 76, 80,
-	4, 60, 47, 148, 105, 101, 153, 154, 107, 138,
-	36, 171, 139, 138, 106, 104, 139, 108, 102, 165,
-	141, 36, 163, 110, 36, 114, 121, 118, 119, 123,
-	100, 61, 62, 63, 113, 130, 87, 54, 48, 127,
-	133, 126, 160, 146, 145, 120, 117, 116, 115, 132,
-	151, 129, 95, 159, 147, 93, 92, 55, 21, 22,
-	23, 96, 53, 162, 43, 42, 164, 41, 178, 177,
-	166, 128, 111, 145, 91, 151, 170, 66, 174, 172,
-	65, 64, 123, 6, 109, 175, 176, 4, 86, 103,
-	112, 7, 143, 142, 131, 59, 49, 56, 149, 137,
-	136, 134, 82, 97, 94, 157, 124, 69, 78, 77,
-	67, 71, 140, 25, 24, 19, 18, 17, 11, 16,
-	15, 14, 13, 12, 10, 5, 2, 1,
+	88, 233, 232, 179, 205, 8, 214, 155, 219, 154,
+	194, 125, 55, 216, 25, 100, 70, 7, 69, 9,
+	152, 10, 134, 44, 11, 11, 11, 40, 64, 63,
+	62, 109, 43, 42, 41, 45, 46, 47, 48, 49,
+	50, 104, 105, 134, 152, 75, 73, 74, 76, 87,
+	173, 77, 78, 79, 80, 81, 82, 83, 84, 57,
+	71, 110, 126, 225, 139, 140, 170, 98, 107, 71,
+	234, 11, 35, 11, 90, 36, 72, 9, 136, 10,
+	142, 138, 37, 38, 39, 11, 11, 108, 217, 218,
+	4, 228, 127, 117, 118, 119, 174, 222, 34, 58,
+	238, 236, 134, 230, 202, 171, 11, 128, 165, 129,
+	130, 60, 189, 133, 116, 144, 145, 11, 66, 59,
+	196, 224, 115, 207, 148, 186, 132, 175, 143, 169,
+	156, 141, 91, 92, 93, 210, 151, 147, 166, 149,
+	191, 75, 73, 74, 76, 87, 157, 77, 78, 79,
+	80, 81, 82, 83, 84, 129, 199, 168, 164, 200,
+	32, 167, 162, 161, 188, 211, 172, 129, 177, 21,
+	113, 199, 178, 180, 200, 156, 27, 28, 29, 26,
+	123, 11, 129, 122, 183, 185, 121, 33, 184, 114,
+	192, 67, 206, 65, 54, 53, 52, 243, 242, 231,
+	215, 195, 227, 223, 208, 209, 212, 187, 220, 176,
+	160, 159, 158, 150, 226, 131, 120, 111, 96, 95,
+	94, 220, 229, 51, 6, 152, 206, 4, 112, 215,
+	235, 56, 3, 239, 163, 237, 135, 137, 180, 204,
+	240, 241, 104, 105, 203, 190, 75, 73, 74, 76,
+	87, 89, 77, 78, 79, 80, 81, 82, 83, 84,
+	104, 105, 61, 182, 75, 73, 74, 76, 87, 103,
+	77, 78, 79, 80, 81, 82, 83, 84, 68, 213,
+	198, 197, 153, 104, 105, 146, 11, 75, 73, 74,
+	76, 87, 193, 77, 78, 79, 80, 81, 82, 83,
+	84, 106, 124, 221, 11, 181, 99, 86, 85, 97,
+	102, 101, 201, 31, 30, 24, 23, 22, 13, 20,
+	19, 18, 17, 16, 15, 14, 12, 11, 5, 2,
+	1,
 }
 
 var snowpPact = [...]int16{
-	173, -1000, -1000, 168, 38, 139, -1000, -1000, -1000, -1000,
+	223, -1000, -1000, 219, -33, 167, -1000, -1000, -1000, -1000,
 	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
-	33, 12, 10, 9, -1000, -30, -28, -28, -28, -28,
-	-28, 151, 149, 148, -1000, 173, -1000, 173, 65, 107,
-	-1000, 0, -1, -9, 145, -1000, 106, 134, -28, 86,
-	166, 165, 162, 56, -1000, -28, 25, -1000, 174, -1000,
-	105, 70, 70, 70, -1000, -1000, -1000, 159, -1000, -1000,
-	-1000, -1000, 133, -1000, -1000, -1000, -1000, -1000, -1000, 132,
-	129, 143, 5, 95, -1000, -1000, 19, -1000, -1000, -1000,
-	-1000, -1000, 7, 70, -1000, 19, -28, -1000, -1000, 170,
-	7, 157, -1000, 102, 124, 123, 122, -1000, 92, 19,
-	121, -1000, -1000, -1000, -28, -1000, -1000, -1000, 21, -1000,
-	173, 170, 156, -1000, -1000, 128, 104, 126, -1000, 70,
-	75, 85, -28, 119, 71, -1000, -1000, -1000, 67, 170,
-	43, -28, 118, 22, -1000, 170, -1000, -1000, -1000, 73,
-	-1000, -1000, -1000, -1000, -1000, 84, -1000, 155, 2, -1000,
-	-1000, -28, 76, 67, 58, 2, -1000, -1000, -1000, -1000,
-	-1000, 21, -1000, 2, 154, -1000, 153, -1000, -1000,
+	-1000, 155, -1000, -1000, -1000, 39, -32, -22, -23, -24,
+	-1000, -35, -1000, -1000, -32, -32, -32, -32, -32, -32,
+	218, 190, 189, 188, -1000, 223, 223, 62, 88, 76,
+	-1000, -1000, -27, -28, -29, 186, -1000, 87, 176, 14,
+	124, 86, 215, 214, 213, 247, -1000, -32, 29, -1000,
+	212, 224, 163, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, 174, 114, -1000,
+	83, 270, 270, 270, -1000, -1000, -1000, 211, -1000, -1000,
+	-1000, -1000, -1000, 171, 168, 165, 60, 75, -1000, 210,
+	-1000, -1000, -31, 25, -31, -32, -1000, -1000, -1000, -1000,
+	-1000, 124, 270, 124, -1000, -1000, 208, -1000, 221, 16,
+	124, -1000, 207, 206, -1000, 205, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, 147, 146, -1000, 106, 141, 113, 15,
+	-1000, 70, -10, 45, -1000, -1000, -1000, 111, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, 204, -1000, -32, -1000, -1000,
+	270, 229, -1000, -1000, 16, 223, -1000, 221, 109, 202,
+	-1000, -1000, 149, -1000, 81, 125, -1000, -1000, 270, 118,
+	69, -32, 107, 133, -1000, -1000, 201, -1000, -1000, 49,
+	221, 47, -32, 105, 12, -1000, 221, -1000, -1000, -1000,
+	197, -1000, -1000, 40, -1000, -1000, -1000, -1000, -1000, 68,
+	-1000, 194, 28, -1000, -1000, -32, 66, -1000, 49, 65,
+	28, -1000, -1000, -1000, -1000, -1000, 229, -1000, 28, 193,
+	-1000, 192, -1000, -1000,
 }
 
-var snowpPgo = [...]uint8{
-	0, 217, 216, 41, 10, 215, 214, 213, 212, 211,
-	210, 209, 208, 207, 206, 205, 11, 204, 203, 1,
-	202, 201, 0, 12, 200, 199, 198, 197, 3, 196,
-	2, 195, 194, 8, 7, 193, 192, 191, 9, 190,
-	189, 5, 188, 187, 13, 186, 185, 184, 183, 182,
-	4, 6, 181, 180, 179,
+var snowpPgo = [...]int16{
+	0, 330, 329, 231, 12, 328, 326, 325, 324, 323,
+	322, 321, 320, 319, 318, 317, 316, 315, 14, 314,
+	313, 0, 312, 311, 310, 1, 15, 309, 308, 307,
+	306, 3, 305, 2, 303, 13, 7, 302, 301, 292,
+	285, 11, 282, 9, 10, 281, 280, 6, 279, 278,
+	18, 262, 251, 245, 244, 239, 4, 8, 17, 237,
+	236, 234,
 }
 
 var snowpR1 = [...]int8{
-	0, 1, 5, 5, 13, 14, 15, 17, 18, 18,
-	16, 4, 4, 21, 33, 32, 32, 25, 26, 26,
-	23, 23, 23, 23, 23, 23, 22, 22, 27, 24,
-	24, 7, 34, 51, 51, 29, 28, 28, 35, 36,
-	36, 8, 37, 37, 38, 38, 42, 42, 41, 41,
-	41, 41, 30, 30, 39, 40, 9, 44, 43, 43,
-	10, 12, 12, 12, 6, 6, 6, 6, 6, 6,
-	19, 2, 52, 52, 3, 46, 46, 46, 45, 45,
-	20, 20, 48, 48, 49, 49, 50, 47, 31, 31,
-	53, 54, 54, 11,
+	0, 1, 5, 5, 15, 16, 17, 13, 19, 20,
+	20, 18, 4, 4, 23, 24, 35, 28, 28, 28,
+	29, 29, 26, 26, 26, 26, 26, 26, 26, 26,
+	26, 26, 26, 26, 26, 26, 25, 25, 25, 30,
+	27, 27, 7, 36, 57, 57, 32, 31, 31, 37,
+	41, 42, 42, 43, 43, 38, 38, 38, 38, 8,
+	39, 39, 39, 39, 39, 39, 44, 44, 48, 48,
+	47, 47, 47, 47, 33, 33, 45, 46, 9, 50,
+	50, 49, 49, 49, 49, 10, 12, 60, 60, 60,
+	60, 60, 14, 14, 14, 6, 6, 6, 6, 6,
+	6, 6, 6, 6, 6, 21, 2, 58, 58, 59,
+	59, 3, 3, 52, 52, 52, 51, 51, 22, 22,
+	54, 54, 55, 55, 56, 53, 34, 34, 61, 40,
+	40, 40, 40, 11,
 }
 
 var snowpR2 = [...]int8{
-	0, 2, 0, 2, 5, 5, 5, 1, 0, 2,
-	1, 0, 1, 4, 1, 0, 3, 2, 1, 3,
-	1, 1, 1, 1, 1, 1, 1, 1, 4, 1,
-	1, 7, 2, 0, 1, 4, 1, 1, 5, 0,
-	2, 7, 1, 2, 1, 1, 1, 3, 1, 1,
-	1, 1, 1, 1, 6, 5, 13, 4, 1, 2,
-	6, 1, 1, 1, 1, 1, 1, 1, 1, 1,
-	1, 2, 1, 1, 2, 2, 2, 2, 0, 2,
-	0, 2, 0, 1, 1, 3, 4, 3, 0, 2,
-	7, 0, 2, 8,
+	0, 2, 0, 2, 5, 5, 5, 3, 1, 0,
+	2, 1, 0, 1, 4, 6, 1, 1, 4, 4,
+	1, 3, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 4,
+	1, 1, 7, 2, 0, 1, 4, 1, 1, 5,
+	3, 1, 3, 1, 1, 0, 2, 2, 3, 7,
+	1, 1, 2, 2, 2, 3, 1, 1, 1, 3,
+	1, 1, 1, 1, 1, 1, 6, 5, 13, 4,
+	4, 1, 2, 2, 3, 6, 8, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 2, 2, 1, 2, 1, 1, 1,
+	1, 2, 2, 2, 2, 2, 0, 2, 0, 2,
+	0, 1, 1, 3, 4, 3, 0, 2, 7, 0,
+	2, 2, 3, 8,
 }
 
 var snowpChk = [...]int16{
-	-1000, -1, -2, -3, 4, -5, 5, -52, 40, 42,
-	-6, -12, -7, -8, -9, -10, -11, -13, -14, -15,
-	-16, 9, 10, 11, -17, -18, 46, 23, 26, 33,
-	34, 43, 43, 43, 45, -19, 44, -19, -19, -19,
-	-19, 6, 6, 6, -4, -3, -4, 27, 21, -45,
-	44, 44, 44, 7, 21, 13, -43, -44, -19, -46,
-	-3, 35, 36, 37, 5, 5, 5, -24, -22, -27,
-	-23, -21, 20, 16, 17, 15, 18, -25, -26, 12,
-	19, -19, -36, -19, -44, 22, 4, 21, -22, -22,
-	-22, 5, 13, 13, -32, 13, 8, -35, 22, -19,
-	25, -33, 41, -54, -23, -22, -33, -19, -34, 4,
-	-23, 5, -53, 22, -16, 14, 14, 14, 25, -33,
-	14, -19, -28, -22, -29, 24, -4, -34, 5, 13,
-	21, -47, 13, -22, -37, -38, -39, -40, 28, 31,
-	-20, 25, -48, -49, -50, -19, 14, -38, 22, -42,
-	-41, -19, -33, 29, 30, -51, -34, -31, 38, -19,
-	14, 39, -34, 39, -51, 25, 5, -30, -22, 32,
-	-50, 25, -41, 25, -30, -28, -30, 5, 5,
+	-1000, -1, -2, -3, 4, -5, 5, -58, -21, 52,
+	54, 57, -6, -14, -7, -8, -9, -10, -11, -12,
+	-13, 2, -15, -16, -17, -18, 12, 9, 10, 11,
+	-19, -20, 5, 32, 59, 33, 36, 43, 44, 45,
+	-21, 56, 56, 56, 58, -21, -21, -21, -21, -21,
+	-21, 5, 6, 6, 6, -4, -3, -4, 37, 31,
+	35, -51, 57, 57, 57, 7, 31, 15, -49, -50,
+	2, -21, -26, 18, 19, 17, 20, 23, 24, 25,
+	26, 27, 28, 29, 30, -28, -29, 21, -21, -52,
+	-4, 46, 47, 48, 5, 5, 5, -27, -25, -30,
+	-26, -23, -24, 22, 13, 14, -38, -21, -50, 2,
+	32, 5, 4, 7, 15, 8, 31, -25, -25, -25,
+	5, 15, 15, 15, -37, -41, 2, 32, -21, 49,
+	35, 5, -35, -21, 53, -60, 53, -59, 56, 39,
+	40, -58, 55, -35, -21, -21, -40, -26, -25, -26,
+	5, -36, 4, -42, -43, -36, -21, -26, 5, 5,
+	5, 16, 16, -61, -41, 2, 32, -18, 16, 16,
+	51, 35, -35, 5, 51, 16, 5, -21, -25, -31,
+	-25, -32, 34, -43, -4, -36, 16, 5, 15, 31,
+	-53, 15, -25, -39, -44, -41, 2, -45, -46, 38,
+	41, -22, 35, -54, -55, -56, -21, 16, -44, -41,
+	2, 32, 5, -48, -47, -21, -35, 39, 40, -57,
+	-36, -34, 50, -21, 16, 51, -36, 5, 51, -57,
+	35, 5, -33, -25, 42, -56, 35, -47, 35, -33,
+	-31, -33, 5, 5,
 }
 
-var snowpDef = [...]int8{
-	0, -2, 2, 0, 0, -2, 71, 74, 72, 73,
-	3, 64, 65, 66, 67, 68, 69, 61, 62, 63,
-	0, 0, 0, 0, 10, 7, 0, 0, 0, 0,
-	0, 0, 0, 0, 9, 11, 70, 11, 0, 0,
-	78, 0, 0, 0, 0, 12, 0, 0, 0, 0,
-	0, 0, 0, 0, 39, 0, 0, 58, 0, 79,
-	0, 0, 0, 0, 4, 5, 6, 0, 29, 30,
-	26, 27, 0, 20, 21, 22, 23, 24, 25, 0,
-	15, 18, 0, 0, 59, 60, 0, 91, 75, 76,
-	77, 31, 0, 0, 17, 0, 0, 40, 41, 0,
-	0, 0, 14, 8, 0, 0, 0, 19, 0, 0,
-	0, 57, 92, 93, 0, 28, 13, 16, 0, 32,
-	11, 0, 0, 36, 37, 0, 0, 0, 38, 0,
-	0, 80, 82, 0, 0, 42, 44, 45, 0, 33,
-	88, 0, 0, 83, 84, 0, 35, 43, 56, 33,
-	46, 48, 49, 50, 51, 0, 34, 0, 0, 81,
-	87, 0, 0, 0, 0, 0, 90, 89, 52, 53,
-	85, 0, 47, 0, 0, 86, 0, 55, 54,
+var snowpDef = [...]int16{
+	0, -2, 2, 0, 0, -2, 106, 111, 112, 107,
+	108, 105, 3, 95, 96, 97, 98, 99, 100, 101,
+	102, 0, 92, 93, 94, 0, 0, 0, 0, 0,
+	11, 8, 103, 104, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 10, 12, 12, 0, 0, 0,
+	116, 7, 0, 0, 0, 0, 13, 0, 0, 0,
+	0, 12, 0, 0, 0, 0, 55, 0, 0, 81,
+	0, 0, 0, 22, 23, 24, 25, 26, 27, 28,
+	29, 30, 31, 32, 33, 34, 35, 17, 20, 117,
+	0, 0, 0, 0, 4, 5, 6, 0, 40, 41,
+	36, 37, 38, 0, 0, 0, 0, 0, 83, 0,
+	85, 82, 0, 0, 0, 0, 129, 113, 114, 115,
+	42, 0, 0, 0, 56, 57, 0, 59, 0, 0,
+	0, 84, 0, 0, 16, 0, 87, 88, 89, 90,
+	91, 109, 110, 0, 0, 21, -2, 0, 0, 0,
+	58, 0, 0, 0, 51, 53, 54, 0, 79, 80,
+	86, 18, 19, 130, 131, 0, 133, 0, 39, 14,
+	0, 0, 43, 50, 0, 12, 132, 0, 0, 0,
+	47, 48, 0, 52, 0, 0, 15, 49, 0, 0,
+	118, 120, 0, 0, 60, 61, 0, 66, 67, 0,
+	44, 126, 0, 0, 121, 122, 0, 46, 63, 64,
+	0, 78, 62, 44, 68, 70, 71, 72, 73, 0,
+	45, 0, 0, 119, 125, 0, 0, 65, 0, 0,
+	0, 128, 127, 74, 75, 123, 0, 69, 0, 0,
+	124, 0, 77, 76,
 }
 
 var snowpTok1 = [...]int8{
@@ -292,7 +381,8 @@ var snowpTok2 = [...]int8{
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
 	32, 33, 34, 35, 36, 37, 38, 39, 40, 41,
-	42, 43, 44, 45, 46,
+	42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
+	52, 53, 54, 55, 56, 57, 58, 59,
 }
 
 var snowpTok3 = [...]int8{
@@ -638,565 +728,821 @@ snowpdefault:
 
 	case 1:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:87
+//line parser.y:97
 		{
 			snowpVAL.root = Root{Id: snowpDollar[1].uniqueId, Stmts: snowpDollar[2].stmts}
-			top = &snowpVAL.root // Set the global top variable
+			state(snowplex).setRoot(snowpVAL.root)
 		}
 	case 2:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:94
+//line parser.y:104
 		{
 			snowpVAL.stmts = []Statement{}
 		}
 	case 3:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:95
+//line parser.y:106
 		{
-			snowpVAL.stmts = append(snowpDollar[1].stmts, snowpDollar[2].stmt)
+			snowpVAL.stmts = snowpDollar[1].stmts
+			if snowpDollar[2].stmt != nil {
+				snowpVAL.stmts = append(snowpVAL.stmts, snowpDollar[2].stmt)
+			}
 		}
 	case 4:
 		snowpDollar = snowpS[snowppt-5 : snowppt+1]
-//line parser.y:100
+//line parser.y:116
 		{
-			snowpVAL.imprt = Import{Path: snowpDollar[2].rawval, Name: snowpDollar[4].rawval, Lang: LangGeneric}
+			snowpVAL.imprt = Import{Path: snowpDollar[2].rawval, Name: snowpDollar[4].rawval, Lang: LangGeneric, Span: snowpDollar[1].span.Extend(snowpDollar[5].span)}
 		}
 	case 5:
 		snowpDollar = snowpS[snowppt-5 : snowppt+1]
-//line parser.y:107
+//line parser.y:123
 		{
-			snowpVAL.imprt = Import{Path: snowpDollar[2].rawval, Name: snowpDollar[4].rawval, Lang: LangTypeScript}
+			snowpVAL.imprt = Import{Path: snowpDollar[2].rawval, Name: snowpDollar[4].rawval, Lang: LangTypeScript, Span: snowpDollar[1].span.Extend(snowpDollar[5].span)}
 		}
 	case 6:
 		snowpDollar = snowpS[snowppt-5 : snowppt+1]
-//line parser.y:114
+//line parser.y:130
 		{
-			snowpVAL.imprt = Import{Path: snowpDollar[2].rawval, Name: snowpDollar[4].rawval, Lang: LangGo}
+			snowpVAL.imprt = Import{Path: snowpDollar[2].rawval, Name: snowpDollar[4].rawval, Lang: LangGo, Span: snowpDollar[1].span.Extend(snowpDollar[5].span)}
 		}
 	case 7:
-		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:121
+		snowpDollar = snowpS[snowppt-3 : snowppt+1]
+//line parser.y:137
 		{
-			snowpVAL.doc = Docstring{Raw: snowpDollar[1].docRaw}
+			snowpVAL.stmt = GoPackage{Name: snowpDollar[2].ident, Span: snowpDollar[1].span.Extend(snowpDollar[3].span)}
 		}
 	case 8:
-		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:127
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:144
 		{
-			snowpVAL.docRaw = ""
+			snowpVAL.doc = snowpDollar[1].doc
 		}
 	case 9:
-		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:128
+		snowpDollar = snowpS[snowppt-0 : snowppt+1]
+//line parser.y:150
 		{
-			snowpVAL.docRaw = snowpDollar[1].docRaw + snowpDollar[2].rawval
+			snowpVAL.doc = Docstring{}
 		}
 	case 10:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:151
+		{
+			snowpVAL.doc = Docstring{Raw: snowpDollar[1].doc.Raw + snowpDollar[2].rawval, Span: snowpDollar[1].doc.Span.Extend(snowpDollar[2].span)}
+		}
+	case 11:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:132
+//line parser.y:155
 		{
 			snowpVAL.dec = Decorators{Doc: snowpDollar[1].doc}
 		}
-	case 11:
+	case 12:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:136
+//line parser.y:159
 		{
 			snowpVAL.uniqueId = UniqueID{}
 		}
-	case 12:
+	case 13:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:137
+//line parser.y:160
 		{
 			snowpVAL.uniqueId = snowpDollar[1].uniqueId
 		}
-	case 13:
+	case 14:
 		snowpDollar = snowpS[snowppt-4 : snowppt+1]
-//line parser.y:142
+//line parser.y:165
 		{
-			snowpVAL.typ = List{Type: snowpDollar[3].typ}
+			snowpVAL.typ = List{BaseType: BaseType{Span: snowpDollar[1].span.Extend(snowpDollar[4].span)}, Type: snowpDollar[3].typ}
 		}
-	case 14:
+	case 15:
+		snowpDollar = snowpS[snowppt-6 : snowppt+1]
+//line parser.y:172
+		{
+			snowpVAL.typ = Map{BaseType: BaseType{Span: snowpDollar[1].span.Extend(snowpDollar[6].span)}, Key: snowpDollar[3].typ, Value: snowpDollar[5].typ}
+		}
+	case 16:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:148
+//line parser.y:178
 		{
 			var i int
 			i, err := strconv.Atoi(snowpDollar[1].rawval)
 			if err != nil {
-				parseErr = err
+				state(snowplex).setParseErr(snowpDollar[1].span, err)
 			} else if i < 0 {
-				parseErr = fmt.Errorf("blob byte-count must be greater than 0")
+				state(snowplex).setParseErr(snowpDollar[1].span, fmt.Errorf("blob byte-count must be greater than 0"))
 			} else {
 				snowpVAL.num = i
 			}
 		}
-	case 15:
-		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:162
-		{
-			snowpVAL.num = 0
-		}
-	case 16:
-		snowpDollar = snowpS[snowppt-3 : snowppt+1]
-//line parser.y:164
-		{
-			if snowpDollar[2].num <= 0 {
-				parseErr = fmt.Errorf("blob byte-count must be greater than 0")
-			} else {
-				snowpVAL.num = snowpDollar[2].num
-			}
-		}
 	case 17:
-		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:174
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:192
 		{
-			snowpVAL.typ = Blob{Count: snowpDollar[2].num}
+			snowpVAL.typ = Blob{BaseType: BaseType{Span: snowpDollar[1].span}}
 		}
 	case 18:
-		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:179
+		snowpDollar = snowpS[snowppt-4 : snowppt+1]
+//line parser.y:194
 		{
-			snowpVAL.typ = DerivedType{Name: snowpDollar[1].ident}
+			sp := snowpDollar[1].span.Extend(snowpDollar[4].span)
+			if snowpDollar[3].num <= 0 {
+				state(snowplex).setParseErr(sp, fmt.Errorf("blob byte-count must be greater than 0"))
+			}
+			snowpVAL.typ = Blob{BaseType: BaseType{Span: sp}, Count: snowpDollar[3].num}
 		}
 	case 19:
-		snowpDollar = snowpS[snowppt-3 : snowppt+1]
-//line parser.y:183
+		snowpDollar = snowpS[snowppt-4 : snowppt+1]
+//line parser.y:202
 		{
-			snowpVAL.typ = DerivedType{ImportedFrom: snowpDollar[1].ident, Name: snowpDollar[3].ident}
+			snowpVAL.typ = Blob{BaseType: BaseType{Span: snowpDollar[1].span.Extend(snowpDollar[4].span)}, CountConst: snowpDollar[3].ident}
 		}
 	case 20:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:190
+//line parser.y:209
 		{
-			snowpVAL.typ = Uint{}
+			snowpVAL.typ = DerivedType{BaseType: BaseType{Span: snowpDollar[1].ident.Span}, Name: snowpDollar[1].ident}
 		}
 	case 21:
-		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:191
+		snowpDollar = snowpS[snowppt-3 : snowppt+1]
+//line parser.y:213
 		{
-			snowpVAL.typ = Int{}
+			snowpVAL.typ = DerivedType{BaseType: BaseType{Span: snowpDollar[1].ident.Span.Extend(snowpDollar[3].ident.Span)}, ImportedFrom: snowpDollar[1].ident, Name: snowpDollar[3].ident}
 		}
 	case 22:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:192
+//line parser.y:220
 		{
-			snowpVAL.typ = Text{}
+			snowpVAL.typ = Uint{BaseType{Span: snowpDollar[1].span}}
 		}
 	case 23:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:193
+//line parser.y:221
 		{
-			snowpVAL.typ = Bool{}
+			snowpVAL.typ = Int{BaseType{Span: snowpDollar[1].span}}
 		}
 	case 24:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:194
+//line parser.y:222
 		{
-			snowpVAL.typ = snowpDollar[1].typ
+			snowpVAL.typ = Text{BaseType{Span: snowpDollar[1].span}}
 		}
 	case 25:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:195
+//line parser.y:223
 		{
-			snowpVAL.typ = snowpDollar[1].typ
+			snowpVAL.typ = Bool{BaseType{Span: snowpDollar[1].span}}
+		}
+	case 26:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:224
+		{
+			snowpVAL.typ = SizedInt{BaseType: BaseType{Span: snowpDollar[1].span}, Bits: 8}
+		}
+	case 27:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:225
+		{
+			snowpVAL.typ = SizedInt{BaseType: BaseType{Span: snowpDollar[1].span}, Bits: 16}
 		}
 	case 28:
-		snowpDollar = snowpS[snowppt-4 : snowppt+1]
-//line parser.y:204
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:226
+		{
+			snowpVAL.typ = SizedInt{BaseType: BaseType{Span: snowpDollar[1].span}, Bits: 32}
+		}
+	case 29:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:227
+		{
+			snowpVAL.typ = SizedInt{BaseType: BaseType{Span: snowpDollar[1].span}, Bits: 8, Signed: true}
+		}
+	case 30:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:228
 		{
-			snowpVAL.typ = Future{Type: snowpDollar[3].typ}
+			snowpVAL.typ = SizedInt{BaseType: BaseType{Span: snowpDollar[1].span}, Bits: 16, Signed: true}
 		}
 	case 31:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:229
+		{
+			snowpVAL.typ = SizedInt{BaseType: BaseType{Span: snowpDollar[1].span}, Bits: 32, Signed: true}
+		}
+	case 32:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:230
+		{
+			snowpVAL.typ = Float{BaseType: BaseType{Span: snowpDollar[1].span}, Bits: 32}
+		}
+	case 33:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:231
+		{
+			snowpVAL.typ = Float{BaseType: BaseType{Span: snowpDollar[1].span}, Bits: 64}
+		}
+	case 34:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:232
+		{
+			snowpVAL.typ = snowpDollar[1].typ
+		}
+	case 35:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:233
+		{
+			snowpVAL.typ = snowpDollar[1].typ
+		}
+	case 39:
+		snowpDollar = snowpS[snowppt-4 : snowppt+1]
+//line parser.y:244
+		{
+			snowpVAL.typ = Future{BaseType: BaseType{Span: snowpDollar[1].span.Extend(snowpDollar[4].span)}, Type: snowpDollar[3].typ}
+		}
+	case 42:
 		snowpDollar = snowpS[snowppt-7 : snowppt+1]
-//line parser.y:214
+//line parser.y:256
 		{
 			snowpVAL.stmt = Typedef{
 				BaseTypedef: BaseTypedef{
-					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec},
+					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec, Span: snowpDollar[2].span.Extend(snowpDollar[7].span)},
 					Ident:         snowpDollar[3].ident,
 					UniqueID:      snowpDollar[4].uniqueId,
 				},
 				Type: snowpDollar[6].typ,
 			}
 		}
-	case 32:
+	case 43:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:226
+//line parser.y:268
 		{
 			snowpVAL.num = snowpDollar[2].num
 		}
-	case 33:
+	case 44:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:229
+//line parser.y:271
 		{
 			snowpVAL.intp = nil
 		}
-	case 34:
+	case 45:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:231
+//line parser.y:273
 		{
 			tmp := snowpDollar[1].num
 			snowpVAL.intp = &tmp
 		}
-	case 35:
+	case 46:
 		snowpDollar = snowpS[snowppt-4 : snowppt+1]
-//line parser.y:239
+//line parser.y:281
 		{
-			snowpVAL.typ = Option{Type: snowpDollar[3].typ}
+			snowpVAL.typ = Option{BaseType: BaseType{Span: snowpDollar[1].span.Extend(snowpDollar[4].span)}, Type: snowpDollar[3].typ}
 		}
-	case 38:
+	case 49:
 		snowpDollar = snowpS[snowppt-5 : snowppt+1]
-//line parser.y:251
+//line parser.y:293
 		{
 			snowpVAL.field = Field{
 				Ident: snowpDollar[1].ident,
 				Pos:   snowpDollar[2].num,
 				Type:  snowpDollar[4].typ,
+				Span:  snowpDollar[1].ident.Span.Extend(snowpDollar[5].span),
 			}
 		}
-	case 39:
+	case 50:
+		snowpDollar = snowpS[snowppt-3 : snowppt+1]
+//line parser.y:304
+		{
+			snowpVAL.reserved = snowpDollar[2].reserved
+		}
+	case 51:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:308
+		{
+			snowpVAL.reserved = []Reserved{snowpDollar[1].rsv}
+		}
+	case 52:
+		snowpDollar = snowpS[snowppt-3 : snowppt+1]
+//line parser.y:309
+		{
+			snowpVAL.reserved = append(snowpDollar[1].reserved, snowpDollar[3].rsv)
+		}
+	case 53:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:314
+		{
+			pos := snowpDollar[1].num
+			snowpVAL.rsv = Reserved{Pos: &pos, Span: snowpDollar[1].span}
+		}
+	case 54:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:318
+		{
+			snowpVAL.rsv = Reserved{Name: snowpDollar[1].ident.Name, Span: snowpDollar[1].ident.Span}
+		}
+	case 55:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:261
+//line parser.y:328
+		{
+			snowpVAL.members = members{fields: []Field{}}
+		}
+	case 56:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:329
 		{
-			snowpVAL.fields = []Field{}
+			snowpVAL.members = snowpDollar[1].members
+			snowpVAL.members.fields = append(snowpVAL.members.fields, snowpDollar[2].field)
 		}
-	case 40:
+	case 57:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:262
+//line parser.y:330
 		{
-			snowpVAL.fields = append(snowpDollar[1].fields, snowpDollar[2].field)
+			snowpVAL.members = snowpDollar[1].members
+			snowpVAL.members.reserved = append(snowpVAL.members.reserved, snowpDollar[2].reserved...)
 		}
-	case 41:
+	case 58:
+		snowpDollar = snowpS[snowppt-3 : snowppt+1]
+//line parser.y:331
+		{
+			snowpVAL.members = snowpDollar[1].members
+			Errflag = 0
+		}
+	case 59:
 		snowpDollar = snowpS[snowppt-7 : snowppt+1]
-//line parser.y:267
+//line parser.y:336
 		{
 			snowpVAL.stmt = Struct{
 				BaseTypedef: BaseTypedef{
-					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec},
+					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec, Span: snowpDollar[2].span.Extend(snowpDollar[7].span)},
 					Ident:         snowpDollar[3].ident,
 					UniqueID:      snowpDollar[4].uniqueId,
 				},
-				Fields: snowpDollar[6].fields,
+				Fields:   snowpDollar[6].members.fields,
+				Reserved: snowpDollar[6].members.reserved,
 			}
 		}
-	case 42:
+	case 60:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:280
+//line parser.y:350
 		{
-			snowpVAL.cases = []Case{snowpDollar[1].cas}
+			snowpVAL.members = members{cases: []Case{snowpDollar[1].cas}}
 		}
-	case 43:
+	case 61:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:351
+		{
+			snowpVAL.members = members{reserved: snowpDollar[1].reserved}
+		}
+	case 62:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:281
+//line parser.y:352
 		{
-			snowpVAL.cases = append(snowpDollar[1].cases, snowpDollar[2].cas)
+			snowpVAL.members = members{}
+			Errflag = 0
 		}
-	case 44:
+	case 63:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:353
+		{
+			snowpVAL.members = snowpDollar[1].members
+			snowpVAL.members.cases = append(snowpVAL.members.cases, snowpDollar[2].cas)
+		}
+	case 64:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:354
+		{
+			snowpVAL.members = snowpDollar[1].members
+			snowpVAL.members.reserved = append(snowpVAL.members.reserved, snowpDollar[2].reserved...)
+		}
+	case 65:
+		snowpDollar = snowpS[snowppt-3 : snowppt+1]
+//line parser.y:355
+		{
+			snowpVAL.members = snowpDollar[1].members
+			Errflag = 0
+		}
+	case 66:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:285
+//line parser.y:359
 		{
 			snowpVAL.cas = snowpDollar[1].cas
 		}
-	case 45:
+	case 67:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:286
+//line parser.y:360
 		{
 			snowpVAL.cas = snowpDollar[1].cas
 		}
-	case 46:
+	case 68:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:290
+//line parser.y:364
 		{
 			snowpVAL.caseLabels = []CaseLabel{snowpDollar[1].caseLabel}
 		}
-	case 47:
+	case 69:
 		snowpDollar = snowpS[snowppt-3 : snowppt+1]
-//line parser.y:291
+//line parser.y:365
 		{
 			snowpVAL.caseLabels = append(snowpDollar[1].caseLabels, snowpDollar[3].caseLabel)
 		}
-	case 48:
+	case 70:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:295
+//line parser.y:369
 		{
 			snowpVAL.caseLabel = CaseLabelIdentifier{Ident: snowpDollar[1].ident}
 		}
-	case 49:
+	case 71:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:296
+//line parser.y:370
 		{
-			snowpVAL.caseLabel = CaseLabelNumber{Num: snowpDollar[1].num}
+			snowpVAL.caseLabel = CaseLabelNumber{Num: snowpDollar[1].num, Span: snowpDollar[1].span}
 		}
-	case 50:
+	case 72:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:297
+//line parser.y:371
 		{
-			snowpVAL.caseLabel = CaseLabelBool{Bool: true}
+			snowpVAL.caseLabel = CaseLabelBool{Bool: true, Span: snowpDollar[1].span}
 		}
-	case 51:
+	case 73:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:298
+//line parser.y:372
 		{
-			snowpVAL.caseLabel = CaseLabelBool{Bool: false}
+			snowpVAL.caseLabel = CaseLabelBool{Bool: false, Span: snowpDollar[1].span}
 		}
-	case 52:
+	case 74:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:302
+//line parser.y:376
 		{
 			snowpVAL.typ = snowpDollar[1].typ
 		}
-	case 53:
+	case 75:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:303
+//line parser.y:377
 		{
-			snowpVAL.typ = Void{}
+			snowpVAL.typ = Void{BaseType{Span: snowpDollar[1].span}}
 		}
-	case 54:
+	case 76:
 		snowpDollar = snowpS[snowppt-6 : snowppt+1]
-//line parser.y:308
+//line parser.y:382
 		{
 			snowpVAL.cas = Case{
 				Labels:   snowpDollar[2].caseLabels,
 				Position: snowpDollar[3].intp,
 				Type:     snowpDollar[5].typ,
+				Span:     snowpDollar[1].span.Extend(snowpDollar[6].span),
 			}
 		}
-	case 55:
+	case 77:
 		snowpDollar = snowpS[snowppt-5 : snowppt+1]
-//line parser.y:319
+//line parser.y:394
 		{
 			snowpVAL.cas = Case{
 				Labels:   nil,
 				Position: snowpDollar[2].intp,
 				Type:     snowpDollar[4].typ,
+				Span:     snowpDollar[1].span.Extend(snowpDollar[5].span),
 			}
 		}
-	case 56:
+	case 78:
 		snowpDollar = snowpS[snowppt-13 : snowppt+1]
-//line parser.y:332
+//line parser.y:408
 		{
 			snowpVAL.stmt = Variant{
 				BaseTypedef: BaseTypedef{
-					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec},
+					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec, Span: snowpDollar[2].span.Extend(snowpDollar[13].span)},
 					Ident:         snowpDollar[3].ident,
 					UniqueID:      snowpDollar[10].uniqueId,
 				},
 				SwitchVar:  snowpDollar[6].ident,
 				SwitchType: snowpDollar[8].typ,
-				Cases:      snowpDollar[12].cases,
+				Cases:      snowpDollar[12].members.cases,
+				Reserved:   snowpDollar[12].members.reserved,
 			}
 		}
-	case 57:
+	case 79:
 		snowpDollar = snowpS[snowppt-4 : snowppt+1]
-//line parser.y:348
+//line parser.y:425
 		{
 			snowpVAL.enumValue = EnumValue{
 				Ident: snowpDollar[1].ident,
 				Num:   snowpDollar[3].num,
+				Span:  snowpDollar[1].ident.Span.Extend(snowpDollar[4].span),
 			}
 		}
-	case 58:
+	case 80:
+		snowpDollar = snowpS[snowppt-4 : snowppt+1]
+//line parser.y:433
+		{
+			snowpVAL.enumValue = EnumValue{
+				Ident:    snowpDollar[1].ident,
+				NumConst: snowpDollar[3].ident,
+				Span:     snowpDollar[1].ident.Span.Extend(snowpDollar[4].span),
+			}
+		}
+	case 81:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:357
+//line parser.y:443
 		{
 			snowpVAL.enumValues = []EnumValue{snowpDollar[1].enumValue}
 		}
-	case 59:
+	case 82:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:444
+		{
+			snowpVAL.enumValues = nil
+			Errflag = 0
+		}
+	case 83:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:358
+//line parser.y:445
 		{
 			snowpVAL.enumValues = append(snowpDollar[1].enumValues, snowpDollar[2].enumValue)
 		}
-	case 60:
+	case 84:
+		snowpDollar = snowpS[snowppt-3 : snowppt+1]
+//line parser.y:446
+		{
+			snowpVAL.enumValues = snowpDollar[1].enumValues
+			Errflag = 0
+		}
+	case 85:
 		snowpDollar = snowpS[snowppt-6 : snowppt+1]
-//line parser.y:363
+//line parser.y:451
 		{
 			snowpVAL.stmt = Enum{
 				BaseTypedef: BaseTypedef{
-					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec},
+					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec, Span: snowpDollar[2].span.Extend(snowpDollar[6].span)},
 					Ident:         snowpDollar[3].ident,
 				},
 				Values: snowpDollar[5].enumValues,
 			}
 		}
-	case 61:
+	case 86:
+		snowpDollar = snowpS[snowppt-8 : snowppt+1]
+//line parser.y:464
+		{
+			snowpVAL.stmt = Const{
+				BaseStatement: BaseStatement{Dec: snowpDollar[1].dec, Span: snowpDollar[2].span.Extend(snowpDollar[8].span)},
+				Ident:         snowpDollar[3].ident,
+				Type:          snowpDollar[5].typ,
+				Value:         snowpDollar[7].literal,
+			}
+		}
+	case 87:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:475
+		{
+			snowpVAL.literal = Literal{Kind: LiteralInt, Raw: snowpDollar[1].rawval, Span: snowpDollar[1].span}
+		}
+	case 88:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:476
+		{
+			snowpVAL.literal = Literal{Kind: LiteralHex, Raw: snowpDollar[1].rawval, Span: snowpDollar[1].span}
+		}
+	case 89:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:477
+		{
+			snowpVAL.literal = Literal{Kind: LiteralText, Raw: snowpDollar[1].rawval, Span: snowpDollar[1].span}
+		}
+	case 90:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:478
+		{
+			snowpVAL.literal = Literal{Kind: LiteralBool, Raw: "true", Span: snowpDollar[1].span}
+		}
+	case 91:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:479
+		{
+			snowpVAL.literal = Literal{Kind: LiteralBool, Raw: "false", Span: snowpDollar[1].span}
+		}
+	case 92:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:375
+//line parser.y:483
 		{
 			snowpVAL.imprt = snowpDollar[1].imprt
 		}
-	case 62:
+	case 93:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:376
+//line parser.y:484
 		{
 			snowpVAL.imprt = snowpDollar[1].imprt
 		}
-	case 63:
+	case 94:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:377
+//line parser.y:485
 		{
 			snowpVAL.imprt = snowpDollar[1].imprt
 		}
-	case 64:
+	case 95:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:381
+//line parser.y:489
 		{
 			snowpVAL.stmt = snowpDollar[1].imprt
 		}
-	case 65:
+	case 96:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:382
+//line parser.y:490
 		{
 			snowpVAL.stmt = snowpDollar[1].stmt
 		}
-	case 66:
+	case 97:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:383
+//line parser.y:491
 		{
 			snowpVAL.stmt = snowpDollar[1].stmt
 		}
-	case 67:
+	case 98:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:384
+//line parser.y:492
 		{
 			snowpVAL.stmt = snowpDollar[1].stmt
 		}
-	case 68:
+	case 99:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:385
+//line parser.y:493
 		{
 			snowpVAL.stmt = snowpDollar[1].stmt
 		}
-	case 69:
+	case 100:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:386
+//line parser.y:494
 		{
 			snowpVAL.stmt = snowpDollar[1].stmt
 		}
-	case 70:
+	case 101:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:390
+//line parser.y:495
 		{
-			snowpVAL.ident = Identifier{Name: snowpDollar[1].rawval}
+			snowpVAL.stmt = snowpDollar[1].stmt
 		}
-	case 71:
+	case 102:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:496
+		{
+			snowpVAL.stmt = snowpDollar[1].stmt
+		}
+	case 103:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:394
+//line parser.y:497
+		{
+			snowpVAL.stmt = nil
+		}
+	case 104:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:498
+		{
+			snowpVAL.stmt = nil
+			Errflag = 0
+		}
+	case 105:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:502
+		{
+			snowpVAL.ident = Identifier{Name: snowpDollar[1].rawval, Span: snowpDollar[1].span}
+		}
+	case 106:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:506
 		{
 			snowpVAL.uniqueId = snowpDollar[1].uniqueId
 		}
-	case 72:
+	case 107:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:398
+//line parser.y:510
 		{
 			snowpVAL.rawval = snowpDollar[1].rawval
 		}
-	case 73:
+	case 108:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:399
+//line parser.y:511
 		{
 			snowpVAL.rawval = snowpDollar[1].rawval
 		}
-	case 74:
+	case 109:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:515
+		{
+			snowpVAL.rawval = snowpDollar[1].rawval
+		}
+	case 110:
+		snowpDollar = snowpS[snowppt-1 : snowppt+1]
+//line parser.y:516
+		{
+			snowpVAL.rawval = snowpDollar[1].rawval
+		}
+	case 111:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:403
+//line parser.y:520
 		{
-			snowpVAL.uniqueId = UniqueID{Val: snowpDollar[2].rawval}
+			snowpVAL.uniqueId = UniqueID{Val: snowpDollar[2].rawval, Span: snowpDollar[1].span.Extend(snowpDollar[2].span)}
 		}
-	case 75:
+	case 112:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:522
+		{
+			if snowpDollar[2].ident.Name != "new" {
+				state(snowplex).setParseErr(snowpDollar[2].ident.Span,
+					fmt.Errorf("unique ID must be a 64- or 32-bit hex number, or `new`"))
+			}
+			snowpVAL.uniqueId = UniqueID{Pending: true, Span: snowpDollar[1].span.Extend(snowpDollar[2].ident.Span)}
+		}
+	case 113:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:407
+//line parser.y:532
 		{
-			snowpVAL.protoModifier = Errors{Type: snowpDollar[2].typ}
+			snowpVAL.protoModifier = Errors{Type: snowpDollar[2].typ, Span: snowpDollar[1].span.Extend(snowpDollar[2].typ.GetSpan())}
 		}
-	case 76:
+	case 114:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:408
+//line parser.y:533
 		{
-			snowpVAL.protoModifier = ArgHeader{Type: snowpDollar[2].typ}
+			snowpVAL.protoModifier = ArgHeader{Type: snowpDollar[2].typ, Span: snowpDollar[1].span.Extend(snowpDollar[2].typ.GetSpan())}
 		}
-	case 77:
+	case 115:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:409
+//line parser.y:534
 		{
-			snowpVAL.protoModifier = ResHeader{Type: snowpDollar[2].typ}
+			snowpVAL.protoModifier = ResHeader{Type: snowpDollar[2].typ, Span: snowpDollar[1].span.Extend(snowpDollar[2].typ.GetSpan())}
 		}
-	case 78:
+	case 116:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:413
+//line parser.y:538
 		{
 			snowpVAL.protoModifiers = nil
 		}
-	case 79:
+	case 117:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:414
+//line parser.y:539
 		{
 			snowpVAL.protoModifiers = append(snowpDollar[1].protoModifiers, snowpDollar[2].protoModifier)
 		}
-	case 80:
+	case 118:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:418
+//line parser.y:543
 		{
 			snowpVAL.ident = Identifier{}
 		}
-	case 81:
+	case 119:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:419
+//line parser.y:544
 		{
 			snowpVAL.ident = snowpDollar[2].ident
 		}
-	case 82:
+	case 120:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:423
+//line parser.y:548
 		{
 			snowpVAL.params = nil
 		}
-	case 84:
+	case 122:
 		snowpDollar = snowpS[snowppt-1 : snowppt+1]
-//line parser.y:428
+//line parser.y:553
 		{
 			snowpVAL.params = []Param{snowpDollar[1].param}
 		}
-	case 85:
+	case 123:
 		snowpDollar = snowpS[snowppt-3 : snowppt+1]
-//line parser.y:429
+//line parser.y:554
 		{
 			snowpVAL.params = append(snowpDollar[1].params, snowpDollar[3].param)
 		}
-	case 86:
+	case 124:
 		snowpDollar = snowpS[snowppt-4 : snowppt+1]
-//line parser.y:434
+//line parser.y:559
 		{
 			snowpVAL.param = Param{
 				Ident: snowpDollar[1].ident,
 				Pos:   snowpDollar[2].num,
 				Type:  snowpDollar[4].typ,
+				Span:  snowpDollar[1].ident.Span.Extend(snowpDollar[4].typ.GetSpan()),
 			}
 		}
-	case 87:
+	case 125:
 		snowpDollar = snowpS[snowppt-3 : snowppt+1]
-//line parser.y:445
+//line parser.y:571
 		{
 			snowpVAL.params = snowpDollar[2].params
 		}
-	case 88:
+	case 126:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:449
+//line parser.y:575
 		{
 			snowpVAL.typ = Void{}
 		}
-	case 89:
+	case 127:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:450
+//line parser.y:576
 		{
 			snowpVAL.typ = snowpDollar[2].typ
 		}
-	case 90:
+	case 128:
 		snowpDollar = snowpS[snowppt-7 : snowppt+1]
-//line parser.y:455
+//line parser.y:581
 		{
 			snowpVAL.method = Method{
 				BaseTypedef: BaseTypedef{
-					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec},
+					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec, Span: snowpDollar[2].ident.Span.Extend(snowpDollar[7].span)},
 					Ident:         snowpDollar[2].ident,
 				},
 				Pos:     snowpDollar[3].num,
@@ -1205,25 +1551,40 @@ snowpdefault:
 				ResType: snowpDollar[6].typ,
 			}
 		}
-	case 91:
+	case 129:
 		snowpDollar = snowpS[snowppt-0 : snowppt+1]
-//line parser.y:470
+//line parser.y:596
 		{
-			snowpVAL.methods = nil
+			snowpVAL.members = members{}
 		}
-	case 92:
+	case 130:
 		snowpDollar = snowpS[snowppt-2 : snowppt+1]
-//line parser.y:471
+//line parser.y:597
 		{
-			snowpVAL.methods = append(snowpDollar[1].methods, snowpDollar[2].method)
+			snowpVAL.members = snowpDollar[1].members
+			snowpVAL.members.methods = append(snowpVAL.members.methods, snowpDollar[2].method)
 		}
-	case 93:
+	case 131:
+		snowpDollar = snowpS[snowppt-2 : snowppt+1]
+//line parser.y:598
+		{
+			snowpVAL.members = snowpDollar[1].members
+			snowpVAL.members.reserved = append(snowpVAL.members.reserved, snowpDollar[2].reserved...)
+		}
+	case 132:
+		snowpDollar = snowpS[snowppt-3 : snowppt+1]
+//line parser.y:599
+		{
+			snowpVAL.members = snowpDollar[1].members
+			Errflag = 0
+		}
+	case 133:
 		snowpDollar = snowpS[snowppt-8 : snowppt+1]
-//line parser.y:478
+//line parser.y:606
 		{
 			pmsp, err := NewProtocolModifiers(snowpDollar[4].protoModifiers)
 			if err != nil {
-				parseErr = err
+				state(snowplex).setParseErr(snowpDollar[3].ident.Span, err)
 			}
 			var pms ProtocolModifiers
 			if pmsp != nil {
@@ -1231,12 +1592,13 @@ snowpdefault:
 			}
 			snowpVAL.stmt = Protocol{
 				BaseTypedef: BaseTypedef{
-					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec},
+					BaseStatement: BaseStatement{Dec: snowpDollar[1].dec, Span: snowpDollar[2].span.Extend(snowpDollar[8].span)},
 					Ident:         snowpDollar[3].ident,
 					UniqueID:      snowpDollar[5].uniqueId,
 				},
 				Modifiers: pms,
-				Methods:   snowpDollar[7].methods,
+				Methods:   snowpDollar[7].members.methods,
+				Reserved:  snowpDollar[7].members.reserved,
 			}
 		}
 	}
@@ -7,6 +7,15 @@ import (
     "strconv"
 )
 
+// members is the body of a struct, variant or protocol, along with any
+// reserved clauses mixed in with it.
+type members struct {
+    fields   []Field
+    cases    []Case
+    methods  []Method
+    reserved []Reserved
+}
+
 %}
 
 %union {
@@ -19,42 +28,42 @@ import (
     imprt    Import
     dec      Decorators
     doc      Docstring
-    docRaw   string
     ident    Identifier
     typ      Type
     num      int
     field    Field
-    fields   []Field
-    cases    []Case
+    members  members
+    reserved []Reserved
+    rsv      Reserved
     cas      Case
     intp     *int
     caseLabel  CaseLabel
     caseLabels []CaseLabel
     enumValues []EnumValue
     enumValue  EnumValue
+    literal    Literal
     protoModifiers []ProtocolModifier
     protoModifier  ProtocolModifier
     params []Param
     param Param
     method Method
-    methods []Method
-
+    span   Span
 }
 
 %type <root> top
 %type <uniqueId> fileID uniqueID uniqueIDOpt
 %type <stmts> statements
-%type <stmt> statement typedef struct variant enum protocol
+%type <stmt> statement typedef struct variant enum protocol const goPackage
 %type <imprt> import genericImport tsImport goImport
 %type <dec> decorators
-%type <doc> doc 
-%type <docRaw> docRaw
+%type <doc> doc docRaw
 %type <ident> identifier argTypeOpt
-%type <typ> list type simpleType typeOrFuture blob dottedIdentifier future typeOrOptional optionalType typeOrVoid returnOpt
-%type <num> countOpt number position
+%type <typ> list map type simpleType typeOrFuture blob dottedIdentifier future typeOrOptional optionalType typeOrVoid returnOpt
+%type <num> number position
 %type <field> field
-%type <fields> fields
-%type <cases> cases
+%type <members> fields cases methods
+%type <reserved> reserved reservedItems
+%type <rsv> reservedItem
 %type <cas> case normalCase defaultCase
 %type <caseLabel> caseLabel
 %type <caseLabels> caseLabels
@@ -65,19 +74,20 @@ import (
 %type <params> paramList paramsOpt params
 %type <param> param
 %type <intp> positionOpt
-%type <rawval> uintConstant
+%type <rawval> uintConstant hexConstant
+%type <literal> literal
 %type <method> method
-%type <methods> methods
 
 %token TokenAt TokenSemicolon TokenAs TokenEquals TokenDot
-%token TokenImport TokenTypeScriptImport TokenGoImport
-%token TokenList TokenLParen TokenRParen TokenText TokenUint TokenInt TokenBool TokenBlob TokenFuture
+%token TokenImport TokenTypeScriptImport TokenGoImport TokenGoPackage
+%token TokenList TokenMap TokenLParen TokenRParen TokenText TokenUint TokenInt TokenBool TokenBlob TokenFuture
+%token TokenUint8 TokenUint16 TokenUint32 TokenInt8 TokenInt16 TokenInt32 TokenFloat32 TokenFloat64
 %token TokenLBrace TokenRBrace TokenStruct TokenOption TokenColon TokenVariant TokenSwitch TokenCase
-%token TokenTrue TokenFalse TokenDefault TokenVoid TokenEnum 
-%token TokenProtocol TokenErrors TokenArgHeader TokenResHeader
+%token TokenTrue TokenFalse TokenDefault TokenVoid TokenEnum TokenConst
+%token TokenProtocol TokenErrors TokenArgHeader TokenResHeader TokenReserved
 %token TokenArrow TokenComma
 
-%token <rawval> TokenUint64Val TokenIntVal TokenUint32Val 
+%token <rawval> TokenUint64Val TokenIntVal TokenUint32Val TokenHexVal
 %token <rawval> TokenDQoutedString TokenIdentifier TokenDoc TokenTypedef 
 
 %%
@@ -86,46 +96,59 @@ top:
     fileID statements
     {
         $$ = Root{ Id: $1, Stmts : $2 }
-        top = &$$ // Set the global top variable
+        state(snowplex).setRoot($$)
     }
     ;
 
 statements:
     /* empty */ { $$ = []Statement{} }
-    | statements statement { $$ = append($1, $2) }
+    | statements statement
+    {
+        $$ = $1
+        if $2 != nil {
+            $$ = append($$, $2)
+        }
+    }
     ;
 
 genericImport: 
     TokenImport TokenDQoutedString TokenAs TokenIdentifier TokenSemicolon
     { 
-        $$ = Import{ Path: $2, Name : $4, Lang : LangGeneric }
+        $$ = Import{ Path: $2, Name : $4, Lang : LangGeneric, Span : $<span>1.Extend($<span>5) }
     }
     ;
 
 tsImport: 
     TokenTypeScriptImport TokenDQoutedString TokenAs TokenIdentifier TokenSemicolon
     { 
-        $$ = Import { Path: $2, Name : $4, Lang : LangTypeScript }
+        $$ = Import { Path: $2, Name : $4, Lang : LangTypeScript, Span : $<span>1.Extend($<span>5) }
     } 
     ;
 
 goImport: 
     TokenGoImport TokenDQoutedString TokenAs TokenIdentifier TokenSemicolon
     { 
-        $$ = Import { Path: $2, Name : $4, Lang : LangGo }
+        $$ = Import { Path: $2, Name : $4, Lang : LangGo, Span : $<span>1.Extend($<span>5) }
     } 
     ;
 
+goPackage:
+    TokenGoPackage identifier TokenSemicolon
+    {
+        $$ = GoPackage{ Name: $2, Span: $<span>1.Extend($<span>3) }
+    }
+    ;
+
 doc : 
     docRaw
     {
-        $$ = Docstring{ Raw: $1 }
+        $$ = $1
     }
     ;
 
 docRaw
-    : { $$ = "" }
-    | docRaw TokenDoc { $$ = $1 + $2; }
+    : { $$ = Docstring{} }
+    | docRaw TokenDoc { $$ = Docstring{ Raw: $1.Raw + $2, Span: $1.Span.Extend($<span>2) } }
     ;
 
 decorators:
@@ -140,7 +163,14 @@ uniqueIDOpt
 list:
     TokenList TokenLParen type TokenRParen
     {
-        $$ = List{ Type: $3 }
+        $$ = List{ BaseType: BaseType{ Span: $<span>1.Extend($<span>4) }, Type: $3 }
+    }
+    ;
+
+map:
+    TokenMap TokenLParen simpleType TokenComma type TokenRParen
+    {
+        $$ = Map{ BaseType: BaseType{ Span: $<span>1.Extend($<span>6) }, Key: $3, Value: $5 }
     }
     ;
 
@@ -149,48 +179,56 @@ number: TokenIntVal
         var i int
         i, err := strconv.Atoi($1)
         if err != nil {
-            parseErr = err
+            state(snowplex).setParseErr($<span>1, err)
         } else if i < 0 {
-            parseErr = fmt.Errorf("blob byte-count must be greater than 0")
+            state(snowplex).setParseErr($<span>1, fmt.Errorf("blob byte-count must be greater than 0"))
         } else {
             $$ = i
         }
     }
     ;
 
-countOpt:
-    /* empty */ { $$ = 0 }
-    | TokenLParen number TokenRParen
+blob
+    : TokenBlob { $$ = Blob{ BaseType: BaseType{ Span: $<span>1 } } }
+    | TokenBlob TokenLParen number TokenRParen
     {
-        if $2 <= 0 {
-            parseErr = fmt.Errorf("blob byte-count must be greater than 0")
-        } else {
-            $$ = $2
+        sp := $<span>1.Extend($<span>4)
+        if $3 <= 0 {
+            state(snowplex).setParseErr(sp, fmt.Errorf("blob byte-count must be greater than 0"))
         }
+        $$ = Blob{ BaseType: BaseType{ Span: sp }, Count: $3 }
+    }
+    | TokenBlob TokenLParen identifier TokenRParen
+    {
+        $$ = Blob{ BaseType: BaseType{ Span: $<span>1.Extend($<span>4) }, CountConst: $3 }
     }
-    ;
-
-blob
-    : TokenBlob countOpt { $$ = Blob{ Count: $2 } }
     ;
 
 dottedIdentifier:
     identifier
     {
-        $$ = DerivedType{ Name : $1 }
+        $$ = DerivedType{ BaseType: BaseType{ Span: $1.Span }, Name : $1 }
     }
     | identifier TokenDot identifier
     {
-        $$ = DerivedType{ ImportedFrom : $1, Name : $3 }
+        $$ = DerivedType{ BaseType: BaseType{ Span: $1.Span.Extend($3.Span) }, ImportedFrom : $1, Name : $3 }
     }
     ;
 
 
 simpleType
-    : TokenUint { $$ = Uint{} }
-    | TokenInt  { $$ = Int{} }
-    | TokenText { $$ = Text{} }
-    | TokenBool { $$ = Bool{} }
+    : TokenUint { $$ = Uint{ BaseType{ Span: $<span>1 } } }
+    | TokenInt  { $$ = Int{ BaseType{ Span: $<span>1 } } }
+    | TokenText { $$ = Text{ BaseType{ Span: $<span>1 } } }
+    | TokenBool { $$ = Bool{ BaseType{ Span: $<span>1 } } }
+    | TokenUint8  { $$ = SizedInt{ BaseType: BaseType{ Span: $<span>1 }, Bits: 8 } }
+    | TokenUint16 { $$ = SizedInt{ BaseType: BaseType{ Span: $<span>1 }, Bits: 16 } }
+    | TokenUint32 { $$ = SizedInt{ BaseType: BaseType{ Span: $<span>1 }, Bits: 32 } }
+    | TokenInt8   { $$ = SizedInt{ BaseType: BaseType{ Span: $<span>1 }, Bits: 8, Signed: true } }
+    | TokenInt16  { $$ = SizedInt{ BaseType: BaseType{ Span: $<span>1 }, Bits: 16, Signed: true } }
+    | TokenInt32  { $$ = SizedInt{ BaseType: BaseType{ Span: $<span>1 }, Bits: 32, Signed: true } }
+    | TokenFloat32 { $$ = Float{ BaseType: BaseType{ Span: $<span>1 }, Bits: 32 } }
+    | TokenFloat64 { $$ = Float{ BaseType: BaseType{ Span: $<span>1 }, Bits: 64 } }
     | blob      { $$ = $1 }
     | dottedIdentifier { $$ = $1 }
     ; 
@@ -198,10 +236,14 @@ simpleType
 type:
     simpleType
     | list
+    | map
     ;
 
 future
-    : TokenFuture TokenLParen simpleType TokenRParen { $$ = Future{ Type: $3 } }
+    : TokenFuture TokenLParen simpleType TokenRParen
+    {
+        $$ = Future{ BaseType: BaseType{ Span: $<span>1.Extend($<span>4) }, Type: $3 }
+    }
     ;
 
 typeOrFuture
@@ -214,7 +256,7 @@ typedef:
     {
         $$ = Typedef{
             BaseTypedef : BaseTypedef{
-                BaseStatement: BaseStatement{ Dec : $1 }, 
+                BaseStatement: BaseStatement{ Dec : $1, Span : $<span>2.Extend($<span>7) }, 
                 Ident : $3, 
                 UniqueID : $4,
             },
@@ -237,7 +279,7 @@ positionOpt
 optionalType
     : TokenOption TokenLParen type TokenRParen
     {
-        $$ = Option{ Type: $3 }
+        $$ = Option{ BaseType: BaseType{ Span: $<span>1.Extend($<span>4) }, Type: $3 }
     }
     ;
 
@@ -253,13 +295,40 @@ field:
             Ident : $1,
             Pos : $2,
             Type : $4,
+            Span : $1.Span.Extend($<span>5),
         }
     }
     ;
 
+reserved:
+    TokenReserved reservedItems TokenSemicolon { $$ = $2 }
+    ;
+
+reservedItems
+    : reservedItem { $$ = []Reserved{ $1 } }
+    | reservedItems TokenComma reservedItem { $$ = append($1, $3) }
+    ;
+
+reservedItem
+    : position
+    {
+        pos := $1
+        $$ = Reserved{ Pos: &pos, Span: $<span>1 }
+    }
+    | identifier { $$ = Reserved{ Name: $1.Name, Span: $1.Span } }
+    ;
+
+/*
+ * A member that doesn't parse is skipped up to its semicolon, and a
+ * statement up to its closing brace. Setting Errflag to 0 after either
+ * tells the parser that it's back in step, so that the next error is
+ * reported too, rather than dropped if it comes within three tokens.
+ */
 fields 
-    : /* empty */ { $$ = []Field{} }
-    | fields field { $$ = append($1, $2) }
+    : /* empty */ { $$ = members{ fields: []Field{} } }
+    | fields field { $$ = $1; $$.fields = append($$.fields, $2) }
+    | fields reserved { $$ = $1; $$.reserved = append($$.reserved, $2...) }
+    | fields error TokenSemicolon { $$ = $1; Errflag = 0 }
     ;
 
 struct:
@@ -267,18 +336,23 @@ struct:
     {
         $$ = Struct{
             BaseTypedef : BaseTypedef{
-                BaseStatement: BaseStatement{ Dec : $1 }, 
+                BaseStatement: BaseStatement{ Dec : $1, Span : $<span>2.Extend($<span>7) }, 
                 Ident : $3, 
                 UniqueID : $4,
             },
-            Fields : $6,
+            Fields : $6.fields,
+            Reserved : $6.reserved,
         }
     }
     ;
 
 cases
-    : case { $$ = []Case{ $1 } }
-    | cases case { $$ = append($1, $2) }
+    : case { $$ = members{ cases: []Case{ $1 } } }
+    | reserved { $$ = members{ reserved: $1 } }
+    | error TokenSemicolon { $$ = members{}; Errflag = 0 }
+    | cases case { $$ = $1; $$.cases = append($$.cases, $2) }
+    | cases reserved { $$ = $1; $$.reserved = append($$.reserved, $2...) }
+    | cases error TokenSemicolon { $$ = $1; Errflag = 0 }
     ;
 
 case
@@ -293,14 +367,14 @@ caseLabels
 
 caseLabel 
     : identifier { $$ = CaseLabelIdentifier{ Ident: $1 } }
-    | number { $$ = CaseLabelNumber{ Num: $1 } }
-    | TokenTrue { $$ = CaseLabelBool{ Bool: true } }
-    | TokenFalse { $$ = CaseLabelBool{ Bool: false } }
+    | number { $$ = CaseLabelNumber{ Num: $1, Span: $<span>1 } }
+    | TokenTrue { $$ = CaseLabelBool{ Bool: true, Span: $<span>1 } }
+    | TokenFalse { $$ = CaseLabelBool{ Bool: false, Span: $<span>1 } }
     ;
 
 typeOrVoid
     : type { $$ = $1 }
-    | TokenVoid { $$ = Void{} }
+    | TokenVoid { $$ = Void{ BaseType{ Span: $<span>1 } } }
     ;
 
 normalCase
@@ -310,6 +384,7 @@ normalCase
             Labels : $2,
             Position : $3,
             Type : $5,
+            Span : $<span>1.Extend($<span>6),
         }
     }
     ;
@@ -321,6 +396,7 @@ defaultCase
             Labels : nil,
             Position : $2,
             Type : $4,
+            Span : $<span>1.Extend($<span>5),
         }
     }
     ;
@@ -332,13 +408,14 @@ variant:
     {
         $$ = Variant{
             BaseTypedef : BaseTypedef{
-                BaseStatement: BaseStatement{ Dec : $1 }, 
+                BaseStatement: BaseStatement{ Dec : $1, Span : $<span>2.Extend($<span>13) }, 
                 Ident : $3, 
                 UniqueID : $10,
             },
             SwitchVar : $6,
             SwitchType : $8,
-            Cases : $12,
+            Cases : $12.cases,
+            Reserved : $12.reserved,
         }
     }
     ;
@@ -349,13 +426,24 @@ enumValue
         $$ = EnumValue{
             Ident : $1,
             Num : $3,
+            Span : $1.Span.Extend($<span>4),
+        }
+    }
+    | identifier TokenAt identifier TokenSemicolon
+    {
+        $$ = EnumValue{
+            Ident : $1,
+            NumConst : $3,
+            Span : $1.Span.Extend($<span>4),
         }
     }
     ;
 
 enumValues
     : enumValue { $$ = []EnumValue{ $1 } }
+    | error TokenSemicolon { $$ = nil; Errflag = 0 }
     | enumValues enumValue { $$ = append($1, $2) }
+    | enumValues error TokenSemicolon { $$ = $1; Errflag = 0 }
     ;
 
 enum: 
@@ -363,7 +451,7 @@ enum:
     {
         $$ = Enum{
             BaseTypedef : BaseTypedef{
-                BaseStatement: BaseStatement{ Dec : $1 }, 
+                BaseStatement: BaseStatement{ Dec : $1, Span : $<span>2.Extend($<span>6) }, 
                 Ident : $3, 
             },
             Values : $5,
@@ -371,6 +459,26 @@ enum:
     }
     ;
 
+const:
+    decorators TokenConst identifier TokenColon simpleType TokenEquals literal TokenSemicolon
+    {
+        $$ = Const{
+            BaseStatement: BaseStatement{ Dec : $1, Span : $<span>2.Extend($<span>8) },
+            Ident : $3,
+            Type : $5,
+            Value : $7,
+        }
+    }
+    ;
+
+literal
+    : TokenIntVal { $$ = Literal{ Kind: LiteralInt, Raw: $1, Span: $<span>1 } }
+    | hexConstant { $$ = Literal{ Kind: LiteralHex, Raw: $1, Span: $<span>1 } }
+    | TokenDQoutedString { $$ = Literal{ Kind: LiteralText, Raw: $1, Span: $<span>1 } }
+    | TokenTrue { $$ = Literal{ Kind: LiteralBool, Raw: "true", Span: $<span>1 } }
+    | TokenFalse { $$ = Literal{ Kind: LiteralBool, Raw: "false", Span: $<span>1 } }
+    ;
+
 import: 
     genericImport { $$ = $1 }
     | tsImport    { $$ = $1 }
@@ -384,10 +492,14 @@ statement
     | variant  { $$ = $1 }
     | enum     { $$ = $1 }
     | protocol { $$ = $1 }
+    | const    { $$ = $1 }
+    | goPackage { $$ = $1 }
+    | error TokenSemicolon { $$ = nil }
+    | error TokenRBrace { $$ = nil; Errflag = 0 }
     ;
 
 identifier:
-    TokenIdentifier { $$ = Identifier{ Name : $1 } }
+    TokenIdentifier { $$ = Identifier{ Name : $1, Span : $<span>1 } }
     ;
 
 fileID:
@@ -399,14 +511,27 @@ uintConstant
     | TokenUint32Val { $$ = $1 }
     ;
 
-uniqueID:
-    TokenAt uintConstant { $$ = UniqueID{ Val: $2 } }
+hexConstant
+    : uintConstant { $$ = $1 }
+    | TokenHexVal  { $$ = $1 }
+    ;
+
+uniqueID
+    : TokenAt uintConstant { $$ = UniqueID{ Val: $2, Span: $<span>1.Extend($<span>2) } }
+    | TokenAt identifier
+    {
+        if $2.Name != "new" {
+            state(snowplex).setParseErr($2.Span,
+                fmt.Errorf("unique ID must be a 64- or 32-bit hex number, or `new`"))
+        }
+        $$ = UniqueID{ Pending: true, Span: $<span>1.Extend($2.Span) }
+    }
     ;
 
 protoModifier
-    : TokenErrors type    { $$ = Errors{ Type: $2 } }
-    | TokenArgHeader type { $$ = ArgHeader{ Type: $2 } }
-    | TokenResHeader type { $$ = ResHeader{ Type: $2 } }
+    : TokenErrors type    { $$ = Errors{ Type: $2, Span: $<span>1.Extend($2.GetSpan()) } }
+    | TokenArgHeader type { $$ = ArgHeader{ Type: $2, Span: $<span>1.Extend($2.GetSpan()) } }
+    | TokenResHeader type { $$ = ResHeader{ Type: $2, Span: $<span>1.Extend($2.GetSpan()) } }
     ;
 
 protoModifiers
@@ -436,6 +561,7 @@ param
             Ident : $1,
             Pos : $2,
             Type : $4,
+            Span : $1.Span.Extend($4.GetSpan()),
         }
     }
     ;
@@ -455,7 +581,7 @@ method
         {
             $$ = Method{
                 BaseTypedef : BaseTypedef{
-                    BaseStatement: BaseStatement{ Dec : $1 }, 
+                    BaseStatement: BaseStatement{ Dec : $1, Span : $2.Span.Extend($<span>7) }, 
                     Ident : $2, 
                 },
                 Pos : $3,
@@ -467,18 +593,20 @@ method
     ;
 
 methods
-    : /* empty */ { $$ = nil }
-    | methods method { $$ = append($1, $2) }
+    : /* empty */ { $$ = members{} }
+    | methods method { $$ = $1; $$.methods = append($$.methods, $2) }
+    | methods reserved { $$ = $1; $$.reserved = append($$.reserved, $2...) }
+    | methods error TokenSemicolon { $$ = $1; Errflag = 0 }
     ;
 
 
 protocol
-    : decorators TokenProtocol identifier protoModifiers uniqueID 
+    : decorators TokenProtocol identifier protoModifiers uniqueIDOpt
         TokenLBrace methods TokenRBrace 
     {
         pmsp, err := NewProtocolModifiers($4)
         if err != nil {
-            parseErr = err
+            state(snowplex).setParseErr($3.Span, err)
         }
         var pms ProtocolModifiers
         if pmsp != nil {
@@ -486,12 +614,13 @@ protocol
         }
         $$ = Protocol{
             BaseTypedef : BaseTypedef{
-                BaseStatement: BaseStatement{ Dec : $1 }, 
+                BaseStatement: BaseStatement{ Dec : $1, Span : $<span>2.Extend($<span>8) }, 
                 Ident : $3, 
                 UniqueID : $5,
             },
             Modifiers : pms,
-            Methods : $7,
+            Methods : $7.methods,
+            Reserved : $7.reserved,
         }
     }
     ;
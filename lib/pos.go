@@ -0,0 +1,37 @@
+package lib
+
+import "fmt"
+
+// Pos is a location in a .snowp source file. Lines and columns are 1-based,
+// and columns count runes, not bytes. Offset is the 0-based byte offset.
+type Pos struct {
+	Line   int
+	Column int
+	Offset int
+}
+
+func (p Pos) IsValid() bool { return p.Line > 0 }
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Span is the range of source text that an AST node was parsed from. End is
+// exclusive. Nodes that were synthesized rather than parsed have a zero Span.
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+func (s Span) IsValid() bool { return s.Start.IsValid() }
+
+// Extend returns a span running from the start of s to the end of e.
+func (s Span) Extend(e Span) Span {
+	if !s.IsValid() {
+		return e
+	}
+	if !e.IsValid() {
+		return s
+	}
+	return Span{Start: s.Start, End: e.End}
+}
@@ -0,0 +1,357 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Symbol is a named declaration that a DerivedType can refer to. It points
+// back into the Root it was declared in, rather than holding a copy of the
+// Statement, so it always sees the resolved version of that statement.
+type Symbol struct {
+	Root  *Root
+	Index int // into Root.Stmts
+}
+
+func (s *Symbol) Decl() Statement { return s.Root.Stmts[s.Index] }
+
+func (s *Symbol) Name() string { return statementName(s.Decl()) }
+
+func statementName(s Statement) string {
+	switch s := s.(type) {
+	case Typedef:
+		return s.Ident.Name
+	case Struct:
+		return s.Ident.Name
+	case Variant:
+		return s.Ident.Name
+	case Enum:
+		return s.Ident.Name
+	case Protocol:
+		return s.Ident.Name
+	case Const:
+		return s.Ident.Name
+	}
+	return ""
+}
+
+// Scope is the symbol table for a set of files that share a namespace.
+type Scope struct {
+	syms map[string]*Symbol
+}
+
+func NewScope() *Scope {
+	return &Scope{syms: make(map[string]*Symbol)}
+}
+
+func (s *Scope) Lookup(name string) *Symbol {
+	return s.syms[name]
+}
+
+// Declare adds all of the named declarations in r to the scope, and reports
+// any name that is declared twice.
+func (s *Scope) Declare(r *Root) []Diagnostic {
+	var diags []Diagnostic
+	for i, stmt := range r.Stmts {
+		nm := statementName(stmt)
+		if nm == "" {
+			continue
+		}
+		if prev, found := s.syms[nm]; found {
+			diags = append(diags, Diagnostic{
+				Filename: r.Filename,
+				Pos:      stmt.GetSpan().Start,
+				Msg: fmt.Sprintf("duplicate type name %q (previously declared at %s:%s)",
+					nm, prev.Root.Filename, prev.Decl().GetSpan().Start),
+			})
+			continue
+		}
+		s.syms[nm] = &Symbol{Root: r, Index: i}
+	}
+	return diags
+}
+
+// Importer loads the package named by a generic `import` statement.
+type Importer interface {
+	ImportPackage(from *Root, imp Import) (*Package, error)
+}
+
+type resolver struct {
+	root  *Root
+	scope *Scope
+
+	// Generic imports that we could load, and foreign imports (go: and ts:)
+	// whose types we have to take on faith.
+	imports map[string]*Scope
+	foreign map[string]bool
+
+	diags []Diagnostic
+}
+
+// Resolve binds every DerivedType in r to its declaration, either in scope or
+// in a package brought in by a generic import. The scope must already contain
+// r's own declarations. If imp is nil, generic imports are treated like go:
+// imports, and types referenced through them are left unbound.
+func Resolve(r *Root, scope *Scope, imp Importer) []Diagnostic {
+	rs := &resolver{
+		root:    r,
+		scope:   scope,
+		imports: make(map[string]*Scope),
+		foreign: make(map[string]bool),
+	}
+	rs.loadImports(imp)
+	rs.run()
+	return rs.diags
+}
+
+func (r *resolver) errorf(sp Span, format string, args ...any) {
+	r.diags = append(r.diags, Diagnostic{
+		Filename: r.root.Filename,
+		Pos:      sp.Start,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// addGoImports adds a go:import after each generic import in r whose alias
+// is in goImports, which maps aliases onto Go import paths, unless r already
+// has a go:import of its own for it.
+func addGoImports(r *Root, goImports map[string]string) {
+	if len(goImports) == 0 {
+		return
+	}
+	hasGo := make(map[string]bool)
+	for _, s := range r.Stmts {
+		if i, ok := s.(Import); ok && i.Lang == LangGo {
+			hasGo[i.Name] = true
+		}
+	}
+	var stmts []Statement
+	for _, s := range r.Stmts {
+		stmts = append(stmts, s)
+		i, ok := s.(Import)
+		if !ok || i.Lang != LangGeneric || hasGo[i.Name] {
+			continue
+		}
+		if gp, ok := goImports[i.Name]; ok {
+			stmts = append(stmts, Import{Path: gp, Name: i.Name, Lang: LangGo, Span: i.Span})
+			hasGo[i.Name] = true
+		}
+	}
+	r.Stmts = stmts
+}
+
+func (r *resolver) loadImports(imp Importer) {
+	// A go: or ts: import of the same name stands in for a generic import
+	// that isn't there, since the package might only exist in the target
+	// language.
+	hasForeign := make(map[string]bool)
+	for _, s := range r.root.Stmts {
+		if i, ok := s.(Import); ok && i.Lang != LangGeneric {
+			hasForeign[i.Name] = true
+		}
+	}
+	for idx, s := range r.root.Stmts {
+		i, ok := s.(Import)
+		if !ok {
+			continue
+		}
+		if i.Lang != LangGeneric || imp == nil {
+			r.foreign[i.Name] = true
+			continue
+		}
+		pkg, err := imp.ImportPackage(r.root, i)
+		var diags Diagnostics
+		switch {
+		case errors.As(err, &diags):
+			r.errorf(i.Span, "cannot import %q due to errors in imported files", i.Path)
+			r.diags = append(r.diags, diags...)
+		case errors.Is(err, errImportNotFound) && hasForeign[i.Name]:
+		case errors.Is(err, errImportNotFound):
+			r.errorf(i.Span, "cannot import %q: %s; if it isn't a .snowp package, "+
+				"add a `go:import` or `ts:import` as %s", i.Path, err.Error(), i.Name)
+		case err != nil:
+			r.errorf(i.Span, "cannot import %q: %s", i.Path, err.Error())
+		}
+		if err != nil {
+			r.foreign[i.Name] = true
+			continue
+		}
+		r.imports[i.Name] = pkg.Scope
+		i.Pkg = pkg
+		r.root.Stmts[idx] = i
+	}
+}
+
+func (r *resolver) run() {
+	// Constants go first, so that their types are resolved by the time
+	// they're used in Blob sizes.
+	for i, s := range r.root.Stmts {
+		if c, ok := s.(Const); ok {
+			c.Type = r.resolveType(c.Type)
+			r.root.Stmts[i] = c
+		}
+	}
+	for i, s := range r.root.Stmts {
+		switch s := s.(type) {
+		case Typedef:
+			s.Type = r.resolveType(s.Type)
+			r.root.Stmts[i] = s
+		case Struct:
+			r.resolveFields(s.Fields)
+		case Variant:
+			s.SwitchType = r.resolveType(s.SwitchType)
+			for j := range s.Cases {
+				s.Cases[j].Type = r.resolveType(s.Cases[j].Type)
+			}
+			r.root.Stmts[i] = s
+		case Enum:
+			r.resolveEnum(s)
+		case Protocol:
+			r.resolveProtocol(&s)
+			r.root.Stmts[i] = s
+		}
+	}
+}
+
+func (r *resolver) resolveEnum(e Enum) {
+	for i, v := range e.Values {
+		if v.NumConst.Name == "" {
+			continue
+		}
+		n, ok := r.constNumber(v.NumConst)
+		switch {
+		case !ok:
+		case n < 0:
+			r.errorf(v.NumConst.Span, "enum value %s must not be negative", v.Ident.Name)
+		default:
+			e.Values[i].Num = n
+		}
+	}
+}
+
+func (r *resolver) resolveFields(fields []Field) {
+	for i := range fields {
+		fields[i].Type = r.resolveType(fields[i].Type)
+	}
+}
+
+func (r *resolver) resolveProtocol(p *Protocol) {
+	mods := &p.Modifiers
+	mods.Errors.Type = r.resolveType(mods.Errors.Type)
+	if mods.ArgHeader != nil {
+		mods.ArgHeader.Type = r.resolveType(mods.ArgHeader.Type)
+	}
+	if mods.ResHeader != nil {
+		mods.ResHeader.Type = r.resolveType(mods.ResHeader.Type)
+	}
+	for i := range p.Methods {
+		m := &p.Methods[i]
+		for j := range m.Params {
+			m.Params[j].Type = r.resolveType(m.Params[j].Type)
+		}
+		m.ResType = r.resolveType(m.ResType)
+	}
+}
+
+func (r *resolver) resolveType(t Type) Type {
+	return mapTypes(t, func(t Type) Type {
+		switch t := t.(type) {
+		case DerivedType:
+			t.Sym = r.lookup(t)
+			return t
+		case Blob:
+			return r.resolveBlob(t)
+		}
+		return t
+	})
+}
+
+func (r *resolver) resolveBlob(b Blob) Blob {
+	if b.CountConst.Name == "" {
+		return b
+	}
+	n, ok := r.constNumber(b.CountConst)
+	switch {
+	case !ok:
+	case n <= 0:
+		r.errorf(b.CountConst.Span, "blob byte-count must be greater than 0")
+	default:
+		b.Count = n
+	}
+	return b
+}
+
+// constNumber looks up the integer constant named by id.
+func (r *resolver) constNumber(id Identifier) (int, bool) {
+	sym := r.scope.Lookup(id.Name)
+	if sym == nil {
+		r.errorf(id.Span, "undefined constant %q", id.Name)
+		return 0, false
+	}
+	c, ok := sym.Decl().(Const)
+	if !ok {
+		r.errorf(id.Span, "%q is not a constant", id.Name)
+		return 0, false
+	}
+	_, isBlob := Underlying(c.Type).(Blob)
+	if isBlob || (c.Value.Kind != LiteralInt && c.Value.Kind != LiteralHex) {
+		r.errorf(id.Span, "constant %s is not an integer", id.Name)
+		return 0, false
+	}
+	n, err := c.Value.Int64()
+	if err != nil {
+		r.errorf(id.Span, "constant %s is out of range", id.Name)
+		return 0, false
+	}
+	return int(n), true
+}
+
+func (r *resolver) lookup(d DerivedType) *Symbol {
+	scope := r.scope
+	if imp := d.ImportedFrom.Name; imp != "" {
+		scope = r.imports[imp]
+		if scope == nil && r.foreign[imp] {
+			return nil
+		}
+		if scope == nil {
+			r.errorf(d.ImportedFrom.Span, "unknown import %q in type %s",
+				imp, d.FullTypeName())
+			return nil
+		}
+	}
+	sym := scope.Lookup(d.Name.Name)
+	if sym == nil {
+		r.errorf(d.Span, "undefined type %q", d.FullTypeName())
+		return nil
+	}
+	switch sym.Decl().(type) {
+	case Protocol:
+		r.errorf(d.Span, "%q is a protocol, not a type", d.FullTypeName())
+		return nil
+	case Const:
+		r.errorf(d.Span, "%q is a constant, not a type", d.FullTypeName())
+		return nil
+	}
+	return sym
+}
+
+// mapTypes rebuilds t, replacing each type inside of it that doesn't contain
+// other types with the result of f.
+func mapTypes(t Type, f func(Type) Type) Type {
+	switch t := t.(type) {
+	case List:
+		t.Type = mapTypes(t.Type, f)
+		return t
+	case Option:
+		t.Type = mapTypes(t.Type, f)
+		return t
+	case Future:
+		t.Type = mapTypes(t.Type, f)
+		return t
+	case Map:
+		t.Key = mapTypes(t.Key, f)
+		t.Value = mapTypes(t.Value, f)
+		return t
+	}
+	return f(t)
+}
@@ -1,24 +1,312 @@
 package lib
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
 type Runner struct {
 	opts *Options
+
+	// only, if set, limits the run to the packages in these directories,
+	// which are absolute. Watch mode uses it to rebuild just what changed.
+	only map[string]bool
+
+	// imports maps the directory of each package that was compiled to the
+	// directories of the packages it imports, all absolute.
+	imports map[string][]string
+
+	// manifest, if set, is used to skip packages that haven't changed since
+	// the last run, and is updated after this one. Only gen uses it.
+	manifest *Manifest
+}
+
+// parallel calls f(0) through f(n-1), on up to jobs goroutines at once. The
+// errors come back indexed the same way, so however the work got scheduled,
+// the caller can report them in a stable order.
+func parallel(jobs int, n int, f func(i int) error) []error {
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = f(i)
+			}
+		}()
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// collectErrors merges per-file errors, in order, into one. Diagnostics are
+// combined into a single list; if there are any other kinds of errors, they
+// come first.
+func collectErrors(errs []error) error {
+	var diags Diagnostics
+	var others []error
+	for _, err := range errs {
+		var ds Diagnostics
+		switch {
+		case err == nil:
+		case errors.As(err, &ds):
+			diags = append(diags, ds...)
+		default:
+			others = append(others, err)
+		}
+	}
+	if len(others) > 0 {
+		if len(diags) > 0 {
+			others = append(others, diags)
+		}
+		return errors.Join(others...)
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+	return nil
 }
 
 func NewRunner(o *Options) *Runner {
 	return &Runner{opts: o}
 }
 
+// Run compiles the input files as one package and writes out the results.
 func (r *Runner) Run() error {
-	fs := &FileSet{}
-	err := fs.Build(r.opts)
+	if r.opts.indir != "" {
+		r.manifest = loadManifest(r.opts)
+	}
+	mds, err := r.compile()
 	if err != nil {
 		return err
 	}
-	for _, fp := range fs.files {
-		err = fp.run(r.opts)
+	for _, md := range mds {
+		if md.lang == LangGo && md.pkg == "" {
+			return fmt.Errorf("must specify package name for %s, with -p or go:package",
+				md.infile.Name())
+		}
+	}
+
+	// Only touch the output files once everything has compiled, so a
+	// mistake doesn't leave a half-updated package behind.
+	err = collectErrors(parallel(r.opts.jobs, len(mds), func(i int) error {
+		return mds[i].emit()
+	}))
+	if err != nil {
+		return err
+	}
+	for _, md := range mds {
+		md.logWrite()
+		err = md.write()
 		if err != nil {
 			return err
 		}
 	}
+	if r.manifest == nil {
+		return nil
+	}
+	for _, md := range mds {
+		r.manifest.noteInput(md)
+	}
+	for _, md := range mds {
+		r.manifest.record(md, md.deps)
+	}
+	return r.manifest.save()
+}
+
+// Check compiles the input files, but doesn't generate any code.
+func (r *Runner) Check() error {
+	_, err := r.compile()
+	return err
+}
+
+// compile parses and checks the input files. Each package's files are all
+// parsed and declared into a shared scope before any is resolved, so files
+// can refer to each other's types without importing them. Warnings go to
+// stderr; errors come back as Diagnostics.
+func (r *Runner) compile() ([]*Metadata, error) {
+	fs := &FileSet{}
+	err := fs.Build(r.opts)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []*PackageFiles
+	for _, pf := range fs.pkgs {
+		if r.manifest != nil {
+			r.manifest.see(pf)
+		}
+		switch {
+		case r.only != nil && !r.only[absOr(pf.dir)]:
+		case r.manifest != nil && r.manifest.fresh(pf):
+			r.logFresh(pf)
+		default:
+			pkgs = append(pkgs, pf)
+		}
+	}
+
+	pkgMds := make([][]*Metadata, len(pkgs))
+	var mds []*Metadata
+	for i, pf := range pkgs {
+		for _, fp := range pf.files {
+			md := NewMetadata(&fp, r.opts)
+			pkgMds[i] = append(pkgMds[i], md)
+			mds = append(mds, md)
+		}
+	}
+	err = collectErrors(parallel(r.opts.jobs, len(mds), func(i int) error {
+		return mds[i].parse()
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	// One loader for the whole tree, so that packages in it that import
+	// each other are only loaded once, and know where each other's
+	// generated code is. Checking goes one package at a time, through that
+	// loader; it's quick next to parsing and emitting.
+	loader := NewLoader(r.opts.importPath, r.opts.ext)
+	for _, pf := range fs.pkgs {
+		if pf.outdir != "" {
+			loader.SetOutputDir(pf.dir, pf.outdir)
+		}
+	}
+	var diags Diagnostics
+	for i, pf := range pkgs {
+		diags = append(diags, r.checkPackage(pf, pkgMds[i], loader)...)
+	}
+
+	sortDiagnostics(diags)
+	errs, warns := splitWarnings(diags)
+	if len(errs) > 0 {
+		return nil, Diagnostics(diags)
+	}
+	if len(warns) > 0 {
+		fmt.Fprintln(os.Stderr, Diagnostics(warns).Error())
+	}
+	return mds, nil
+}
+
+// logFresh says that pf is being skipped, if asked to.
+func (r *Runner) logFresh(pf *PackageFiles) {
+	if !r.opts.verbose {
+		return
+	}
+	for _, fp := range pf.files {
+		fmt.Fprintf(os.Stderr, "✅ %s is up to date\n", fp.infile.Name())
+	}
+}
+
+// checkPackage declares, resolves and checks the parsed files of one
+// package, and settles what its Go package is called.
+func (r *Runner) checkPackage(pf *PackageFiles, mds []*Metadata, loader *Loader) []Diagnostic {
+	var roots []*Root
+	for _, md := range mds {
+		roots = append(roots, md.root)
+	}
+	pkg, diags := NewPackage(pf.dir, roots)
+	loader.Add(pf.key, pkg)
+	for _, md := range mds {
+		diags = append(diags, md.check(pkg.Scope, loader)...)
+	}
+	loader.Done(pf.key)
+	deps := packageDeps(pkg)
+	for _, md := range mds {
+		md.deps = deps
+	}
+
+	if r.imports == nil {
+		r.imports = make(map[string][]string)
+	}
+	dir := absOr(pf.dir)
+	r.imports[dir] = nil
+	for _, md := range mds {
+		for _, s := range md.root.Stmts {
+			if i, ok := s.(Import); ok && i.Pkg != nil {
+				r.imports[dir] = append(r.imports[dir], absOr(i.Pkg.Dir))
+			}
+		}
+	}
+
+	if r.opts.lang == LangGo {
+		diags = append(diags, goPackageFor(pf, mds)...)
+	}
+	return diags
+}
+
+// goPackageFor names the Go package that mds compile into: whatever their
+// go:package directives say, which have to agree, or else what the Runner
+// picked for their directory.
+func goPackageFor(pf *PackageFiles, mds []*Metadata) []Diagnostic {
+	var diags []Diagnostic
+	var first *GoPackage
+	var firstFile string
+	for _, md := range mds {
+		// The checker complains about any more than one per file.
+		gp := fileGoPackage(md.root)
+		switch {
+		case gp == nil:
+		case first == nil:
+			first, firstFile = gp, md.root.Filename
+		case gp.Name.Name != first.Name.Name:
+			diags = append(diags, Diagnostic{
+				Filename: md.root.Filename,
+				Pos:      gp.Span.Start,
+				Msg: fmt.Sprintf("go:package %s doesn't match go:package %s in %s",
+					gp.Name.Name, first.Name.Name, firstFile),
+			})
+		}
+	}
+	name := pf.pkg
+	if first != nil {
+		name = first.Name.Name
+	}
+	for _, md := range mds {
+		md.pkg = name
+	}
+	return diags
+}
+
+func fileGoPackage(r *Root) *GoPackage {
+	for _, s := range r.Stmts {
+		if gp, ok := s.(GoPackage); ok {
+			return &gp
+		}
+	}
 	return nil
 }
+
+// RunCheck checks each of paths, which are .snowp files or directories of
+// them, as a package of its own. It reports the errors from all of them
+// together. With no paths, it checks stdin.
+func RunCheck(o *Options, paths []string) error {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var errs []error
+	for _, p := range paths {
+		po := *o
+		if isDir(p) {
+			po.indir = p
+		} else {
+			po.infile = p
+		}
+		err := po.checkInput()
+		if err != nil {
+			return err
+		}
+		errs = append(errs, NewRunner(&po).Check())
+	}
+	return collectErrors(errs)
+}
@@ -0,0 +1,198 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs int
+		n    int
+		max  int // most calls we expect to see at once
+	}{
+		{name: "one job", jobs: 1, n: 20, max: 1},
+		{name: "a few jobs", jobs: 3, n: 20, max: 3},
+		{name: "more jobs than work", jobs: 50, n: 4, max: 4},
+		{name: "one per CPU", jobs: 0, n: 20, max: runtime.GOMAXPROCS(0)},
+		{name: "no work", jobs: 4, n: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := make([]int, tt.n)
+			var running, most atomic.Int32
+			errs := parallel(tt.jobs, tt.n, func(i int) error {
+				now := running.Add(1)
+				defer running.Add(-1)
+				for {
+					m := most.Load()
+					if now <= m || most.CompareAndSwap(m, now) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				calls[i]++
+				mu.Unlock()
+				if i%3 == 0 {
+					return fmt.Errorf("error %d", i)
+				}
+				return nil
+			})
+			if len(errs) != tt.n {
+				t.Fatalf("got %d errors; want %d", len(errs), tt.n)
+			}
+			for i := range tt.n {
+				if calls[i] != 1 {
+					t.Errorf("f(%d) called %d times", i, calls[i])
+				}
+				var want string
+				if i%3 == 0 {
+					want = fmt.Sprintf("error %d", i)
+				}
+				if got := fmt.Sprint(errs[i]); (errs[i] == nil) != (want == "") || (want != "" && got != want) {
+					t.Errorf("errs[%d] = %v; want %q", i, errs[i], want)
+				}
+			}
+			if m := int(most.Load()); m > tt.max {
+				t.Errorf("%d calls at once; want at most %d", m, tt.max)
+			}
+		})
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	d := func(file string, off int) Diagnostic {
+		return Diagnostic{Filename: file, Pos: Pos{Line: 1, Column: off + 1, Offset: off}, Msg: "bad"}
+	}
+	tests := []struct {
+		name string
+		errs []error
+		want string
+	}{
+		{name: "none", errs: []error{nil, nil}},
+		{
+			name: "diagnostics, in order",
+			errs: []error{Diagnostics{d("b", 0)}, nil, Diagnostics{d("a", 0), d("a", 4)}},
+			want: "b:1:1: bad\na:1:1: bad\na:1:5: bad",
+		},
+		{
+			name: "other errors first",
+			errs: []error{Diagnostics{d("a", 0)}, errors.New("disk full"), nil, errors.New("no such file")},
+			want: "disk full\nno such file\na:1:1: bad",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := collectErrors(tt.errs)
+			if got := fmt.Sprint(err); (err == nil) != (tt.want == "") || (err != nil && got != tt.want) {
+				t.Errorf("got:\n%v\nwant:\n%s", err, tt.want)
+			}
+		})
+	}
+}
+
+// captureStderr returns what f writes to stderr.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	done := make(chan string)
+	go func() {
+		dat, _ := io.ReadAll(r)
+		done <- string(dat)
+	}()
+	defer func() {
+		os.Stderr = stderr
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+// TestRunOrder makes sure that a run with many jobs reports errors, and
+// logs what it writes, in the same order every time.
+func TestRunOrder(t *testing.T) {
+	const nFiles = 24
+	good := make(map[string]string)
+	bad := make(map[string]string)
+	for i := range nFiles {
+		nm := fmt.Sprintf("in/p%d/f%02d.snowp", i%3, i)
+		src := fmt.Sprintf("@0x8a9f2b3c4d5e6f%02x;\nstruct S%d { x @0 : Uint; }", i, i)
+		good[nm] = src
+		if i%2 == 0 {
+			src = fmt.Sprintf("@0x8a9f2b3c4d5e6f%02x;\nstruct S%d { x @0 : T%d; y @1 : ; }", i, i, i)
+		}
+		bad[nm] = src
+	}
+	run := func(files map[string]string) (string, error) {
+		dir := t.TempDir()
+		writeFiles(t, dir, files)
+		if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		o := &Options{
+			langRaw: "go",
+			indir:   filepath.Join(dir, "in"),
+			outdir:  filepath.Join(dir, "out"),
+			ext:     ".snowp",
+			jobs:    8,
+			verbose: true,
+		}
+		if err := o.check(); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		log := captureStderr(t, func() { err = NewRunner(o).Run() })
+		log = strings.ReplaceAll(log, dir+string(filepath.Separator), "")
+		if err != nil {
+			return log, errors.New(strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), ""))
+		}
+		return log, nil
+	}
+
+	firstLog, err := run(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(firstLog, "🏗️"); n != nFiles {
+		t.Errorf("logged %d writes; want %d:\n%s", n, nFiles, firstLog)
+	}
+	if !strings.HasPrefix(firstLog, "🏗️  in/p0/f00.snowp → out/p0/f00.go\n🏗️  in/p0/f03.snowp → out/p0/f03.go\n") {
+		t.Errorf("log isn't in order:\n%s", firstLog)
+	}
+	_, firstErr := run(bad)
+	if firstErr == nil {
+		t.Fatal("no error")
+	}
+	// Every bad file is a syntax error, and they all get reported.
+	if n := strings.Count(firstErr.Error(), "syntax error"); n != nFiles/2 {
+		t.Errorf("got %d errors; want %d:\n%v", n, nFiles/2, firstErr)
+	}
+	if !strings.HasPrefix(firstErr.Error(), "in/p0/f00.snowp:2:") {
+		t.Errorf("errors aren't in order:\n%v", firstErr)
+	}
+	for range 5 {
+		if log, _ := run(good); log != firstLog {
+			t.Fatalf("log changed:\n%s\nwas:\n%s", log, firstLog)
+		}
+		if _, err := run(bad); err == nil || err.Error() != firstErr.Error() {
+			t.Fatalf("errors changed:\n%v\nwere:\n%v", err, firstErr)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// fileStamp is what we compare to tell if a file changed between polls.
+type fileStamp struct {
+	mtime time.Time
+	size  int64
+	dir   string // the directory of the package the file is in
+}
+
+// watcher polls the input files and rebuilds the packages that change,
+// along with every package that imports them, directly or not.
+type watcher struct {
+	opts *Options
+	log  io.Writer
+
+	stamps  map[string]fileStamp
+	imports map[string][]string // as in Runner
+
+	// pending are the packages that have changed since the last build that
+	// worked; when one fails, nothing is written, so they need doing again.
+	pending map[string]bool
+
+	// full is set until a build of everything works. Before then, some
+	// packages might never have been written, and imports is incomplete, so
+	// building just what changed isn't enough.
+	full bool
+}
+
+func newWatcher(o *Options, log io.Writer) *watcher {
+	return &watcher{
+		opts:    o,
+		log:     log,
+		stamps:  make(map[string]fileStamp),
+		imports: make(map[string][]string),
+		pending: make(map[string]bool),
+		full:    true,
+	}
+}
+
+// RunWatch builds everything, and then rebuilds whatever changes, until ctx
+// is done. Errors are written to log rather than returned, so that a mistake
+// doesn't stop the watch.
+func RunWatch(ctx context.Context, o *Options, log io.Writer) error {
+	w := newWatcher(o, log)
+	w.scan()
+	w.build()
+	fmt.Fprintf(log, "snowpc: watching for changes; press Ctrl-C to stop\n")
+
+	tick := time.NewTicker(o.watchInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+		changed := w.scan()
+		if len(changed) == 0 {
+			continue
+		}
+		w.rebuild(changed)
+	}
+}
+
+// rebuild builds the packages in dirs, along with whatever else still needs
+// it.
+func (w *watcher) rebuild(dirs map[string]bool) {
+	for d := range dirs {
+		w.pending[d] = true
+	}
+	w.build()
+}
+
+// scan stats every input file, and returns the directories of the packages
+// in which files were added, removed or changed since the last scan.
+func (w *watcher) scan() map[string]bool {
+	fs := &FileSet{}
+	err := fs.Build(w.opts)
+	if err != nil {
+		fmt.Fprintln(w.log, err.Error())
+		return nil
+	}
+	changed := make(map[string]bool)
+	stamps := make(map[string]fileStamp)
+	for _, pf := range fs.pkgs {
+		dir := absOr(pf.dir)
+		for _, fp := range pf.files {
+			nm := fp.infile.name
+			st, err := os.Stat(nm)
+			if err != nil {
+				// It's probably mid-save; look again next time.
+				changed[dir] = true
+				continue
+			}
+			s := fileStamp{mtime: st.ModTime(), size: st.Size(), dir: dir}
+			stamps[nm] = s
+			if old, ok := w.stamps[nm]; !ok || old != s {
+				changed[dir] = true
+			}
+		}
+	}
+	for nm, s := range w.stamps {
+		if _, ok := stamps[nm]; !ok {
+			changed[s.dir] = true
+		}
+	}
+	w.stamps = stamps
+	return changed
+}
+
+// withImporters adds to dirs every package that imports one of them, as of
+// the last build.
+func (w *watcher) withImporters(dirs map[string]bool) map[string]bool {
+	ret := make(map[string]bool)
+	for d := range dirs {
+		ret[d] = true
+	}
+	for grew := true; grew; {
+		grew = false
+		for pkg, imps := range w.imports {
+			if ret[pkg] {
+				continue
+			}
+			for _, imp := range imps {
+				if ret[imp] {
+					ret[pkg] = true
+					grew = true
+					break
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// build runs the compiler over the pending packages and those that import
+// them, or over everything if no full build has worked yet.
+func (w *watcher) build() {
+	r := NewRunner(w.opts)
+	if !w.full {
+		r.only = w.withImporters(w.pending)
+	}
+	err := r.Run()
+	stamp := time.Now().Format("15:04:05")
+	if err != nil {
+		fmt.Fprintln(w.log, err.Error())
+		fmt.Fprintf(w.log, "snowpc: %s build failed\n", stamp)
+		return
+	}
+	for pkg, imps := range r.imports {
+		w.imports[pkg] = imps
+	}
+	w.pending = make(map[string]bool)
+	w.full = false
+	fmt.Fprintf(w.log, "snowpc: %s build ok\n", stamp)
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWatchAfterFailedBuild starts watching a tree that doesn't build, and
+// makes sure that fixing it builds everything, not just what was fixed.
+func TestWatchAfterFailedBuild(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, manifestTree)
+	writeFiles(t, dir, map[string]string{"in/lib/b.snowp": "@0x8a9f2b3c4d5e6f72;\nstruct B {"})
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	o := manifestOptions(dir)
+	if err := o.check(); err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	w := newWatcher(o, &log)
+	w.scan()
+	w.build()
+	if !strings.Contains(log.String(), "build failed") {
+		t.Fatalf("first build didn't fail:\n%s", log.String())
+	}
+
+	exists := func(nm string) bool {
+		_, err := os.Stat(filepath.Join(dir, "out", nm))
+		return err == nil
+	}
+	outputs := []string{"lib/b.go", "other/c.go", "top/a.go", "top/a2.go"}
+
+	writeFiles(t, dir, map[string]string{"in/lib/b.snowp": manifestTree["in/lib/b.snowp"]})
+	w.rebuild(w.scan())
+	for _, nm := range outputs {
+		if !exists(nm) {
+			t.Errorf("%s wasn't written once the tree built", nm)
+		}
+	}
+
+	// Now that a build has worked, a change only rebuilds its package and
+	// those that import it.
+	for _, nm := range []string{"other/c.go", "top/a.go"} {
+		if err := os.Remove(filepath.Join(dir, "out", nm)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, dir, map[string]string{"in/lib/b.snowp": manifestTree["in/lib/b.snowp"] + "\n// more"})
+	w.rebuild(w.scan())
+	if !exists("top/a.go") {
+		t.Errorf("top, which imports lib, wasn't rebuilt")
+	}
+	if exists("other/c.go") {
+		t.Errorf("other was rebuilt, though nothing it uses changed")
+	}
+}
@@ -23,16 +23,7 @@ func debugStop() {
 func mainWithErr() error {
 	debugStop()
 
-	opts, err := lib.ParseOpts()
-	if err != nil {
-		return err
-	}
-	err = opts.Run()
-	if err != nil {
-		return err
-	}
-	return nil
-
+	return lib.Execute()
 }
 
 func main() {